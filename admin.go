@@ -0,0 +1,252 @@
+package jwt_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+var (
+	signersMu sync.RWMutex
+	signers   = map[*JwtSigner]struct{}{}
+)
+
+func registerSigner(s *JwtSigner) {
+	signersMu.Lock()
+	defer signersMu.Unlock()
+
+	signers[s] = struct{}{}
+}
+
+func unregisterSigner(s *JwtSigner) {
+	signersMu.Lock()
+	defer signersMu.Unlock()
+
+	delete(signers, s)
+}
+
+func provisionedSigners(name string) []*JwtSigner {
+	signersMu.RLock()
+	defer signersMu.RUnlock()
+
+	list := make([]*JwtSigner, 0, len(signers))
+	for s := range signers {
+		if name == "" || s.Name == name {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+type adminAPI struct{}
+
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.jwt_signer",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+func (a adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/jwt_signer/schema", Handler: caddy.AdminHandlerFunc(a.handleSchema)},
+		{Pattern: "/jwt_signer/config", Handler: caddy.AdminHandlerFunc(a.handleConfig)},
+		{Pattern: "/jwt_signer/revoke", Handler: caddy.AdminHandlerFunc(a.handleRevoke)},
+	}
+}
+
+type schemaEntry struct {
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+func (adminAPI) handleSchema(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	entries := []schemaEntry{}
+
+	for _, s := range provisionedSigners(r.URL.Query().Get("name")) {
+		schema, err := s.GenerateClaimsSchema()
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusInternalServerError,
+				Err:        fmt.Errorf("generating claims schema: %w", err),
+			}
+		}
+
+		entries = append(entries, schemaEntry{Name: s.Name, Schema: schema})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entries)
+}
+
+type configEntry struct {
+	Name   string         `json:"name,omitempty"`
+	Config map[string]any `json:"config"`
+}
+
+// handleConfig returns the effective configuration of provisioned signers, defaults filled in and secrets masked.
+func (adminAPI) handleConfig(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	entries := []configEntry{}
+
+	for _, s := range provisionedSigners(r.URL.Query().Get("name")) {
+		cfg, err := s.exportConfig()
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusInternalServerError,
+				Err:        fmt.Errorf("exporting config: %w", err),
+			}
+		}
+
+		entries = append(entries, configEntry{Name: s.Name, Config: cfg})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// sensitiveConfigKeys name the config fields holding secrets or credentials, masked wherever they appear.
+var sensitiveConfigKeys = map[string]bool{
+	"secret":         true,
+	"scoped_secrets": true,
+	"client_secret":  true,
+	"secret_hash":    true,
+	"token":          true,
+	"hash_claim_key": true,
+}
+
+const maskedValue = "REDACTED"
+
+func (s *JwtSigner) exportConfig() (map[string]any, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg map[string]any
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+
+	maskSecrets(cfg)
+
+	return cfg, nil
+}
+
+func maskSecrets(v any) {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			if sensitiveConfigKeys[k] {
+				val[k] = maskValue(child)
+			} else {
+				maskSecrets(child)
+			}
+		}
+	case []any:
+		for _, child := range val {
+			maskSecrets(child)
+		}
+	}
+}
+
+// maskValue hides every non-empty string below v, map keys such as the scopes of scoped secrets stay visible.
+func maskValue(v any) any {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		if val == "" {
+			return val
+		}
+
+		return maskedValue
+	case map[string]any:
+		for k, child := range val {
+			val[k] = maskValue(child)
+		}
+
+		return val
+	case []any:
+		for i, child := range val {
+			val[i] = maskValue(child)
+		}
+
+		return val
+	default:
+		return maskedValue
+	}
+}
+
+type revokeRequest struct {
+	JTI string `json:"jti"`
+	Exp int64  `json:"exp,omitempty"`
+}
+
+func (adminAPI) handleRevoke(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	var req revokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JTI == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("expected a JSON object with a jti"),
+		}
+	}
+
+	lists := map[*revocationList]struct{}{}
+
+	for _, s := range provisionedSigners(r.URL.Query().Get("name")) {
+		if s.Revocation != nil && s.Revocation.list != nil {
+			lists[s.Revocation.list] = struct{}{}
+		}
+	}
+
+	if len(lists) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("no jwt_signer with revocation configured"),
+		}
+	}
+
+	for list := range lists {
+		if err := list.revoke(r.Context(), req.JTI, req.Exp); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusInternalServerError,
+				Err:        fmt.Errorf("storing revocation: %w", err),
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+var _ caddy.AdminRouter = adminAPI{}
@@ -0,0 +1,158 @@
+package jwt_signer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+func (s *JwtSigner) provisionAlgorithmKeys() error {
+	if len(s.AlgorithmKeys) == 0 {
+		return nil
+	}
+
+	s.algorithmKeys = make(map[string]*signingKey, len(s.AlgorithmKeys))
+
+	for alg, path := range s.AlgorithmKeys {
+		key, err := s.loadKeyFileTested(path)
+		if err != nil {
+			return fmt.Errorf("loading key for algorithm %s: %w", alg, err)
+		}
+
+		if key.method.Alg() != alg {
+			return fmt.Errorf("algorithm_keys %s holds a key for %s", alg, key.method.Alg())
+		}
+
+		s.algorithmKeys[alg] = key
+		s.checkKeyStrength("algorithm_keys "+alg, key.method, key.sign)
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) validateAlgorithmAllow() error {
+	if len(s.AlgorithmAllow) == 0 {
+		if len(s.AlgorithmKeys) > 0 {
+			return fmt.Errorf("algorithm_keys requires algorithm_allow")
+		}
+
+		return nil
+	}
+
+	if s.Algorithm == "" {
+		return fmt.Errorf("algorithm_allow requires an algorithm")
+	}
+
+	if s.KeyFile != "" || s.Tenant != "" || s.Vault != nil || len(s.ScopedSecrets) > 0 || len(s.PreviousSecrets) > 0 {
+		return fmt.Errorf("algorithm_allow cannot be combined with key_file, tenant, vault, scoped_secrets or previous_secrets")
+	}
+
+	for _, alg := range s.AlgorithmAllow {
+		if _, ok := s.AlgorithmKeys[alg]; ok {
+			continue
+		}
+
+		if _, err := resolveHMACMethod(alg); err != nil {
+			if errors.Is(err, errNoneAlgorithm) {
+				return err
+			}
+
+			return fmt.Errorf("algorithm_allow %s requires a key in algorithm_keys", alg)
+		}
+
+		if s.Secret == "" {
+			return fmt.Errorf("algorithm_allow %s requires a secret", alg)
+		}
+	}
+
+	for alg := range s.AlgorithmKeys {
+		if !slices.Contains(s.AlgorithmAllow, alg) {
+			return fmt.Errorf("algorithm_keys %s is not in algorithm_allow", alg)
+		}
+	}
+
+	if !strings.Contains(s.Algorithm, "{") && !slices.Contains(s.AlgorithmAllow, s.Algorithm) {
+		return fmt.Errorf("algorithm %s is not in algorithm_allow", s.Algorithm)
+	}
+
+	return nil
+}
+
+// keyedAlgorithmsOnly tells whether every allowed algorithm has its own key, so no secret is needed.
+func (s *JwtSigner) keyedAlgorithmsOnly() bool {
+	if len(s.AlgorithmAllow) == 0 {
+		return false
+	}
+
+	for _, alg := range s.AlgorithmAllow {
+		if _, ok := s.AlgorithmKeys[alg]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allowedAlgorithmKey resolves the algorithm for the request and refuses any not in algorithm_allow, so a
+// placeholder can never select none or an algorithm without a configured key.
+func (s *JwtSigner) allowedAlgorithmKey(repl *caddy.Replacer) (*signingKey, error) {
+	alg := strings.TrimSpace(repl.ReplaceAll(s.Algorithm, ""))
+	if alg == "" || strings.EqualFold(alg, jwt.SigningMethodNone.Alg()) {
+		return nil, errNoneAlgorithm
+	}
+
+	if !slices.Contains(s.AlgorithmAllow, alg) {
+		return nil, fmt.Errorf("algorithm %s is not in algorithm_allow", alg)
+	}
+
+	s.l.Debug("Selected algorithm", zap.String("alg", alg))
+
+	if key, ok := s.algorithmKeys[alg]; ok {
+		return key, nil
+	}
+
+	secret := repl.ReplaceAll(s.Secret, "")
+	if secret == "" {
+		return nil, fmt.Errorf("required parameter empty after replacements: secret")
+	}
+
+	method, err := resolveHMACMethod(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	return hmacKey(method, secret), nil
+}
+
+func (s *JwtSigner) parseAlgorithmKeysCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	if s.AlgorithmKeys == nil {
+		s.AlgorithmKeys = map[string]string{}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		alg := d.Val()
+
+		var path string
+		if !d.Args(&path) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.AlgorithmKeys[alg] = path
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	presetAssertion = "assertion"
+
+	// maxAssertionLifetime is what common token endpoints accept for client assertions.
+	maxAssertionLifetime = 5 * time.Minute
+)
+
+// provisionPreset fills in what the preset implies. The assertion preset produces RFC 7523 client assertions:
+// sub defaults to iss, which is the client id, and the lifetime is capped.
+func (s *JwtSigner) provisionPreset() {
+	if s.Preset != presetAssertion {
+		return
+	}
+
+	if s.MaxDuration == 0 {
+		s.MaxDuration = caddy.Duration(maxAssertionLifetime)
+	}
+
+	if _, ok := s.Claims["sub"]; !ok {
+		if iss, ok := s.Claims["iss"]; ok {
+			if s.Claims == nil {
+				s.Claims = jwt.MapClaims{}
+			}
+
+			s.Claims["sub"] = iss
+		}
+	}
+}
+
+func (s *JwtSigner) validatePreset() error {
+	switch s.Preset {
+	case "":
+		return nil
+	case presetAssertion:
+	default:
+		return fmt.Errorf("unknown preset: %s", s.Preset)
+	}
+
+	if iss, _ := s.Claims["iss"].(string); iss == "" {
+		if _, ok := s.EnvClaims["iss"]; !ok {
+			return fmt.Errorf("assertion preset requires an iss claim holding the client id")
+		}
+	}
+
+	if aud, _ := s.Claims["aud"].(string); aud == "" && len(s.Audience) == 0 {
+		return fmt.Errorf("assertion preset requires the token endpoint as audience")
+	}
+
+	if s.MaxDuration > caddy.Duration(maxAssertionLifetime) {
+		return fmt.Errorf("assertion preset allows a max_duration of at most %s", maxAssertionLifetime)
+	}
+
+	if s.tokenCaching() {
+		return fmt.Errorf("assertion preset cannot be combined with token caching, every assertion needs a fresh jti")
+	}
+
+	if s.Verify != nil || s.Exchange != nil || s.Refresh != nil || s.Rotation != nil || s.ClientCredentials != nil ||
+		s.Vault != nil || s.SignBody != nil || s.SignResponse || s.FanOut {
+		return fmt.Errorf("assertion preset cannot be combined with other modes or fan_out")
+	}
+
+	return nil
+}
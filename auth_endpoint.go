@@ -0,0 +1,75 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// AuthEndpointConfig turns verify into a terminal endpoint for forward_auth and auth_request subrequests: a valid
+// token is answered with 204 and identity headers, anything else with 401 and a challenge as usual.
+type AuthEndpointConfig struct {
+	// Headers are set on the response, values may use placeholders such as {http.jwt_signer.claims.sub} and
+	// headers resolving empty are left out.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (ac *AuthEndpointConfig) validate() error {
+	for name := range ac.Headers {
+		if name == "" {
+			return fmt.Errorf("auth_endpoint header requires a name")
+		}
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) serveAuthEndpoint(w http.ResponseWriter, repl *caddy.Replacer) error {
+	h := w.Header()
+
+	for name, val := range s.AuthEndpoint.Headers {
+		if val = repl.ReplaceAll(val, ""); val != "" {
+			h.Set(name, val)
+		}
+	}
+
+	s.l.Debug("Answer auth subrequest", zap.Int("headers", len(s.AuthEndpoint.Headers)))
+
+	s.noStore(w)
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (ac *AuthEndpointConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "header":
+			var name, val string
+			if !d.Args(&name, &val) {
+				return d.ArgErr()
+			}
+
+			if ac.Headers == nil {
+				ac.Headers = map[string]string{}
+			}
+
+			ac.Headers[name] = val
+		default:
+			return d.Errf("unknown auth_endpoint option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
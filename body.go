@@ -0,0 +1,211 @@
+package jwt_signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRequestBodyLimit = 1 << 20
+
+	bodyContentTypeJSON = "application/json"
+	bodyContentTypeForm = "application/x-www-form-urlencoded"
+)
+
+type BodyClaimsConfig struct {
+	Map map[string]string `json:"map,omitempty"`
+}
+
+// readBody reads the request body up to the limit and puts it back so the following handlers can read it too.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("reading request body: %w", err))
+	}
+
+	if int64(len(body)) > limit {
+		return nil, caddyhttp.Error(http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", limit))
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// bodyTooLarge reports whether reading the body failed on the limit set with http.MaxBytesReader.
+func bodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func validateBodyContentType(ct string) error {
+	switch ct {
+	case "", bodyContentTypeJSON, bodyContentTypeForm:
+		return nil
+	default:
+		return fmt.Errorf("unsupported body_content_type: %s", ct)
+	}
+}
+
+// parseBody decodes the body as the expected media type, refusing requests which declare another one.
+func parseBody(r *http.Request, body []byte, contentType string) (map[string]any, error) {
+	doc := map[string]any{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return doc, nil
+	}
+
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || !strings.EqualFold(mt, contentType) {
+		return nil, caddyhttp.Error(http.StatusUnsupportedMediaType, fmt.Errorf("request body must be %s", contentType))
+	}
+
+	if contentType == bodyContentTypeForm {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("request body is not a valid form: %w", err))
+		}
+
+		for k, vals := range form {
+			doc[k] = vals[0]
+		}
+
+		return doc, nil
+	}
+
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("request body is not a JSON object: %w", err))
+	}
+
+	return doc, nil
+}
+
+func (bc *BodyClaimsConfig) validate(s *JwtSigner) error {
+	if len(bc.Map) == 0 {
+		return fmt.Errorf("claims_from_body requires the claims to map")
+	}
+
+	for _, claim := range slices.Sorted(maps.Keys(bc.Map)) {
+		if err := s.checkRequestClaim(claim); err != nil {
+			return fmt.Errorf("claims_from_body: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (bc *BodyClaimsConfig) apply(cs jwt.MapClaims, r *http.Request, limit int64, contentType string, missing missingFunc, l *zap.Logger) error {
+	body, err := readBody(r, limit)
+	if err != nil {
+		return err
+	}
+
+	doc, err := parseBody(r, body, contentType)
+	if err != nil {
+		return err
+	}
+
+	for claim, path := range bc.Map {
+		if v, ok := lookupPath(doc, path); ok && v != nil && v != "" {
+			setBodyClaim(cs, claim, v, l)
+		} else if err := missing(claim, "body field "+path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setBodyClaim(cs jwt.MapClaims, key string, v any, l *zap.Logger) {
+	if v == nil || v == "" {
+		return
+	}
+
+	l.Debug("Body value included", zap.String("claim", key))
+	cs[key] = v
+}
+
+// lookupPath walks a dotted path like user.id through nested JSON objects, a field literally named
+// like the path (as form fields may be) takes precedence.
+func lookupPath(doc map[string]any, path string) (any, bool) {
+	if v, ok := doc[path]; ok {
+		return v, true
+	}
+
+	var cur any = doc
+
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if cur, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+func (bc *BodyClaimsConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		claim := d.Val()
+
+		var path string
+		if !d.Args(&path) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		if bc.Map == nil {
+			bc.Map = map[string]string{}
+		}
+
+		bc.Map[claim] = path
+	}
+
+	return nil
+}
+
+func parseSizeArg(d *caddyfile.Dispenser) (int64, error) {
+	var sizeStr string
+	if !d.Args(&sizeStr) {
+		return 0, d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return 0, d.ArgErr()
+	}
+
+	size, err := humanize.ParseBytes(sizeStr)
+	if err != nil {
+		return 0, d.Errf("invalid size %s: %v", sizeStr, err)
+	}
+
+	return int64(size), nil
+}
@@ -0,0 +1,246 @@
+package jwt_signer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// jsonBodyOfSize builds a JSON body of exactly n bytes carrying user.id.
+func jsonBodyOfSize(n int) string {
+	const prefix, suffix = `{"user":{"id":"`, `"}}`
+	return prefix + strings.Repeat("a", n-len(prefix)-len(suffix)) + suffix
+}
+
+func TestClaimsFromBody(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub svc
+		aud api
+		options {
+			claims_from_body {
+				user user.id
+				level level
+			}
+			request_body_limit_bytes 128
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   map[string]any
+	}{
+		{
+			name: "mapped fields",
+			body: `{"user":{"id":"alice"},"level":3}`,
+			want: map[string]any{"user": "alice", "level": 3.0, "sub": "svc", "aud": "api"},
+		},
+		{
+			name: "unmapped fields",
+			body: `{"user":{"id":"alice"},"sub":"root","aud":"evil","exp":9999999999,"admin":true}`,
+			want: map[string]any{"user": "alice", "sub": "svc", "aud": "api", "admin": nil},
+		},
+		{
+			name: "empty and null values",
+			body: `{"user":{"id":""},"level":null}`,
+			want: map[string]any{"user": nil, "level": nil},
+		},
+		{
+			name: "just under the limit",
+			body: jsonBodyOfSize(128),
+			want: map[string]any{"user": strings.Repeat("a", 110)},
+		},
+		{
+			name:   "just over the limit",
+			body:   jsonBodyOfSize(129),
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "not an object",
+			body:   `["alice"]`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, repl := newTestRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+			if tt.status != 0 {
+				var he caddyhttp.HandlerError
+				if !errors.As(err, &he) || he.StatusCode != tt.status {
+					t.Fatalf("error %v, want status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cs := parseTestToken(t, repl)
+			for k, v := range tt.want {
+				if got := cs[k]; !reflect.DeepEqual(got, v) {
+					t.Errorf("%s = %v, want %v", k, got, v)
+				}
+			}
+			if exp, _ := cs["exp"].(float64); exp == 9999999999 {
+				t.Error("exp taken from the body")
+			}
+		})
+	}
+}
+
+func TestValidateClaimsFromBody(t *testing.T) {
+	tests := []struct {
+		name, cfg, wantErr string
+	}{
+		{
+			name:    "no mapping",
+			cfg:     `claims_from_body`,
+			wantErr: "claims_from_body requires the claims to map",
+		},
+		{
+			name: "registered claim",
+			cfg: `claims_from_body {
+					sub user.id
+				}`,
+			wantErr: "registered claim sub cannot be taken from the request",
+		},
+		{
+			name: "configured claim",
+			cfg: `claims_from_body {
+					role user.role
+				}`,
+			wantErr: "configured claim role cannot be taken from the request",
+		},
+		{
+			name: "other claim",
+			cfg: `claims_from_body {
+					user user.id
+				}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				role user
+				options {
+					`+tt.cfg+`
+				}
+			}`)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBodyContentType(t *testing.T) {
+	tests := []struct {
+		name, bodyType, contentType, body string
+		wantStatus                        int
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"user":{"id":"alice"}}`,
+		},
+		{
+			name:        "json with parameters",
+			contentType: "Application/JSON; charset=utf-8",
+			body:        `{"user":{"id":"alice"}}`,
+		},
+		{
+			name:        "json as text",
+			contentType: "text/plain",
+			body:        `{"user":{"id":"alice"}}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "json without content type",
+			body:       `{"user":{"id":"alice"}}`,
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "form where json is expected",
+			contentType: "application/x-www-form-urlencoded",
+			body:        `user.id=alice`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "form",
+			bodyType:    "application/x-www-form-urlencoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        `user.id=alice&user.id=bob`,
+		},
+		{
+			name:        "json where a form is expected",
+			bodyType:    "application/x-www-form-urlencoded",
+			contentType: "application/json",
+			body:        `{"user":{"id":"alice"}}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := ""
+			if tt.bodyType != "" {
+				options = "body_content_type " + tt.bodyType
+			}
+
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				options {
+					claims_from_body {
+						user user.id
+					}
+					`+options+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			err = s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+			if tt.wantStatus != 0 {
+				var he caddyhttp.HandlerError
+				if !errors.As(err, &he) || he.StatusCode != tt.wantStatus {
+					t.Fatalf("error %v, want status %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if user := parseTestToken(t, repl)["user"]; user != "alice" {
+				t.Fatalf("user = %v, want alice", user)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package jwt_signer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultTokenCacheSize bounds the token cache when token_cache_size is not set.
+const defaultTokenCacheSize = 10000
+
+var errSigningAborted = errors.New("concurrent signing of the same token aborted")
+
+type cachedToken struct {
+	cacheKey string
+	tosStr   string
+	cs       jwt.MapClaims
+	iat      time.Time
+	exp      time.Time
+}
+
+// tokenCache keeps signed tokens by a hash of their claims (minus iat and exp), so identical claim sets are
+// signed once and reused for as long as they stay valid for at least the cache TTL and, with reuse set, until that
+// fraction of their lifetime has passed. The least recently used tokens are evicted beyond max entries.
+type tokenCache struct {
+	ttl   time.Duration
+	reuse float64
+	max   int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List
+	flights map[string]*signFlight
+}
+
+// signFlight is a signing in progress for a cache key, which concurrent misses for the same key wait for.
+type signFlight struct {
+	done   chan struct{}
+	tosStr string
+	cs     jwt.MapClaims
+	err    error
+}
+
+func newTokenCache(ttl time.Duration, reuse float64, max int) *tokenCache {
+	return &tokenCache{
+		ttl:     ttl,
+		reuse:   reuse,
+		max:     max,
+		entries: map[string]*list.Element{},
+		flights: map[string]*signFlight{},
+	}
+}
+
+func (s *JwtSigner) tokenCaching() bool {
+	return s.TokenCacheTTL != 0 || s.TokenCacheReuse != 0
+}
+
+func tokenCacheKey(key *signingKey, cs jwt.MapClaims) (string, error) {
+	stripped := make(jwt.MapClaims, len(cs))
+	for k, v := range cs {
+		if k != "iat" && k != "exp" {
+			stripped[k] = v
+		}
+	}
+
+	raw, err := json.Marshal(stripped)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(key.method.Alg()))
+	h.Write([]byte{0})
+
+	// by content rather than identity, so a key loaded again, e.g. refreshed from a remote set, keeps its tokens
+	if secret, ok := key.sign.([]byte); ok {
+		h.Write(secret)
+	} else if der, err := x509.MarshalPKIXPublicKey(key.verify); err == nil {
+		h.Write(der)
+	} else {
+		_, _ = fmt.Fprintf(h, "%v", key.verify)
+	}
+
+	h.Write([]byte{0})
+
+	// keys known by a kid or certificate sign tokens with a different header
+	if len(key.header) > 0 {
+		header, err := json.Marshal(key.header)
+		if err != nil {
+			return "", err
+		}
+
+		h.Write(header)
+	}
+
+	h.Write([]byte{0})
+	h.Write(raw)
+
+	// tokens of another lifetime, be it set per request or cut short by a deadline, are not interchangeable
+	iat, _ := cs["iat"].(int64)
+	exp, _ := cs["exp"].(int64)
+	_, _ = fmt.Fprintf(h, "\x00%d", exp-iat)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// usable tells whether the entry may answer a request for a token expiring at exp, which it must not outlive, e.g.
+// when the request's deadline cut exp short.
+func (tc *tokenCache) usable(entry *cachedToken, now, exp time.Time) bool {
+	if entry.exp.After(exp) || entry.exp.Sub(now) <= tc.ttl {
+		return false
+	}
+
+	if tc.reuse == 0 {
+		return true
+	}
+
+	lifetime := entry.exp.Sub(entry.iat)
+
+	return now.Before(entry.iat.Add(time.Duration(float64(lifetime) * tc.reuse)))
+}
+
+func (tc *tokenCache) get(cacheKey string, exp time.Time) (*cachedToken, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	elem, ok := tc.entries[cacheKey]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cachedToken)
+	if !tc.usable(entry, time.Now(), exp) {
+		tc.lru.Remove(elem)
+		delete(tc.entries, cacheKey)
+
+		return nil, false
+	}
+
+	tc.lru.MoveToFront(elem)
+
+	return entry, true
+}
+
+func (tc *tokenCache) put(cacheKey string, tosStr string, cs jwt.MapClaims) {
+	iat, ok := cs["iat"].(int64)
+	if !ok {
+		return
+	}
+
+	exp, ok := cs["exp"].(int64)
+	if !ok {
+		return
+	}
+
+	entry := &cachedToken{cacheKey: cacheKey, tosStr: tosStr, cs: cs, iat: time.Unix(iat, 0), exp: time.Unix(exp, 0)}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if elem, ok := tc.entries[cacheKey]; ok {
+		elem.Value = entry
+		tc.lru.MoveToFront(elem)
+
+		return
+	}
+
+	tc.entries[cacheKey] = tc.lru.PushFront(entry)
+
+	for len(tc.entries) > tc.max {
+		oldest := tc.lru.Back()
+		tc.lru.Remove(oldest)
+		delete(tc.entries, oldest.Value.(*cachedToken).cacheKey)
+	}
+}
+
+// sign calls fn on a cache miss and caches its token. Concurrent misses for the same key do not sign again but wait
+// for the first one and share its token or error, reported by shared. The flights belong to the handler's cache,
+// so different handlers never share a result.
+func (tc *tokenCache) sign(cacheKey string, fn func() (string, jwt.MapClaims, error)) (string, jwt.MapClaims, bool, error) {
+	tc.mu.Lock()
+	if f, ok := tc.flights[cacheKey]; ok {
+		tc.mu.Unlock()
+		<-f.done
+
+		return f.tosStr, f.cs, true, f.err
+	}
+
+	f := &signFlight{done: make(chan struct{})}
+	tc.flights[cacheKey] = f
+	tc.mu.Unlock()
+
+	defer func() {
+		tc.mu.Lock()
+		delete(tc.flights, cacheKey)
+		tc.mu.Unlock()
+
+		close(f.done)
+	}()
+
+	// waiters still see an error if fn panics
+	f.err = errSigningAborted
+
+	f.tosStr, f.cs, f.err = fn()
+	if f.err == nil {
+		tc.put(cacheKey, f.tosStr, f.cs)
+	}
+
+	return f.tosStr, f.cs, false, f.err
+}
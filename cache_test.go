@@ -0,0 +1,281 @@
+package jwt_signer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// countingMethod counts the tokens signed with the method it wraps.
+type countingMethod struct {
+	jwt.SigningMethod
+	signed atomic.Int32
+}
+
+func (m *countingMethod) Sign(signingString string, key any) ([]byte, error) {
+	m.signed.Add(1)
+	return m.SigningMethod.Sign(signingString, key)
+}
+
+// cachingSigner provisions a signer with the token cache whose secret key counts its signatures.
+func cachingSigner(t *testing.T, options string) (*JwtSigner, *countingMethod) {
+	t.Helper()
+
+	s, err := provisionSigner(t, `jwt_signer {http.request.header.X-TTL} `+testSecret+` {
+		sub {http.request.header.X-User}
+		options {
+			`+options+`
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &countingMethod{SigningMethod: s.secretKey.method}
+	s.secretKey.method = m
+
+	return s, m
+}
+
+// cachedRequest serves a request for the user with the given token duration and returns the token.
+func cachedRequest(t *testing.T, s *JwtSigner, user, ttl string) string {
+	t.Helper()
+
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", user)
+	r.Header.Set("X-TTL", ttl)
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+	parseTestToken(t, repl)
+
+	return tosStr
+}
+
+func TestTokenCache(t *testing.T) {
+	type req struct{ user, ttl string }
+
+	tests := []struct {
+		name       string
+		reqs       []req
+		wantSigned int32
+	}{
+		{
+			name:       "identical claims",
+			reqs:       repeatRequest(req{"alice", "5m"}, 100),
+			wantSigned: 1,
+		},
+		{
+			name:       "different claims",
+			reqs:       []req{{"alice", "5m"}, {"bob", "5m"}, {"alice", "5m"}, {"bob", "5m"}},
+			wantSigned: 2,
+		},
+		{
+			name:       "different durations",
+			reqs:       []req{{"alice", "5m"}, {"alice", "10m"}, {"alice", "5m"}, {"alice", "300"}},
+			wantSigned: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, m := cachingSigner(t, `token_cache_ttl 1m`)
+
+			seen := map[req]string{}
+			for _, rq := range tt.reqs {
+				tosStr := cachedRequest(t, s, rq.user, rq.ttl)
+
+				// 300 is counted in seconds, the same lifetime as 5m
+				key := rq
+				if key.ttl == "300" {
+					key.ttl = "5m"
+				}
+
+				if prev, ok := seen[key]; ok && prev != tosStr {
+					t.Fatalf("request %v got a new token", rq)
+				}
+				seen[key] = tosStr
+			}
+
+			if got := m.signed.Load(); got != tt.wantSigned {
+				t.Fatalf("signed %d tokens, want %d", got, tt.wantSigned)
+			}
+		})
+	}
+}
+
+func TestTokenCacheConcurrent(t *testing.T) {
+	s, m := cachingSigner(t, `token_cache_ttl 1m`)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r, _ := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User", "alice")
+			r.Header.Set("X-TTL", "5m")
+
+			errs <- s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := m.signed.Load(); got != 1 {
+		t.Fatalf("signed %d tokens, want 1", got)
+	}
+}
+
+func TestTokenCacheEviction(t *testing.T) {
+	s, m := cachingSigner(t, `token_cache_ttl 1m
+			token_cache_size 2`)
+
+	tests := []struct {
+		user   string
+		signed bool
+	}{
+		{"alice", true},
+		{"bob", true},
+		{"alice", false},
+		// evicts bob, alice having been used since
+		{"carol", true},
+		// evicts alice
+		{"bob", true},
+		{"carol", false},
+		// evicts bob
+		{"alice", true},
+		{"carol", false},
+	}
+
+	for i, tt := range tests {
+		before := m.signed.Load()
+		cachedRequest(t, s, tt.user, "5m")
+
+		if signed := m.signed.Load() != before; signed != tt.signed {
+			t.Fatalf("request %d for %s signed %v, want %v", i, tt.user, signed, tt.signed)
+		}
+	}
+
+	if n := len(s.tokenCache.entries); n != 2 {
+		t.Fatalf("%d cached tokens, want 2", n)
+	}
+}
+
+func TestTokenCacheUsable(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		reuse      float64
+		iat, exp   time.Duration
+		requestExp time.Duration
+		want       bool
+	}{
+		{name: "fresh", ttl: time.Minute, iat: -time.Minute, exp: 9 * time.Minute, requestExp: 10 * time.Minute, want: true},
+		{name: "valid for less than the TTL", ttl: time.Minute, iat: -9 * time.Minute, exp: 30 * time.Second, requestExp: 10 * time.Minute},
+		{name: "valid for exactly the TTL", ttl: time.Minute, iat: -9 * time.Minute, exp: time.Minute, requestExp: 10 * time.Minute},
+		{name: "expired", iat: -10 * time.Minute, exp: -time.Second, requestExp: 10 * time.Minute},
+		{name: "within the reuse share", reuse: 0.8, iat: -7 * time.Minute, exp: 3 * time.Minute, requestExp: 10 * time.Minute, want: true},
+		{name: "past the reuse share", reuse: 0.8, iat: -9 * time.Minute, exp: time.Minute, requestExp: 10 * time.Minute},
+		{name: "past the reuse share of a short token", reuse: 0.5, iat: -40 * time.Second, exp: 20 * time.Second, requestExp: time.Minute},
+		{name: "outliving the requested expiry", ttl: time.Minute, iat: -time.Minute, exp: 9 * time.Minute, requestExp: 5 * time.Minute},
+		{name: "expiring with the request", ttl: time.Minute, iat: -time.Minute, exp: 5 * time.Minute, requestExp: 5 * time.Minute, want: true},
+	}
+
+	for _, tt := range tests {
+		tc := newTokenCache(tt.ttl, tt.reuse, 1)
+		entry := &cachedToken{iat: now.Add(tt.iat), exp: now.Add(tt.exp)}
+
+		if got := tc.usable(entry, now, now.Add(tt.requestExp)); got != tt.want {
+			t.Errorf("%s: usable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenCacheDeadline(t *testing.T) {
+	s, m := cachingSigner(t, `token_cache_ttl 1m`)
+
+	first := cachedRequest(t, s, "alice", "10m")
+
+	// a request which has to be done sooner cannot take a token outliving it
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "alice")
+	r.Header.Set("X-TTL", "10m")
+	r = r.WithContext(context.WithValue(r.Context(), deadlineKey{}, time.Now().Add(5*time.Minute)))
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	if tosStr, _ := repl.GetString("http.jwt_signer.digest_str"); tosStr == first {
+		t.Fatal("request with an earlier deadline got the cached token")
+	}
+
+	if exp, _ := parseTestToken(t, repl).GetExpirationTime(); exp == nil || exp.After(time.Now().Add(5*time.Minute)) {
+		t.Fatalf("exp %v past the deadline", exp)
+	}
+
+	if got := m.signed.Load(); got != 2 {
+		t.Fatalf("signed %d tokens, want 2", got)
+	}
+
+	if cachedRequest(t, s, "alice", "10m") != first {
+		t.Fatal("request without a deadline did not get the cached token")
+	}
+}
+
+func TestTokenCacheScope(t *testing.T) {
+	a, ma := cachingSigner(t, `token_cache_ttl 1m`)
+	b, mb := cachingSigner(t, `token_cache_ttl 1m`)
+
+	if a.tokenCache == b.tokenCache {
+		t.Fatal("handlers configured alike share a token cache")
+	}
+
+	for range 3 {
+		cachedRequest(t, a, "alice", "5m")
+		cachedRequest(t, b, "alice", "5m")
+	}
+
+	if ma.signed.Load() != 1 || mb.signed.Load() != 1 {
+		t.Fatalf("signed %d and %d tokens, want one per handler", ma.signed.Load(), mb.signed.Load())
+	}
+
+	// a reload provisions the handler anew, with an empty cache
+	c, mc := cachingSigner(t, `token_cache_ttl 1m`)
+	cachedRequest(t, c, "alice", "5m")
+
+	if mc.signed.Load() != 1 {
+		t.Fatalf("reloaded handler signed %d tokens, want 1", mc.signed.Load())
+	}
+}
+
+func repeatRequest[T any](r T, n int) []T {
+	reqs := make([]T, n)
+	for i := range reqs {
+		reqs[i] = r
+	}
+
+	return reqs
+}
@@ -0,0 +1,460 @@
+package jwt_signer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *JwtSigner
+	}{
+		{
+			name:  "duration and secret",
+			input: `jwt_signer 1h sec`,
+			want:  &JwtSigner{Dur: "1h", Secret: "sec"},
+		},
+		{
+			name:  "duration only",
+			input: `jwt_signer 1h`,
+			want:  &JwtSigner{Dur: "1h"},
+		},
+		{
+			name: "duration and secret as options",
+			input: `jwt_signer {
+				options {
+					duration {http.request.header.X-TTL}
+					secret {env.JWT_SECRET}
+				}
+			}`,
+			want: &JwtSigner{Dur: "{http.request.header.X-TTL}", Secret: "{env.JWT_SECRET}"},
+		},
+		{
+			name: "empty block",
+			input: `jwt_signer 1h sec {
+			}`,
+			want: &JwtSigner{Dur: "1h", Secret: "sec"},
+		},
+		{
+			name: "flat claims",
+			input: `jwt_signer 1h sec {
+				sub {http.auth.user.id}
+				name "John Doe"
+				admin true
+			}`,
+			want: &JwtSigner{Dur: "1h", Secret: "sec", Claims: jwt.MapClaims{
+				"sub":   "{http.auth.user.id}",
+				"name":  "John Doe",
+				"admin": "true",
+			}},
+		},
+		{
+			name: "nested claims",
+			input: `jwt_signer 1h sec {
+				org {
+					id acme
+					team {
+						id platform
+						lead {http.request.header.X-Lead}
+					}
+				}
+			}`,
+			want: &JwtSigner{Dur: "1h", Secret: "sec", Claims: jwt.MapClaims{
+				"org": jwt.MapClaims{
+					"id": "acme",
+					"team": jwt.MapClaims{
+						"id":   "platform",
+						"lead": "{http.request.header.X-Lead}",
+					},
+				},
+			}},
+		},
+		{
+			name: "mixed claim types",
+			input: `jwt_signer 1h sec {
+				sub u
+				roles user
+				roles += admin {http.request.header.X-Role}
+				amr {
+					- pwd
+					- {
+						method otp
+					}
+				}
+				region env DEPLOY_REGION
+				sid hash sha256 {http.request.header.X-Session}
+				dev hmac sha512 {http.request.header.X-Device}
+				options {
+					hash_claim_key {env.HASH_KEY}
+					hash_claim_encoding base64url
+				}
+			}`,
+			want: &JwtSigner{
+				Dur:    "1h",
+				Secret: "sec",
+				Claims: jwt.MapClaims{
+					"sub":   "u",
+					"roles": []any{"user", "admin", "{http.request.header.X-Role}"},
+					"amr":   []any{"pwd", jwt.MapClaims{"method": "otp"}},
+				},
+				EnvClaims: map[string]string{"region": "DEPLOY_REGION"},
+				HashClaims: map[string]*HashClaim{
+					"sid": {Function: "hash", Algorithm: "sha256", Value: "{http.request.header.X-Session}"},
+					"dev": {Function: "hmac", Algorithm: "sha512", Value: "{http.request.header.X-Device}"},
+				},
+				HashClaimKey:      "{env.HASH_KEY}",
+				HashClaimEncoding: "base64url",
+			},
+		},
+		{
+			name: "repeated nested block replaces the first",
+			input: `jwt_signer 1h sec {
+				org {
+					id acme
+				}
+				org {
+					id globex
+				}
+			}`,
+			want: &JwtSigner{Dur: "1h", Secret: "sec", Claims: jwt.MapClaims{
+				"org": jwt.MapClaims{"id": "globex"},
+			}},
+		},
+		{
+			name: "claims named like options",
+			input: `jwt_signer 1h sec {
+				audience internal
+				tenant acme
+				claims nested
+				options x
+				options {
+					handler_name login
+				}
+				sub u
+				options {
+					audience api
+				}
+			}`,
+			want: &JwtSigner{
+				Name:     "login",
+				Dur:      "1h",
+				Secret:   "sec",
+				Audience: []string{"api"},
+				Claims: jwt.MapClaims{
+					"audience": "internal",
+					"tenant":   "acme",
+					"claims":   "nested",
+					"options":  "x",
+					"sub":      "u",
+				},
+			},
+		},
+		{
+			name: "client claims",
+			input: `jwt_signer 1h sec {
+				options {
+					client_credentials {
+						client svc $2a$14$hash {
+							scopes read
+							claims {
+								tier gold
+								org {
+									id acme
+								}
+							}
+						}
+					}
+				}
+			}`,
+			want: &JwtSigner{Dur: "1h", Secret: "sec", ClientCredentials: &ClientCredentialsConfig{
+				Clients: map[string]*ClientConfig{"svc": {
+					SecretHash: "$2a$14$hash",
+					Scopes:     []string{"read"},
+					Claims:     jwt.MapClaims{"tier": "gold", "org": jwt.MapClaims{"id": "acme"}},
+				}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &JwtSigner{}
+			if err := got.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input)); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parsed\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalCaddyfileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "too many arguments",
+			input: `jwt_signer 1h sec extra`,
+			err:   "wrong argument count",
+		},
+		{
+			name: "option missing its argument",
+			input: `jwt_signer {
+				options {
+					duration
+				}
+			}`,
+			err: "wrong argument count",
+		},
+		{
+			name: "option with an extra argument",
+			input: `jwt_signer {
+				options {
+					secret a b
+				}
+			}`,
+			err: "wrong argument count",
+		},
+		{
+			name: "claim with too many arguments",
+			input: `jwt_signer 1h sec {
+				sub a b
+			}`,
+			err: "too many arguments after key: sub",
+		},
+		{
+			name: "empty claim value",
+			input: `jwt_signer 1h sec {
+				sub ""
+			}`,
+			err: "malformed claim sub: value is empty",
+		},
+		{
+			name: "empty nested claim",
+			input: `jwt_signer 1h sec {
+				org {
+				}
+			}`,
+			err: "claim org: no value",
+		},
+		{
+			name: "error in a nested claim",
+			input: `jwt_signer 1h sec {
+				org {
+					team {
+						id a b
+					}
+				}
+			}`,
+			err: "nested under key org: nested under key team: too many arguments after key: id",
+		},
+		{
+			name: "claims and array elements mixed",
+			input: `jwt_signer 1h sec {
+				roles {
+					- reader
+					admin true
+				}
+			}`,
+			err: "either claims or array elements",
+		},
+		{
+			name: "nothing to append",
+			input: `jwt_signer 1h sec {
+				roles +=
+			}`,
+			err: "malformed claim roles: nothing to append",
+		},
+		{
+			name: "nested env claim",
+			input: `jwt_signer 1h sec {
+				org {
+					region env DEPLOY_REGION
+				}
+			}`,
+			err: "env claim region is only supported at the top level",
+		},
+		{
+			name: "nested hash claim",
+			input: `jwt_signer 1h sec {
+				org {
+					sid hash sha256 {http.request.header.X-Session}
+				}
+			}`,
+			err: "hash claim sid is only supported at the top level",
+		},
+		{
+			name: "hmac claim without value",
+			input: `jwt_signer 1h sec {
+				sid hmac sha256
+			}`,
+			err: "hmac claim sid requires an algorithm and a value",
+		},
+		{
+			name: "hash claim with an extra argument",
+			input: `jwt_signer 1h sec {
+				sid hash sha256 {http.request.header.X-Session} extra
+			}`,
+			err: "too many arguments after key: sid",
+		},
+		{
+			name: "env claim with an extra argument",
+			input: `jwt_signer 1h sec {
+				region env DEPLOY_REGION extra
+			}`,
+			err: "too many arguments after key: region",
+		},
+		{
+			name: "unknown option",
+			input: `jwt_signer 1h sec {
+				options {
+					bogus
+				}
+			}`,
+			err: "unknown option: bogus",
+		},
+		{
+			name: "claim named like an option",
+			input: `jwt_signer 1h sec {
+				audience a b
+			}`,
+			err: "too many arguments after key: audience",
+		},
+		{
+			name: "error in client claims",
+			input: `jwt_signer 1h sec {
+				options {
+					client_credentials {
+						client svc hash {
+							claims {
+								org {
+									region env DEPLOY_REGION
+								}
+							}
+						}
+					}
+				}
+			}`,
+			err: "env claim region is only supported at the top level",
+		},
+		{
+			name: "unknown sub-block option",
+			input: `jwt_signer 1h {
+				options {
+					signing_jwks https://keys.example.com/jwks.json k1 {
+						bogus
+					}
+				}
+			}`,
+			err: "unknown signing_jwks option: bogus",
+		},
+		{
+			name: "unknown client option",
+			input: `jwt_signer 1h sec {
+				options {
+					client_credentials {
+						client svc hash {
+							bogus
+						}
+					}
+				}
+			}`,
+			err: "unknown client option: bogus",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&JwtSigner{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseCaddyfile(t *testing.T) {
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwt_signer 1h sec {
+		sub u
+	}`)}
+
+	handler, err := parseCaddyfile(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := handler.(*JwtSigner)
+	if !ok {
+		t.Fatalf("handler is a %T", handler)
+	}
+
+	if s.Dur != "1h" || s.Secret != "sec" || s.Claims["sub"] != "u" {
+		t.Fatalf("parsed %+v", s)
+	}
+
+	h = httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwt_signer 1h sec {
+		options {
+			use_claim_group base
+		}
+	}`)}
+
+	if _, err := parseCaddyfile(h); err == nil || !strings.Contains(err.Error(), "unknown claim_group: base") {
+		t.Fatalf("unknown claim group: %v", err)
+	}
+}
+
+func TestParseClaimGroupOption(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`claim_group base {
+		extends root
+		iss auth.example.com
+		claims {
+			extends parent
+		}
+	}`)
+
+	got, err := parseClaimGroupOption(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]*ClaimGroup{"base": {
+		Extends: []string{"root"},
+		Claims:  jwt.MapClaims{"iss": "auth.example.com", "extends": "parent"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsed %+v, want %+v", got, want)
+	}
+}
+
+// The JSON a Caddyfile adapts to has to provision: parsing must not produce values only the Caddyfile accepts.
+func TestCaddyfileProvisions(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 1h `+testSecret+` {
+		sub {http.auth.user.id}
+		audience internal
+		org {
+			id acme
+		}
+		sid hash sha256 {http.request.header.X-Session}
+		options {
+			handler_name login
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Name != "login" || s.staticClaims["audience"] != "internal" {
+		t.Fatalf("provisioned %+v", s)
+	}
+}
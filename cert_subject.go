@@ -0,0 +1,130 @@
+package jwt_signer
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	certSubjectCN    = "cn"
+	certSubjectDNS   = "dns"
+	certSubjectURI   = "uri"
+	certSubjectEmail = "email"
+	certSubjectDN    = "dn"
+)
+
+// CertSubjectConfig takes the sub claim from the client certificate of an mTLS connection, which unlike a header
+// cannot be set by the client at will.
+type CertSubjectConfig struct {
+	// From is cn, dns, uri or email for the common name or the first SAN of that type, or dn for the full
+	// distinguished name.
+	From string `json:"from"`
+
+	// OnMissing is error (default) to refuse requests without a certificate or the chosen name, or skip to leave
+	// the subject to the other claim sources.
+	OnMissing string `json:"on_missing,omitempty"`
+}
+
+func (cc *CertSubjectConfig) provision() {
+	if cc.OnMissing == "" {
+		cc.OnMissing = onMissingError
+	}
+}
+
+func (cc *CertSubjectConfig) validate() error {
+	switch cc.From {
+	case certSubjectCN, certSubjectDNS, certSubjectURI, certSubjectEmail, certSubjectDN:
+	case "":
+		return fmt.Errorf("subject_from_cert requires a source")
+	default:
+		return fmt.Errorf("unknown subject_from_cert source: %s", cc.From)
+	}
+
+	switch cc.OnMissing {
+	case onMissingError, onMissingSkip:
+	default:
+		return fmt.Errorf("unknown subject_from_cert on_missing mode: %s", cc.OnMissing)
+	}
+
+	return nil
+}
+
+func (cc *CertSubjectConfig) subject(cert *x509.Certificate) string {
+	switch cc.From {
+	case certSubjectCN:
+		return cert.Subject.CommonName
+	case certSubjectDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case certSubjectURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case certSubjectEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case certSubjectDN:
+		return cert.Subject.String()
+	}
+
+	return ""
+}
+
+func (s *JwtSigner) applyCertSubject(r *http.Request, cs jwt.MapClaims) error {
+	cc := s.SubjectFromCert
+
+	// a certificate the server requested but did not verify could name anyone
+	var sub string
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		sub = cc.subject(r.TLS.VerifiedChains[0][0])
+	}
+
+	if sub == "" {
+		if cc.OnMissing == onMissingError {
+			return caddyhttp.Error(http.StatusUnauthorized, fmt.Errorf("no verified client certificate with a %s subject presented", cc.From))
+		}
+
+		s.l.Debug("No client certificate subject, skip", zap.String("from", cc.From))
+
+		return nil
+	}
+
+	cs["sub"] = sub
+
+	return nil
+}
+
+func (cc *CertSubjectConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&cc.From) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "on_missing":
+			if !d.Args(&cc.OnMissing) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown subject_from_cert option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package jwt_signer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestSubjectFromCert(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames:       []string{"billing.internal", "billing.local"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/billing"}},
+		EmailAddresses: []string{"billing@example.org"},
+	}
+	bare := &x509.Certificate{Subject: pkix.Name{CommonName: "bare"}}
+
+	verified := func(c *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{c}, VerifiedChains: [][]*x509.Certificate{{c}}}
+	}
+
+	tests := []struct {
+		name, from, onMissing string
+		state                 *tls.ConnectionState
+		want                  string
+		status                int
+	}{
+		{name: "common name", from: "cn", state: verified(cert), want: "billing"},
+		{name: "first DNS SAN", from: "dns", state: verified(cert), want: "billing.internal"},
+		{name: "URI SAN", from: "uri", state: verified(cert), want: "spiffe://example.org/billing"},
+		{name: "email SAN", from: "email", state: verified(cert), want: "billing@example.org"},
+		{name: "distinguished name", from: "dn", state: verified(cert), want: "CN=billing,O=Example"},
+		{name: "plain HTTP", from: "cn", status: http.StatusUnauthorized},
+		{name: "unverified certificate", from: "cn", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, status: http.StatusUnauthorized},
+		{name: "name missing from the certificate", from: "uri", state: verified(bare), status: http.StatusUnauthorized},
+		{name: "unverified certificate skipped", from: "cn", onMissing: "skip", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, want: "fallback"},
+		{name: "name missing skipped", from: "email", onMissing: "skip", state: verified(bare), want: "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			onMissing := ""
+			if tt.onMissing != "" {
+				onMissing = "on_missing " + tt.onMissing
+			}
+
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				sub fallback
+				options {
+					subject_from_cert `+tt.from+` {
+						`+onMissing+`
+					}
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+			r.TLS = tt.state
+
+			err = s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+			if tt.status != 0 {
+				var he caddyhttp.HandlerError
+				if !errors.As(err, &he) || he.StatusCode != tt.status {
+					t.Fatalf("error = %v, want status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := parseTestToken(t, repl)["sub"]; got != tt.want {
+				t.Errorf("sub = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
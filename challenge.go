@@ -0,0 +1,248 @@
+package jwt_signer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// RFC 6750 section 3.1 error codes
+const (
+	challengeInvalidRequest    = "invalid_request"
+	challengeInvalidToken      = "invalid_token"
+	challengeInsufficientScope = "insufficient_scope"
+
+	// RFC 9449 section 7.1
+	challengeInvalidDPoPProof = "invalid_dpop_proof"
+)
+
+var errInvalidRequest = errors.New("malformed token request")
+
+// scopeError rejects a valid token lacking scopes the resource requires.
+type scopeError struct {
+	required []string
+}
+
+func (e *scopeError) Error() string {
+	return "token lacks required scopes: " + strings.Join(e.required, " ")
+}
+
+// ChallengeConfig shapes the RFC 6750 WWW-Authenticate challenge of rejected requests.
+type ChallengeConfig struct {
+	Realm string `json:"realm,omitempty"`
+
+	// OmitDescription leaves out error_description, which some consider an information leak.
+	OmitDescription bool `json:"omit_description,omitempty"`
+}
+
+// header builds the challenge, a nil config sends neither realm nor description. The DPoP scheme lists the proof
+// algorithms accepted.
+func (cc *ChallengeConfig) header(scheme, code, desc string, scopes, algs []string) string {
+	var params []string
+
+	if cc != nil && cc.Realm != "" {
+		params = append(params, "realm="+quoteParam(cc.Realm))
+	}
+
+	if code != "" {
+		params = append(params, "error="+quoteParam(code))
+
+		if desc != "" && (cc == nil || !cc.OmitDescription) {
+			params = append(params, "error_description="+quoteParam(desc))
+		}
+	}
+
+	if len(scopes) > 0 {
+		params = append(params, "scope="+quoteParam(strings.Join(scopes, " ")))
+	}
+
+	if len(algs) > 0 {
+		params = append(params, "algs="+quoteParam(strings.Join(algs, " ")))
+	}
+
+	if len(params) == 0 {
+		return scheme
+	}
+
+	return scheme + " " + strings.Join(params, ", ")
+}
+
+func quoteParam(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// describeRejection tells the client why its token was rejected in terms that reveal nothing about the keys or
+// configuration.
+func describeRejection(err error) string {
+	var se *scopeError
+
+	switch {
+	case errors.As(err, &se):
+		return "token lacks required scope"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "token expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return "token not yet valid"
+	case errors.Is(err, errTokenRevoked):
+		return "token revoked"
+	case errors.Is(err, errTokenUsed):
+		return "token already used"
+	case errors.Is(err, errInvalidRequest):
+		return "malformed authorization"
+	case errors.Is(err, errInvalidDPoPProof):
+		return "invalid DPoP proof"
+	default:
+		return "token invalid"
+	}
+}
+
+// rejectPresented fails the request with a Bearer challenge. A missing token gets a bare challenge, a malformed
+// request invalid_request, and a token lacking scopes 403 with insufficient_scope; anything else is invalid_token.
+func (s *JwtSigner) rejectPresented(w http.ResponseWriter, tosStr string, err error) error {
+	s.l.Debug("Token rejected", zap.Error(err))
+
+	var (
+		status = http.StatusUnauthorized
+		scheme = "Bearer"
+		code   = challengeInvalidToken
+		scopes []string
+		algs   []string
+		se     *scopeError
+	)
+
+	switch {
+	case errors.As(err, &se):
+		status, code, scopes = http.StatusForbidden, challengeInsufficientScope, se.required
+	case errors.Is(err, errInvalidRequest):
+		status, code = http.StatusBadRequest, challengeInvalidRequest
+	case errors.Is(err, errInvalidDPoPProof):
+		scheme, code = dpopScheme, challengeInvalidDPoPProof
+
+		if s.Verify != nil && s.Verify.DPoP != nil {
+			algs = s.Verify.DPoP.Algorithms
+		}
+	case tosStr == "":
+		code = ""
+	}
+
+	w.Header().Set("WWW-Authenticate", s.Challenge.header(scheme, code, describeRejection(err), scopes, algs))
+
+	return caddyhttp.Error(status, err)
+}
+
+// presentedMalformed tells whether the request tried to present a token but did it wrong: the header is repeated,
+// or the Bearer scheme is used without a token.
+func (vc *VerifyConfig) presentedMalformed(r *http.Request) bool {
+	if vc.From != tokenFromHeader {
+		return false
+	}
+
+	vals := r.Header.Values(vc.Name)
+	if len(vals) > 1 {
+		return true
+	}
+
+	if len(vals) == 0 || !strings.EqualFold(vc.Name, "Authorization") {
+		return false
+	}
+
+	scheme, _, _ := strings.Cut(strings.TrimSpace(vals[0]), " ")
+
+	for _, accepted := range vc.schemes() {
+		if strings.EqualFold(scheme, accepted) {
+			return schemeToken(vals[0], accepted) == ""
+		}
+	}
+
+	return false
+}
+
+// missingToken explains why no usable token was found in the request.
+func (vc *VerifyConfig) missingToken(r *http.Request) error {
+	if vc.presentedMalformed(r) {
+		return fmt.Errorf("%w: %s header is repeated or carries no Bearer token", errInvalidRequest, vc.Name)
+	}
+
+	return fmt.Errorf("no token presented")
+}
+
+// checkScopes requires every configured scope in the space-separated scope claim or the scp array.
+func (vc *VerifyConfig) checkScopes(cs jwt.MapClaims) error {
+	if len(vc.Scopes) == 0 {
+		return nil
+	}
+
+	var granted []string
+
+	if scope, ok := cs["scope"].(string); ok {
+		granted = strings.Fields(scope)
+	}
+
+	if scp, ok := cs["scp"].([]any); ok {
+		for _, v := range scp {
+			if str, ok := v.(string); ok {
+				granted = append(granted, str)
+			}
+		}
+	}
+
+	for _, want := range vc.Scopes {
+		if !slices.Contains(granted, want) {
+			return &scopeError{required: vc.Scopes}
+		}
+	}
+
+	return nil
+}
+
+func (cc *ChallengeConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "realm":
+			if !d.Args(&cc.Realm) {
+				return d.ArgErr()
+			}
+		case "error_description":
+			var mode string
+			if !d.Args(&mode) {
+				return d.ArgErr()
+			}
+
+			switch mode {
+			case "on":
+				cc.OmitDescription = false
+			case "off":
+				cc.OmitDescription = true
+			default:
+				return d.Errf("error_description must be on or off, got %s", mode)
+			}
+		default:
+			return d.Errf("unknown challenge option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
+
+func (cc *ChallengeConfig) validate() error {
+	if strings.ContainsAny(cc.Realm, "\r\n") {
+		return fmt.Errorf("challenge realm must not contain line breaks")
+	}
+
+	return nil
+}
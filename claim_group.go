@@ -0,0 +1,184 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func init() {
+	httpcaddyfile.RegisterGlobalOption("claim_group", parseClaimGroupOption)
+}
+
+// ClaimGroup is a named, reusable set of claims, defined with the claim_group global option. A group extending
+// other groups has their claims merged in first, in order, and overrides them with its own.
+type ClaimGroup struct {
+	Extends []string      `json:"extends,omitempty"`
+	Claims  jwt.MapClaims `json:"claims,omitempty"`
+}
+
+// resolveClaimGroups merges the used groups, later ones overriding earlier ones, below the configured claims.
+func (s *JwtSigner) resolveClaimGroups() error {
+	merged := jwt.MapClaims{}
+
+	for _, name := range s.UseClaimGroups {
+		cs, err := s.claimGroup(name, nil)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range cs {
+			merged[k] = v
+		}
+	}
+
+	for k, v := range s.Claims {
+		merged[k] = v
+	}
+
+	// a claim taken from the environment or hashed overrides the group value instead of conflicting with it
+	for claim := range s.EnvClaims {
+		delete(merged, claim)
+	}
+
+	for claim := range s.HashClaims {
+		delete(merged, claim)
+	}
+
+	if len(merged) > 0 {
+		s.Claims = merged
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) claimGroup(name string, path []string) (jwt.MapClaims, error) {
+	if slices.Contains(path, name) {
+		return nil, fmt.Errorf("claim_group cycle: %s -> %s", strings.Join(path, " -> "), name)
+	}
+
+	group, ok := s.ClaimGroups[name]
+	if !ok || group == nil {
+		return nil, fmt.Errorf("unknown claim_group: %s", name)
+	}
+
+	path = append(path, name)
+	cs := jwt.MapClaims{}
+
+	for _, parent := range group.Extends {
+		inherited, err := s.claimGroup(parent, path)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range inherited {
+			cs[k] = v
+		}
+	}
+
+	for k, v := range group.Claims {
+		cs[k] = v
+	}
+
+	return cs, nil
+}
+
+// useClaimGroups copies the groups the signer uses, and those they extend, from the global options into the
+// handler config, so the JSON config is self-contained.
+func (s *JwtSigner) useClaimGroups(h httpcaddyfile.Helper) error {
+	if len(s.UseClaimGroups) == 0 {
+		return nil
+	}
+
+	groups, _ := h.Option("claim_group").(map[string]*ClaimGroup)
+
+	s.ClaimGroups = map[string]*ClaimGroup{}
+
+	pending := slices.Clone(s.UseClaimGroups)
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+
+		if _, done := s.ClaimGroups[name]; done {
+			continue
+		}
+
+		group, ok := groups[name]
+		if !ok {
+			return h.Errf("unknown claim_group: %s", name)
+		}
+
+		s.ClaimGroups[name] = group
+		pending = append(pending, group.Extends...)
+	}
+
+	return nil
+}
+
+func parseClaimGroupOption(d *caddyfile.Dispenser, existing any) (any, error) {
+	groups, _ := existing.(map[string]*ClaimGroup)
+	if groups == nil {
+		groups = map[string]*ClaimGroup{}
+	}
+
+	d.Next() // consume option name
+
+	var name string
+	if !d.Args(&name) {
+		return nil, d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	if _, ok := groups[name]; ok {
+		return nil, d.Errf("claim_group %s is defined more than once", name)
+	}
+
+	group := &ClaimGroup{}
+	cs := jwt.MapClaims{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if d.Val() == "extends" {
+			parents := d.RemainingArgs()
+			if len(parents) == 0 {
+				return nil, d.ArgErr()
+			}
+
+			group.Extends = append(group.Extends, parents...)
+
+			continue
+		}
+
+		if d.Val() == "claims" {
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				if err := parseClaimCaddyfile(d, cs, nil); err != nil {
+					return nil, err
+				}
+			}
+
+			continue
+		}
+
+		if err := parseClaimCaddyfile(d, cs, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cs) > 0 {
+		group.Claims = cs
+	}
+
+	groups[name] = group
+
+	return groups, nil
+}
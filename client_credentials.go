@@ -0,0 +1,269 @@
+package jwt_signer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// ClientCredentialsConfig turns the signer into an OAuth 2.0 token endpoint for the client_credentials grant
+// (RFC 6749 section 4.4), authenticating clients against a table of bcrypt-hashed secrets.
+type ClientCredentialsConfig struct {
+	Clients map[string]*ClientConfig `json:"clients,omitempty"`
+}
+
+type ClientConfig struct {
+	SecretHash string         `json:"secret_hash"`
+	Scopes     []string       `json:"scopes,omitempty"`
+	Duration   caddy.Duration `json:"duration,omitempty"`
+	Claims     jwt.MapClaims  `json:"claims,omitempty"`
+}
+
+// durationOverrideKey carries a per-request token duration taking precedence over the configured one.
+type durationOverrideKey struct{}
+
+var clientHash = caddyauth.BcryptHash{}
+
+func (cc *ClientCredentialsConfig) validate(s *JwtSigner) error {
+	if len(cc.Clients) == 0 {
+		return fmt.Errorf("client_credentials requires at least one client")
+	}
+
+	for id, client := range cc.Clients {
+		if id == "" || client == nil || client.SecretHash == "" {
+			return fmt.Errorf("client_credentials clients require an id and a secret hash")
+		}
+
+		if !strings.HasPrefix(client.SecretHash, "$2") {
+			return fmt.Errorf("client %s: secret hash must be a bcrypt hash", id)
+		}
+
+		if client.Duration < 0 {
+			return fmt.Errorf("client %s: duration must not be negative", id)
+		}
+
+		if client.Duration > 0 {
+			if err := s.checkDuration(time.Duration(client.Duration)); err != nil {
+				return fmt.Errorf("client %s: %w", id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// oauthError writes an RFC 6749 section 5.2 error response.
+func oauthError(w http.ResponseWriter, status int, code, desc string) error {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": desc})
+}
+
+// clientAuth reads the client credentials from Basic auth or, failing that, from the form body.
+func clientAuth(r *http.Request) (id, secret string, ok bool) {
+	if id, secret, ok = r.BasicAuth(); ok {
+		// RFC 6749 section 2.3.1 has both form-urlencoded before Basic encoding
+		if id, err := url.QueryUnescape(id); err == nil {
+			if secret, err := url.QueryUnescape(secret); err == nil {
+				return id, secret, r.PostForm.Get("client_secret") == ""
+			}
+		}
+
+		return "", "", false
+	}
+
+	id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+
+	return id, secret, id != "" && secret != ""
+}
+
+func grantedScopes(requested string, allowed []string) []string {
+	if requested == "" {
+		return allowed
+	}
+
+	var granted []string
+
+	for _, scope := range strings.Fields(requested) {
+		if slices.Contains(allowed, scope) && !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	return granted
+}
+
+func (s *JwtSigner) serveClientCredentials(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return caddyhttp.Error(http.StatusMethodNotAllowed, fmt.Errorf("token requests require POST"))
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.RequestBodyLimitBytes)
+	if err := r.ParseForm(); err != nil {
+		if bodyTooLarge(err) {
+			return oauthError(w, http.StatusRequestEntityTooLarge, "invalid_request", "request body too large")
+		}
+
+		return oauthError(w, http.StatusBadRequest, "invalid_request", "malformed request body")
+	}
+
+	if gt := r.PostForm.Get("grant_type"); gt != "client_credentials" {
+		return oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+	}
+
+	id, secret, ok := clientAuth(r)
+	if !ok {
+		return oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication required")
+	}
+
+	client := s.ClientCredentials.Clients[id]
+
+	hash := clientHash.FakeHash()
+	if client != nil {
+		hash = []byte(client.SecretHash)
+	}
+
+	// compare against a fake hash for unknown clients too, so timing does not tell which clients exist
+	match, err := clientHash.Compare(hash, []byte(secret))
+	if err != nil || !match || client == nil {
+		s.l.Debug("Client authentication failed", zap.String("client_id", id), zap.Error(err))
+		return oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+
+	scopes := grantedScopes(r.PostForm.Get("scope"), client.Scopes)
+	if len(scopes) == 0 && r.PostForm.Get("scope") != "" {
+		return oauthError(w, http.StatusBadRequest, "invalid_scope", "none of the requested scopes is allowed")
+	}
+
+	repl.Set("http.jwt_signer.client_id", id)
+
+	extra, err := s.fillClaims(client.Claims, repl)
+	if err != nil {
+		return err
+	}
+
+	if extra == nil {
+		extra = jwt.MapClaims{}
+	}
+
+	extra["client_id"] = id
+	if len(scopes) > 0 {
+		extra["scope"] = strings.Join(scopes, " ")
+	}
+
+	if client.Duration > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), durationOverrideKey{}, time.Duration(client.Duration)))
+	}
+
+	tosStr, cs, err := s.sign(r, repl, jwt.MapClaims{"sub": id}, extra)
+	if errors.Is(err, errSkipSigning) {
+		return next.ServeHTTP(w, r)
+	}
+	if err != nil {
+		s.l.Error("Signing client credentials token failed", zap.String("client_id", id), zap.Error(err))
+		return oauthError(w, http.StatusInternalServerError, "server_error", "token could not be issued")
+	}
+
+	s.l.Debug("Client credentials token issued", zap.String("client_id", id), zap.Strings("scopes", scopes))
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	return s.output(w, r, repl, next, tosStr, cs)
+}
+
+func (cc *ClientCredentialsConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if d.Val() != "client" {
+			return d.Errf("unknown client_credentials option: %s", d.Val())
+		}
+
+		var id string
+		client := &ClientConfig{}
+
+		if !d.Args(&id, &client.SecretHash) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		if err := client.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+
+		if cc.Clients == nil {
+			cc.Clients = map[string]*ClientConfig{}
+		}
+
+		cc.Clients[id] = client
+	}
+
+	return nil
+}
+
+func (c *ClientConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "scopes":
+			scopes := d.RemainingArgs()
+			if len(scopes) == 0 {
+				return d.ArgErr()
+			}
+
+			c.Scopes = append(c.Scopes, scopes...)
+
+			continue
+		case "duration":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid client duration %s: %v", durStr, err)
+			}
+
+			c.Duration = caddy.Duration(dur)
+		case "claims":
+			if err := parseClaimsCaddyfile(d, &c.Claims); err != nil {
+				return err
+			}
+
+			continue
+		default:
+			return d.Errf("unknown client option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package jwt_signer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	compressedClaim       = "payload"
+	compressedContentType = "application/gzip"
+)
+
+// compressedKeptClaims stay readable next to the compressed payload, so tokens can still be attributed and
+// expired without expanding it.
+var compressedKeptClaims = []string{"iat", "exp", "sub", "iss"}
+
+// compressClaims moves all claims but the kept ones into a single payload claim, holding their JSON gzipped and
+// base64url encoded.
+func compressClaims(cs jwt.MapClaims) (jwt.MapClaims, error) {
+	out := jwt.MapClaims{}
+	rest := make(jwt.MapClaims, len(cs))
+
+	for k, v := range cs {
+		if slices.Contains(compressedKeptClaims, k) {
+			out[k] = v
+		} else {
+			rest[k] = v
+		}
+	}
+
+	raw, err := json.Marshal(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	out[compressedClaim] = base64.RawURLEncoding.EncodeToString(buf.Bytes())
+
+	return out, nil
+}
+
+// expandClaims restores the claims of a token signed with compress_claims, the payload claim is replaced by the
+// claims it holds.
+func expandClaims(cs jwt.MapClaims) error {
+	enc, _ := cs[compressedClaim].(string)
+
+	raw, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	raw, err = io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+
+	var rest jwt.MapClaims
+	if err := json.Unmarshal(raw, &rest); err != nil {
+		return err
+	}
+
+	delete(cs, compressedClaim)
+
+	for k, v := range rest {
+		cs[k] = v
+	}
+
+	return nil
+}
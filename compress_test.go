@@ -0,0 +1,154 @@
+package jwt_signer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCompressClaims(t *testing.T) {
+	perms := make([]string, 300)
+	for i := range perms {
+		perms[i] = fmt.Sprintf("orders:%d:read", i)
+	}
+
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub {http.request.header.X-User}
+		iss gateway
+		aud orders
+		tenant acme
+		perms += `+strings.Join(perms, " ")+`
+		options {
+			compress_claims
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "alice")
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+
+	cs := jwt.MapClaims{}
+	tok, err := jwt.ParseWithClaims(tosStr, cs, func(*jwt.Token) (any, error) { return []byte(testSecret), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tok.Header["cty"] != compressedContentType {
+		t.Errorf("cty = %v, want %s", tok.Header["cty"], compressedContentType)
+	}
+
+	keys := slices.Sorted(maps.Keys(cs))
+	if want := []string{"exp", "iat", "iss", "payload", "sub"}; !slices.Equal(keys, want) {
+		t.Fatalf("claims %v, want %v", keys, want)
+	}
+	if cs["sub"] != "alice" || cs["iss"] != "gateway" {
+		t.Errorf("kept claims sub %v and iss %v", cs["sub"], cs["iss"])
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cs["payload"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err = io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rest map[string]any
+	if err := json.Unmarshal(raw, &rest); err != nil {
+		t.Fatal(err)
+	}
+
+	if rest["aud"] != "orders" || rest["tenant"] != "acme" {
+		t.Errorf("payload aud %v and tenant %v", rest["aud"], rest["tenant"])
+	}
+
+	got, _ := rest["perms"].([]any)
+	if len(got) != len(perms) || got[0] != perms[0] || got[len(got)-1] != perms[len(perms)-1] {
+		t.Errorf("payload holds %d permissions, want %d", len(got), len(perms))
+	}
+
+	for _, k := range compressedKeptClaims {
+		if _, ok := rest[k]; ok {
+			t.Errorf("%s compressed as well", k)
+		}
+	}
+
+	if len(tosStr) >= len(raw) {
+		t.Errorf("token of %d bytes, no smaller than its %d bytes of claims", len(tosStr), len(raw))
+	}
+}
+
+func TestExpandClaims(t *testing.T) {
+	compressed, err := compressClaims(jwt.MapClaims{"sub": "alice", "exp": 1e9, "roles": []any{"a", "b"}, "org": "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notJSON := func() string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write([]byte("roles"))
+		_ = zw.Close()
+
+		return base64.RawURLEncoding.EncodeToString(buf.Bytes())
+	}()
+
+	tests := []struct {
+		name    string
+		payload any
+		ok      bool
+	}{
+		{"compressed claims", compressed[compressedClaim], true},
+		{"not base64url", "not base64!", false},
+		{"not gzipped", base64.RawURLEncoding.EncodeToString([]byte(`{"org":"acme"}`)), false},
+		{"not JSON", notJSON, false},
+		{"missing", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := jwt.MapClaims{"sub": "alice", compressedClaim: tt.payload}
+
+			err := expandClaims(cs)
+			if (err == nil) != tt.ok {
+				t.Fatalf("expandClaims = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+
+			if _, ok := cs[compressedClaim]; ok {
+				t.Error("payload claim kept")
+			}
+			if cs["org"] != "acme" || cs["sub"] != "alice" || len(cs["roles"].([]any)) != 2 {
+				t.Errorf("expanded claims %v", cs)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package jwt_signer
+
+import (
+	"context"
+	"maps"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtClaimsKey is the context key holding the claims of the token issued or verified for a request.
+type jwtClaimsKey struct{}
+
+// ClaimsFromContext returns the claims of the token jwt_signer issued or verified for the request, so handlers
+// further down the chain can use them without parsing the token again.
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	cs, ok := ctx.Value(jwtClaimsKey{}).(jwt.MapClaims)
+	return cs, ok
+}
+
+// withClaims stores a copy of the claims, so a downstream handler changing them cannot affect cached tokens.
+func withClaims(r *http.Request, cs jwt.MapClaims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, maps.Clone(cs)))
+}
@@ -0,0 +1,122 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestClaimsFromContext(t *testing.T) {
+	presented, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  "alice",
+		"role": "admin",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, cfg, auth string
+		want            map[string]any
+	}{
+		{
+			name: "signed",
+			cfg: `jwt_signer 5m ` + testSecret + ` {
+				sub alice
+				role admin
+				org {
+					id acme
+				}
+				user {http.request.header.X-User}
+			}`,
+			want: map[string]any{"sub": "alice", "role": "admin", "org": map[string]any{"id": "acme"}, "user": "bob"},
+		},
+		{
+			name: "verified",
+			cfg: `jwt_signer {
+				options {
+					secret ` + testSecret + `
+					verify
+				}
+			}`,
+			auth: "Bearer " + presented,
+			want: map[string]any{"sub": "alice", "role": "admin"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSigner(t, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, _ := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User", "bob")
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			var (
+				got jwt.MapClaims
+				ok  bool
+			)
+
+			err = s.ServeHTTP(httptest.NewRecorder(), r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				got, ok = ClaimsFromContext(r.Context())
+				return nil
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !ok {
+				t.Fatal("no claims in the context")
+			}
+
+			for k, v := range tt.want {
+				if !claimEqual(got[k], v) {
+					t.Errorf("%s = %#v, want %#v", k, got[k], v)
+				}
+			}
+
+			if got["exp"] == nil {
+				t.Error("no exp in the context claims")
+			}
+		})
+	}
+}
+
+// claimEqual compares claim values by their string form, so nested objects of either map type compare alike.
+func claimEqual(got, want any) bool {
+	if wantObj, ok := want.(map[string]any); ok {
+		var gotObj map[string]any
+		switch v := got.(type) {
+		case map[string]any:
+			gotObj = v
+		case jwt.MapClaims:
+			gotObj = v
+		default:
+			return false
+		}
+
+		if len(gotObj) != len(wantObj) {
+			return false
+		}
+
+		for k, v := range wantObj {
+			if !claimEqual(gotObj[k], v) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return got != nil && claimString(got) == claimString(want)
+}
@@ -0,0 +1,245 @@
+package jwt_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+type CookieConfig struct {
+	Name     string `json:"name"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Secure   *bool  `json:"secure,omitempty"`
+	HTTPOnly *bool  `json:"http_only,omitempty"`
+	SameSite string `json:"same_site,omitempty"`
+
+	RefreshBefore caddy.Duration `json:"refresh_before,omitempty"`
+}
+
+func (c *CookieConfig) provision() {
+	if c.Path == "" {
+		c.Path = "/"
+	}
+}
+
+func (c *CookieConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("missing cookie name")
+	}
+
+	if _, err := parseSameSite(c.SameSite); err != nil {
+		return err
+	}
+
+	if c.RefreshBefore < 0 {
+		return fmt.Errorf("cookie refresh_before must not be negative")
+	}
+
+	return nil
+}
+
+func (c *CookieConfig) build(tosStr string, cs jwt.MapClaims) *http.Cookie {
+	sameSite, _ := parseSameSite(c.SameSite)
+
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    tosStr,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure == nil || *c.Secure,
+		HttpOnly: c.HTTPOnly == nil || *c.HTTPOnly,
+		SameSite: sameSite,
+	}
+
+	if exp, ok := cs["exp"].(int64); ok {
+		cookie.Expires = time.Unix(exp, 0)
+		cookie.MaxAge = int(exp - time.Now().Unix())
+	}
+
+	return cookie
+}
+
+func parseSameSite(val string) (http.SameSite, error) {
+	switch strings.ToLower(val) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown cookie same_site mode: %s", val)
+	}
+}
+
+// freshCookie reports whether the request already carries a valid token cookie which is not due for refresh.
+func (s *JwtSigner) freshCookie(r *http.Request, repl *caddy.Replacer) (string, jwt.MapClaims, bool) {
+	cookie, err := r.Cookie(s.Cookie.Name)
+	if err != nil {
+		s.l.Debug("No token cookie present", zap.String("cookie", s.Cookie.Name))
+		return "", nil, false
+	}
+
+	cs, err := s.parse(cookie.Value, repl)
+	if err != nil {
+		s.l.Debug("Token cookie rejected", zap.String("cookie", s.Cookie.Name), zap.Error(err))
+		return "", nil, false
+	}
+
+	exp, err := cs.GetExpirationTime()
+	if err != nil || exp == nil {
+		s.l.Debug("Token cookie has no usable expiration", zap.String("cookie", s.Cookie.Name))
+		return "", nil, false
+	}
+
+	remaining := time.Until(exp.Time)
+	if remaining <= time.Duration(s.Cookie.RefreshBefore) {
+		s.l.Debug("Token cookie is due for refresh", zap.Duration("remaining", remaining))
+		return "", nil, false
+	}
+
+	if current, err := s.cookieClaimsCurrent(r, repl, cs); !current {
+		s.l.Debug("Token cookie claims differ from the current ones", zap.Error(err))
+		return "", nil, false
+	}
+
+	return cookie.Value, cs, true
+}
+
+// cookieClaimsCurrent reports whether the token cookie carries the claims the request would be issued now, apart
+// from the timestamps and generated values, so a cookie is not reused after the user or the configuration changed.
+func (s *JwtSigner) cookieClaimsCurrent(r *http.Request, repl *caddy.Replacer, cs jwt.MapClaims) (bool, error) {
+	_, want, err := s.buildClaims(r, repl, nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if auds := s.audiences(repl); len(auds) > 0 {
+		want["aud"] = s.audienceClaim(auds)
+	}
+
+	applyAliases(want, s.ClaimAliases, s.l)
+
+	if s.NumericDates {
+		if err := normalizeNumericDates(want); err != nil {
+			return false, err
+		}
+	}
+
+	generated, err := s.extraClaims(repl)
+	if err != nil {
+		return false, err
+	}
+
+	// compare as both look after the round trip through JSON
+	raw, err := json.Marshal(want)
+	if err != nil {
+		return false, err
+	}
+
+	current := map[string]any{}
+	if err := json.Unmarshal(raw, &current); err != nil {
+		return false, err
+	}
+
+	presented := maps.Clone(cs)
+
+	for _, claims := range []map[string]any{current, presented} {
+		delete(claims, "iat")
+		delete(claims, "exp")
+
+		for k := range generated {
+			delete(claims, k)
+		}
+	}
+
+	return reflect.DeepEqual(current, map[string]any(presented)), nil
+}
+
+func (c *CookieConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&c.Name) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "path":
+			if !d.Args(&c.Path) {
+				return d.ArgErr()
+			}
+		case "domain":
+			if !d.Args(&c.Domain) {
+				return d.ArgErr()
+			}
+		case "same_site":
+			if !d.Args(&c.SameSite) {
+				return d.ArgErr()
+			}
+		case "secure":
+			val, err := parseBoolArg(d)
+			if err != nil {
+				return err
+			}
+
+			c.Secure = &val
+		case "http_only":
+			val, err := parseBoolArg(d)
+			if err != nil {
+				return err
+			}
+
+			c.HTTPOnly = &val
+		case "refresh_before":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid refresh_before %s: %v", durStr, err)
+			}
+
+			c.RefreshBefore = caddy.Duration(dur)
+		default:
+			return d.Errf("unknown cookie option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
+
+// parseBoolArg reads an optional boolean argument, a bare flag means true.
+func parseBoolArg(d *caddyfile.Dispenser) (bool, error) {
+	if !d.NextArg() {
+		return true, nil
+	}
+
+	val, err := strconv.ParseBool(d.Val())
+	if err != nil {
+		return false, d.Errf("invalid boolean %s: %v", d.Val(), err)
+	}
+
+	return val, nil
+}
@@ -0,0 +1,88 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestFreshCookie(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 10m `+testSecret+` {
+		sub {http.request.header.X-User}
+		role {http.request.header.X-Role}
+		options {
+			cookie session {
+				refresh_before 1m
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(cookie, user, role string) (string, bool) {
+		t.Helper()
+
+		r, repl := newTestRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		r.Header.Set("X-Role", role)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+
+		w := httptest.NewRecorder()
+		if err := s.ServeHTTP(w, r, nopHandler); err != nil {
+			t.Fatal(err)
+		}
+
+		tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+
+		return tosStr, w.Header().Get("Set-Cookie") != ""
+	}
+
+	issued, _ := serve("", "alice", "user")
+
+	expiring, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  "alice",
+		"role": "user",
+		"iat":  time.Now().Add(-9 * time.Minute).Unix(),
+		"exp":  time.Now().Add(30 * time.Second).Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, cookie, user, role string
+		wantReuse                bool
+	}{
+		{name: "same claims", cookie: issued, user: "alice", role: "user", wantReuse: true},
+		{name: "other user", cookie: issued, user: "bob", role: "user"},
+		{name: "changed claim", cookie: issued, user: "alice", role: "admin"},
+		{name: "claim dropped", cookie: issued, user: "alice"},
+		{name: "due for refresh", cookie: expiring, user: "alice", role: "user"},
+		{name: "invalid", cookie: "not.a.token", user: "alice", role: "user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tosStr, setCookie := serve(tt.cookie, tt.user, tt.role)
+
+			if reused := tosStr == tt.cookie && !setCookie; reused != tt.wantReuse {
+				t.Fatalf("cookie reused = %v, want %v", reused, tt.wantReuse)
+			}
+
+			cs := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(tosStr, cs, func(*jwt.Token) (any, error) { return []byte(testSecret), nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			if cs["sub"] != tt.user {
+				t.Fatalf("sub = %v, want %s", cs["sub"], tt.user)
+			}
+		})
+	}
+}
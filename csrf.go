@@ -0,0 +1,264 @@
+package jwt_signer
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCSRFHeader       = "X-CSRF-Token"
+	defaultCSRFCookie       = "csrf_token"
+	defaultCSRFSessionClaim = "sub"
+
+	// csrfBindingClaim holds the hashed session identifier, so the token names no user and only matches its session.
+	csrfBindingClaim = "csrf_session"
+)
+
+// CSRFConfig implements double-submit CSRF protection bound to a session. Safe requests get a token in a cookie
+// readable by scripts, unsafe requests must echo it in a header and are refused unless it belongs to the session.
+type CSRFConfig struct {
+	// SessionCookie holds a token signed by this handler, whose SessionClaim identifies the session.
+	SessionCookie string `json:"session_cookie"`
+	SessionClaim  string `json:"session_claim,omitempty"`
+
+	Header string        `json:"header,omitempty"`
+	Cookie *CookieConfig `json:"cookie,omitempty"`
+
+	// Exempt lists paths which are neither protected nor given a token, matched like the path matcher.
+	Exempt caddyhttp.MatchPath `json:"exempt,omitempty"`
+}
+
+func (cc *CSRFConfig) provision(ctx caddy.Context) error {
+	if cc.SessionClaim == "" {
+		cc.SessionClaim = defaultCSRFSessionClaim
+	}
+
+	if cc.Header == "" {
+		cc.Header = defaultCSRFHeader
+	}
+
+	if cc.Cookie == nil {
+		cc.Cookie = &CookieConfig{Name: defaultCSRFCookie}
+	}
+
+	// the token is read by scripts to be echoed in the header
+	if cc.Cookie.HTTPOnly == nil {
+		httpOnly := false
+		cc.Cookie.HTTPOnly = &httpOnly
+	}
+
+	if cc.Cookie.SameSite == "" {
+		cc.Cookie.SameSite = "strict"
+	}
+
+	cc.Cookie.provision()
+
+	return cc.Exempt.Provision(ctx)
+}
+
+func (cc *CSRFConfig) validate() error {
+	if cc.SessionCookie == "" {
+		return fmt.Errorf("csrf requires a session cookie")
+	}
+
+	if cc.Cookie.Name == cc.SessionCookie {
+		return fmt.Errorf("csrf cookie must differ from the session cookie")
+	}
+
+	return cc.Cookie.validate()
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// csrfBinding identifies the session of the request by the hashed session claim of its verified session cookie.
+func (s *JwtSigner) csrfBinding(r *http.Request, repl *caddy.Replacer) (string, error) {
+	cc := s.CSRF
+
+	cookie, err := r.Cookie(cc.SessionCookie)
+	if err != nil {
+		return "", fmt.Errorf("no session cookie presented")
+	}
+
+	cs, err := s.parse(cookie.Value, repl)
+	if err != nil {
+		return "", fmt.Errorf("session cookie rejected: %w", err)
+	}
+
+	sid := claimString(cs[cc.SessionClaim])
+	if sid == "" {
+		return "", fmt.Errorf("session token has no %s claim", cc.SessionClaim)
+	}
+
+	sum := sha256.Sum256([]byte(sid))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// checkCSRFToken verifies a token was issued for the session of the request.
+func (s *JwtSigner) checkCSRFToken(tosStr, binding string, repl *caddy.Replacer) (jwt.MapClaims, error) {
+	cs, err := s.parse(tosStr, repl)
+	if err != nil {
+		return nil, err
+	}
+
+	bound, _ := cs[csrfBindingClaim].(string)
+	if subtle.ConstantTimeCompare([]byte(bound), []byte(binding)) != 1 {
+		return nil, fmt.Errorf("csrf token belongs to another session")
+	}
+
+	return cs, nil
+}
+
+func (s *JwtSigner) serveCSRF(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	cc := s.CSRF
+
+	exempt, err := cc.Exempt.MatchWithError(r)
+	if err != nil {
+		return err
+	}
+
+	if exempt {
+		s.l.Debug("Path exempt from csrf protection", zap.String("path", r.URL.Path))
+		return next.ServeHTTP(w, r)
+	}
+
+	binding, err := s.csrfBinding(r, repl)
+
+	if !isSafeMethod(r.Method) {
+		if err == nil {
+			err = s.checkCSRFRequest(r, repl, binding)
+		}
+
+		if err != nil {
+			s.l.Debug("CSRF check failed", zap.String("method", r.Method), zap.Error(err))
+			return caddyhttp.Error(http.StatusForbidden, err)
+		}
+
+		return next.ServeHTTP(w, r)
+	}
+
+	if err != nil {
+		s.l.Debug("No session to issue a csrf token for", zap.Error(err))
+		return next.ServeHTTP(w, r)
+	}
+
+	if cookie, err := r.Cookie(cc.Cookie.Name); err == nil {
+		if cs, err := s.checkCSRFToken(cookie.Value, binding, repl); err == nil && s.csrfFresh(cs) {
+			repl.Set("http.jwt_signer.csrf_token", cookie.Value)
+			return next.ServeHTTP(w, r)
+		}
+	}
+
+	tosStr, cs, err := s.sign(r, repl, nil, jwt.MapClaims{csrfBindingClaim: binding})
+	if errors.Is(err, errSkipSigning) {
+		return next.ServeHTTP(w, r)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.l.Debug("Issued csrf token", zap.String("cookie", cc.Cookie.Name))
+
+	http.SetCookie(w, cc.Cookie.build(tosStr, cs))
+	repl.Set("http.jwt_signer.csrf_token", tosStr)
+
+	return next.ServeHTTP(w, r)
+}
+
+// checkCSRFRequest requires the header to repeat the token cookie, and the token to belong to the session.
+func (s *JwtSigner) checkCSRFRequest(r *http.Request, repl *caddy.Replacer, binding string) error {
+	cc := s.CSRF
+
+	header := r.Header.Get(cc.Header)
+	if header == "" {
+		return fmt.Errorf("missing %s header", cc.Header)
+	}
+
+	cookie, err := r.Cookie(cc.Cookie.Name)
+	if err != nil {
+		return fmt.Errorf("missing %s cookie", cc.Cookie.Name)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return fmt.Errorf("%s header does not match the %s cookie", cc.Header, cc.Cookie.Name)
+	}
+
+	_, err = s.checkCSRFToken(header, binding, repl)
+
+	return err
+}
+
+// csrfFresh reports whether a presented token is not yet due for refresh by the cookie's refresh_before.
+func (s *JwtSigner) csrfFresh(cs jwt.MapClaims) bool {
+	exp, err := cs.GetExpirationTime()
+	if err != nil || exp == nil {
+		return false
+	}
+
+	return time.Until(exp.Time) > time.Duration(s.CSRF.Cookie.RefreshBefore)
+}
+
+func (cc *CSRFConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Args(&cc.SessionCookie)
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "session_cookie":
+			if !d.Args(&cc.SessionCookie) {
+				return d.ArgErr()
+			}
+		case "session_claim":
+			if !d.Args(&cc.SessionClaim) {
+				return d.ArgErr()
+			}
+		case "header":
+			if !d.Args(&cc.Header) {
+				return d.ArgErr()
+			}
+		case "cookie":
+			cc.Cookie = &CookieConfig{}
+			if err := cc.Cookie.unmarshalCaddyfile(d); err != nil {
+				return err
+			}
+
+			continue
+		case "exempt":
+			paths := d.RemainingArgs()
+			if len(paths) == 0 {
+				return d.ArgErr()
+			}
+
+			cc.Exempt = append(cc.Exempt, paths...)
+		default:
+			return d.Errf("unknown csrf option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,434 @@
+package jwt_signer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	dpopHeader    = "DPoP"
+	dpopScheme    = "DPoP"
+	dpopProofType = "dpop+jwt"
+
+	defaultDPoPMaxAge = time.Minute
+	defaultDPoPLeeway = 5 * time.Second
+
+	htuMatchExact = "exact"
+	htuMatchPath  = "path"
+
+	// maxSeenDPoPProofs bounds the proof jti replay cache.
+	maxSeenDPoPProofs = 100000
+)
+
+var errInvalidDPoPProof = errors.New("invalid DPoP proof")
+
+// jwkPrivateMembers must never appear in the public key of a proof.
+var jwkPrivateMembers = []string{"d", "p", "q", "dp", "dq", "qi", "oth", "k"}
+
+// DPoPConfig checks DPoP proofs (RFC 9449). On the signing side a valid proof binds the issued token to the
+// proof key with the cnf.jkt claim; on the verifying side a bound token is only accepted with a proof by that key.
+type DPoPConfig struct {
+	// Required refuses requests without a proof instead of issuing or accepting unbound tokens.
+	Required bool `json:"required,omitempty"`
+
+	MaxAge     caddy.Duration `json:"max_age,omitempty"`
+	Leeway     caddy.Duration `json:"leeway,omitempty"`
+	Algorithms []string       `json:"algorithms,omitempty"`
+
+	// HTUMatch is exact to compare the full request URL with htu, or path for the path only. HTUBase replaces
+	// scheme and host of the request URL, for servers behind proxies rewriting them.
+	HTUMatch string `json:"htu_match,omitempty"`
+	HTUBase  string `json:"htu_base,omitempty"`
+
+	seen *seenSet
+}
+
+type dpopProof struct {
+	jkt string
+}
+
+func (dc *DPoPConfig) provision() {
+	if dc.MaxAge == 0 {
+		dc.MaxAge = caddy.Duration(defaultDPoPMaxAge)
+	}
+
+	if dc.Leeway == 0 {
+		dc.Leeway = caddy.Duration(defaultDPoPLeeway)
+	}
+
+	if len(dc.Algorithms) == 0 {
+		dc.Algorithms = slices.Clone(jwksMethods)
+	}
+
+	if dc.HTUMatch == "" {
+		dc.HTUMatch = htuMatchExact
+	}
+
+	dc.seen = newSeenSet(maxSeenDPoPProofs)
+}
+
+func (dc *DPoPConfig) validate() error {
+	if dc.MaxAge < 0 || dc.Leeway < 0 {
+		return fmt.Errorf("dpop max_age and leeway must not be negative")
+	}
+
+	for _, alg := range dc.Algorithms {
+		if !slices.Contains(jwksMethods, alg) {
+			return fmt.Errorf("dpop algorithm %s is not an asymmetric algorithm", alg)
+		}
+	}
+
+	switch dc.HTUMatch {
+	case htuMatchExact, htuMatchPath:
+	default:
+		return fmt.Errorf("unknown dpop htu_match mode: %s", dc.HTUMatch)
+	}
+
+	if dc.HTUBase != "" {
+		if u, err := url.Parse(dc.HTUBase); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("dpop htu_base must be an absolute URL: %s", dc.HTUBase)
+		}
+	}
+
+	return nil
+}
+
+// proof validates the DPoP proof of the request. Without a proof it returns nil, leaving it to the caller whether
+// one is required. An access token, when given, has to match the ath claim.
+func (dc *DPoPConfig) proof(r *http.Request, accessToken string) (*dpopProof, error) {
+	vals := r.Header.Values(dpopHeader)
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	if len(vals) > 1 {
+		return nil, fmt.Errorf("%w: more than one DPoP header", errInvalidDPoPProof)
+	}
+
+	var jkt string
+
+	cs := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(vals[0], cs, func(t *jwt.Token) (any, error) {
+		if typ, _ := t.Header["typ"].(string); typ != dpopProofType {
+			return nil, fmt.Errorf("typ is not %s", dpopProofType)
+		}
+
+		key, err := proofKey(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+
+		vk, err := key.verificationKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(vk.methods, t.Method.Alg()) {
+			return nil, fmt.Errorf("algorithm %s does not match the proof key", t.Method.Alg())
+		}
+
+		if jkt, err = key.thumbprint(); err != nil {
+			return nil, err
+		}
+
+		return vk.key, nil
+	}, jwt.WithValidMethods(dc.Algorithms), jwt.WithLeeway(time.Duration(dc.Leeway)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidDPoPProof, err)
+	}
+
+	if err := dc.checkClaims(r, cs, accessToken, jkt); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidDPoPProof, err)
+	}
+
+	return &dpopProof{jkt: jkt}, nil
+}
+
+func (dc *DPoPConfig) checkClaims(r *http.Request, cs jwt.MapClaims, accessToken, jkt string) error {
+	jti, _ := cs["jti"].(string)
+	if jti == "" {
+		return fmt.Errorf("no jti")
+	}
+
+	if htm, _ := cs["htm"].(string); htm != r.Method {
+		return fmt.Errorf("htm %q does not match the request method", htm)
+	}
+
+	htu, _ := cs["htu"].(string)
+	if !dc.matchHTU(r, htu) {
+		return fmt.Errorf("htu %q does not match the request URL", htu)
+	}
+
+	iat, err := cs.GetIssuedAt()
+	if err != nil || iat == nil {
+		return fmt.Errorf("no usable iat")
+	}
+
+	now := time.Now()
+	leeway := time.Duration(dc.Leeway)
+	expires := iat.Add(time.Duration(dc.MaxAge) + leeway)
+
+	if iat.After(now.Add(leeway)) || now.After(expires) {
+		return fmt.Errorf("iat outside the accepted proof age")
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if ath, _ := cs["ath"].(string); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return fmt.Errorf("ath does not match the access token")
+		}
+	}
+
+	if err := dc.seen.add(jkt+" "+jti, expires); err != nil {
+		return fmt.Errorf("jti %q was used before", jti)
+	}
+
+	return nil
+}
+
+// matchHTU compares the htu claim with the URL the client requested, query and fragment left out.
+func (dc *DPoPConfig) matchHTU(r *http.Request, htu string) bool {
+	claimed, err := url.Parse(htu)
+	if err != nil || claimed.Scheme == "" || claimed.Host == "" {
+		return false
+	}
+
+	reqPath := r.URL.Path
+	if orig, ok := r.Context().Value(caddyhttp.OriginalRequestCtxKey).(http.Request); ok {
+		reqPath = orig.URL.Path
+	}
+
+	if dc.HTUMatch == htuMatchPath {
+		return claimed.Path == reqPath
+	}
+
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if dc.HTUBase != "" {
+		base, _ := url.Parse(dc.HTUBase)
+		scheme, host = base.Scheme, base.Host
+	}
+
+	return strings.EqualFold(claimed.Scheme, scheme) &&
+		strings.EqualFold(hostWithoutDefaultPort(claimed.Scheme, claimed.Host), hostWithoutDefaultPort(scheme, host)) &&
+		claimed.Path == reqPath
+}
+
+func hostWithoutDefaultPort(scheme, host string) string {
+	switch {
+	case strings.EqualFold(scheme, "https"):
+		return strings.TrimSuffix(host, ":443")
+	case strings.EqualFold(scheme, "http"):
+		return strings.TrimSuffix(host, ":80")
+	default:
+		return host
+	}
+}
+
+func proofKey(raw any) (*jwk, error) {
+	members, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("no jwk header")
+	}
+
+	for _, m := range jwkPrivateMembers {
+		if _, ok := members[m]; ok {
+			return nil, fmt.Errorf("jwk header carries a private or symmetric key")
+		}
+	}
+
+	buf, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+
+	var key jwk
+	if err := json.Unmarshal(buf, &key); err != nil {
+		return nil, fmt.Errorf("invalid jwk header: %w", err)
+	}
+
+	return &key, nil
+}
+
+// thumbprint computes the RFC 7638 SHA-256 thumbprint: the required members in lexicographic order, no whitespace.
+func (k *jwk) thumbprint() (string, error) {
+	var members []string
+
+	switch k.Kty {
+	case "RSA":
+		members = []string{"e", k.E, "kty", k.Kty, "n", k.N}
+	case "EC":
+		members = []string{"crv", k.Crv, "kty", k.Kty, "x", k.X, "y", k.Y}
+	case "OKP":
+		members = []string{"crv", k.Crv, "kty", k.Kty, "x", k.X}
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+
+	var sb strings.Builder
+
+	sb.WriteByte('{')
+	for i := 0; i < len(members); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+
+		name, _ := json.Marshal(members[i])
+		val, _ := json.Marshal(members[i+1])
+		sb.Write(name)
+		sb.WriteByte(':')
+		sb.Write(val)
+	}
+	sb.WriteByte('}')
+
+	sum := sha256.Sum256([]byte(sb.String()))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// boundThumbprint returns the cnf.jkt claim of a DPoP-bound token, or an empty string for an unbound one.
+func boundThumbprint(cs jwt.MapClaims) string {
+	cnf, _ := cs["cnf"].(map[string]any)
+	jkt, _ := cnf["jkt"].(string)
+
+	return jkt
+}
+
+// bindDPoP adds the cnf.jkt claim for the proof presented with a token request.
+func (s *JwtSigner) bindDPoP(r *http.Request, cs jwt.MapClaims) error {
+	proof, err := s.DPoP.proof(r, "")
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	if proof == nil {
+		if s.DPoP.Required {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("%w: no DPoP header", errInvalidDPoPProof))
+		}
+
+		return nil
+	}
+
+	cs["cnf"] = map[string]any{"jkt": proof.jkt}
+
+	return nil
+}
+
+// checkDPoP accepts a bound token only with the DPoP scheme and a proof by the bound key, and an unbound one only
+// with the Bearer scheme unless proofs are required.
+func (vc *VerifyConfig) checkDPoP(r *http.Request, tosStr string, cs jwt.MapClaims) error {
+	if vc.DPoP == nil {
+		return nil
+	}
+
+	jkt := boundThumbprint(cs)
+	scheme := vc.scheme(r)
+
+	if jkt == "" {
+		if vc.DPoP.Required || strings.EqualFold(scheme, dpopScheme) {
+			return fmt.Errorf("%w: token is not DPoP-bound", errInvalidDPoPProof)
+		}
+
+		return nil
+	}
+
+	if !strings.EqualFold(scheme, dpopScheme) {
+		return fmt.Errorf("DPoP-bound token presented with the %s scheme", scheme)
+	}
+
+	proof, err := vc.DPoP.proof(r, tosStr)
+	if err != nil {
+		return err
+	}
+
+	if proof == nil {
+		return fmt.Errorf("%w: no DPoP header", errInvalidDPoPProof)
+	}
+
+	if proof.jkt != jkt {
+		return fmt.Errorf("%w: proof key does not match the token binding", errInvalidDPoPProof)
+	}
+
+	return nil
+}
+
+func (dc *DPoPConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		if d.Val() != "required" {
+			return d.Errf("unknown dpop argument: %s", d.Val())
+		}
+
+		dc.Required = true
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "required":
+			dc.Required = true
+		case "max_age", "leeway":
+			opt := d.Val()
+
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid dpop %s %s: %v", opt, durStr, err)
+			}
+
+			if opt == "max_age" {
+				dc.MaxAge = caddy.Duration(dur)
+			} else {
+				dc.Leeway = caddy.Duration(dur)
+			}
+		case "algorithms":
+			algs := d.RemainingArgs()
+			if len(algs) == 0 {
+				return d.ArgErr()
+			}
+
+			dc.Algorithms = append(dc.Algorithms, algs...)
+
+			continue
+		case "htu_match":
+			if !d.Args(&dc.HTUMatch) {
+				return d.ArgErr()
+			}
+		case "htu_base":
+			if !d.Args(&dc.HTUBase) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown dpop option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
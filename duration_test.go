@@ -0,0 +1,135 @@
+package jwt_signer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestMinDuration(t *testing.T) {
+	tests := []struct {
+		name, dur, options, header, wantErr string
+	}{
+		{
+			name:    "static zero",
+			dur:     "0s",
+			wantErr: "duration 0s is below the minimum of 1s",
+		},
+		{
+			name:    "static negative",
+			dur:     "-5m",
+			wantErr: "duration -5m0s is below the minimum of 1s",
+		},
+		{
+			name:    "placeholder zero",
+			dur:     "{http.request.header.X-TTL}",
+			header:  "0s",
+			wantErr: "duration 0s is below the minimum of 1s",
+		},
+		{
+			name:    "placeholder negative",
+			dur:     "{http.request.header.X-TTL}",
+			header:  "-5m",
+			wantErr: "duration -5m0s is below the minimum of 1s",
+		},
+		{
+			name:    "below a configured minimum",
+			dur:     "{http.request.header.X-TTL}",
+			options: "min_duration 1m",
+			header:  "30s",
+			wantErr: "duration 30s is below the minimum of 1m0s",
+		},
+		{
+			name:   "at the minimum",
+			dur:    "{http.request.header.X-TTL}",
+			header: "1s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSigner(t, `jwt_signer `+tt.dur+` `+testSecret+` {
+				sub alice
+				options {
+					`+tt.options+`
+				}
+			}`)
+			if err == nil {
+				r, repl := newTestRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-TTL", tt.header)
+
+				if err = s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err == nil {
+					parseTestToken(t, repl)
+				}
+			}
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDurationCookie(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub alice
+		options {
+			duration_source cookie:ttl
+			max_duration 1h
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, cookie string
+		want         float64
+		wantStatus   int
+	}{
+		{name: "duration", cookie: "10m", want: 600},
+		{name: "seconds", cookie: "90", want: 90},
+		{name: "no cookie", want: 300},
+		{name: "malformed", cookie: "soon", wantStatus: http.StatusBadRequest},
+		{name: "zero", cookie: "0s", wantStatus: http.StatusBadRequest},
+		{name: "negative", cookie: "-5m", wantStatus: http.StatusBadRequest},
+		{name: "above the maximum", cookie: "2h", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "ttl", Value: tt.cookie})
+			}
+
+			err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+			if tt.wantStatus != 0 {
+				var he caddyhttp.HandlerError
+				if !errors.As(err, &he) || he.StatusCode != tt.wantStatus {
+					t.Fatalf("error %v, want status %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cs := parseTestToken(t, repl)
+			if got := cs["exp"].(float64) - cs["iat"].(float64); got != tt.want {
+				t.Fatalf("lifetime %vs, want %vs", got, tt.want)
+			}
+		})
+	}
+}
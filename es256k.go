@@ -0,0 +1,185 @@
+package jwt_signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// es256kMethod is ECDSA over secp256k1 with SHA-256 (RFC 8812), which the standard library lacks.
+type es256kMethod struct{}
+
+var signingMethodES256K = &es256kMethod{}
+
+var (
+	oidPublicKeyECDSA  = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveS256K = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+	oidNamedCurveP192  = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 1}
+)
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodES256K.Alg(), func() jwt.SigningMethod {
+		return signingMethodES256K
+	})
+}
+
+func (m *es256kMethod) Alg() string {
+	return "ES256K"
+}
+
+func (m *es256kMethod) Sign(signingString string, key any) ([]byte, error) {
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok || priv.Curve != secp256k1.S256() {
+		return nil, fmt.Errorf("%w: ES256K requires a secp256k1 private key", jwt.ErrInvalidKeyType)
+	}
+
+	var d secp256k1.ModNScalar
+	if overflow := d.SetByteSlice(priv.D.Bytes()); overflow {
+		return nil, fmt.Errorf("%w: secp256k1 private key out of range", jwt.ErrInvalidKey)
+	}
+
+	hash := sha256.Sum256([]byte(signingString))
+	sig := secpecdsa.Sign(secp256k1.NewPrivateKey(&d), hash[:])
+
+	r, s := sig.R(), sig.S()
+	rb, sb := r.Bytes(), s.Bytes()
+
+	return append(rb[:], sb[:]...), nil
+}
+
+func (m *es256kMethod) Verify(signingString string, sig []byte, key any) error {
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != secp256k1.S256() {
+		return fmt.Errorf("%w: ES256K requires a secp256k1 public key", jwt.ErrInvalidKeyType)
+	}
+
+	if len(sig) != 64 {
+		return jwt.ErrSignatureInvalid
+	}
+
+	var r, s secp256k1.ModNScalar
+	if r.SetByteSlice(sig[:32]) || s.SetByteSlice(sig[32:]) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	var x, y secp256k1.FieldVal
+	if x.SetByteSlice(pub.X.Bytes()) || y.SetByteSlice(pub.Y.Bytes()) {
+		return jwt.ErrInvalidKey
+	}
+
+	hash := sha256.Sum256([]byte(signingString))
+	if !secpecdsa.NewSignature(&r, &s).Verify(hash[:], secp256k1.NewPublicKey(&x, &y)) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+type subjectPublicKeyInfo struct {
+	Algo      pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// ecCurveOID returns the named curve of an EC SubjectPublicKeyInfo, nil for anything else.
+func ecCurveOID(der []byte) asn1.ObjectIdentifier {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(der, &spki); err != nil || !spki.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		return nil
+	}
+
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(spki.Algo.Parameters.FullBytes, &curve); err != nil {
+		return nil
+	}
+
+	return curve
+}
+
+func parseSecp256k1PublicKey(der []byte) (*ecdsa.PublicKey, bool) {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(der, &spki); err != nil || !spki.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		return nil, false
+	}
+
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(spki.Algo.Parameters.FullBytes, &curve); err != nil || !curve.Equal(oidNamedCurveS256K) {
+		return nil, false
+	}
+
+	pub, err := secp256k1.ParsePubKey(spki.PublicKey.RightAlign())
+	if err != nil {
+		return nil, false
+	}
+
+	return pub.ToECDSA(), true
+}
+
+type sec1PrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+type pkcs8PrivateKey struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// isP192PrivateKey recognizes SEC 1 and PKCS #8 encoded P-192 keys, which crypto/x509 refuses as an unknown curve,
+// so the operator gets told why.
+func isP192PrivateKey(blockType string, der []byte) bool {
+	if blockType == "PRIVATE KEY" {
+		var p8 pkcs8PrivateKey
+		if _, err := asn1.Unmarshal(der, &p8); err != nil || !p8.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+			return false
+		}
+
+		var curve asn1.ObjectIdentifier
+		_, err := asn1.Unmarshal(p8.Algo.Parameters.FullBytes, &curve)
+
+		return err == nil && curve.Equal(oidNamedCurveP192)
+	}
+
+	var key sec1PrivateKey
+	_, err := asn1.Unmarshal(der, &key)
+
+	return err == nil && key.NamedCurveOID.Equal(oidNamedCurveP192)
+}
+
+// parseSecp256k1PEMBlock parses SEC 1 and PKCS #8 encoded secp256k1 keys, which crypto/x509 refuses
+// as an unknown curve.
+func parseSecp256k1PEMBlock(blockType string, der []byte) (crypto.Signer, bool) {
+	if blockType == "PRIVATE KEY" {
+		var p8 pkcs8PrivateKey
+		if _, err := asn1.Unmarshal(der, &p8); err != nil || !p8.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+			return nil, false
+		}
+
+		var curve asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(p8.Algo.Parameters.FullBytes, &curve); err != nil || !curve.Equal(oidNamedCurveS256K) {
+			return nil, false
+		}
+
+		der = p8.PrivateKey
+	}
+
+	var key sec1PrivateKey
+	if _, err := asn1.Unmarshal(der, &key); err != nil || len(key.PrivateKey) > 32 {
+		return nil, false
+	}
+
+	if blockType != "PRIVATE KEY" && !key.NamedCurveOID.Equal(oidNamedCurveS256K) {
+		return nil, false
+	}
+
+	return secp256k1.PrivKeyFromBytes(key.PrivateKey).ToECDSA(), true
+}
@@ -0,0 +1,202 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// ExchangeConfig verifies an inbound token and re-issues its claims under the module's own key.
+type ExchangeConfig struct {
+	VerifyConfig
+
+	Key    *KeyConfig        `json:"key,omitempty"`
+	Rename map[string]string `json:"rename,omitempty"`
+	Drop   []string          `json:"drop,omitempty"`
+
+	key *verificationKey
+}
+
+// registered claims of a presented token which never carry over to a re-issued one
+var reissueStripClaims = []string{"iat", "exp", "nbf", "jti"}
+
+func (ec *ExchangeConfig) provision(ctx caddy.Context, l *zap.Logger) error {
+	ec.VerifyConfig.provision()
+
+	if ec.JWKS != nil {
+		if err := ec.JWKS.provision(ctx, l); err != nil {
+			return fmt.Errorf("exchange jwks: %w", err)
+		}
+	}
+
+	if ec.Key != nil {
+		key, err := ec.Key.loadVerification()
+		if err != nil {
+			return fmt.Errorf("exchange key: %w", err)
+		}
+
+		ec.key = key
+	}
+
+	return nil
+}
+
+func (ec *ExchangeConfig) validate() error {
+	if err := ec.VerifyConfig.validate(); err != nil {
+		return err
+	}
+
+	if ec.Key != nil && ec.JWKS != nil {
+		return fmt.Errorf("exchange key and jwks are mutually exclusive")
+	}
+
+	for from, to := range ec.Rename {
+		if from == "" || to == "" {
+			return fmt.Errorf("exchange rename requires non-empty claim names")
+		}
+	}
+
+	return nil
+}
+
+func (ec *ExchangeConfig) mapClaims(in jwt.MapClaims) jwt.MapClaims {
+	out := make(jwt.MapClaims, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+
+	for _, k := range reissueStripClaims {
+		delete(out, k)
+	}
+
+	for from, to := range ec.Rename {
+		if v, ok := out[from]; ok {
+			delete(out, from)
+			out[to] = v
+		}
+	}
+
+	for _, k := range ec.Drop {
+		delete(out, k)
+	}
+
+	return out
+}
+
+func (s *JwtSigner) parseInbound(tosStr string, repl *caddy.Replacer) (jwt.MapClaims, error) {
+	opts := s.Exchange.parserOptions()
+
+	if s.Exchange.JWKS != nil {
+		return s.Exchange.JWKS.parse(tosStr, opts...)
+	}
+
+	if s.Exchange.key == nil {
+		return s.parse(tosStr, repl, opts...)
+	}
+
+	return s.Exchange.key.parse(tosStr, opts...)
+}
+
+func (s *JwtSigner) serveExchange(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	inStr := s.Exchange.token(r)
+	if inStr == "" || s.Exchange.presentedMalformed(r) {
+		return s.rejectPresented(w, "", s.Exchange.missingToken(r))
+	}
+
+	in, err := s.parseInbound(inStr, repl)
+	if err == nil {
+		err = s.Exchange.expect(inStr, in)
+	}
+	if err == nil {
+		err = s.checkRevoked(in)
+	}
+	if err == nil {
+		err = s.Exchange.checkScopes(in)
+	}
+	if err != nil {
+		return s.rejectPresented(w, inStr, err)
+	}
+
+	if s.Exchange.SingleUse {
+		if err := s.consumeToken(w, r, inStr, in); err != nil {
+			return err
+		}
+	}
+
+	if s.Exchange.ReplayProtection != nil {
+		if err := s.checkNonce(w, r, s.Exchange.ReplayProtection, inStr, in); err != nil {
+			return err
+		}
+	}
+
+	s.setClaimPlaceholders(repl, in)
+
+	extra, err := s.extraClaims(repl)
+	if err != nil {
+		return err
+	}
+
+	tosStr, cs, err := s.sign(r, repl, s.Exchange.mapClaims(in), extra)
+	if err != nil {
+		s.l.Error("Signing exchanged token failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, fmt.Errorf("signing exchanged token: %w", err))
+	}
+
+	s.l.Debug("Token exchanged", zap.Int("inbound_claims", len(in)), zap.Int("claims", len(cs)))
+
+	return s.output(w, r, repl, next, tosStr, cs)
+}
+
+func (ec *ExchangeConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "key":
+			ec.Key = &KeyConfig{}
+			if err := ec.Key.unmarshalCaddyfile(d); err != nil {
+				return err
+			}
+
+			continue
+		case "rename":
+			var from, to string
+			if !d.Args(&from, &to) {
+				return d.ArgErr()
+			}
+
+			if ec.Rename == nil {
+				ec.Rename = map[string]string{}
+			}
+
+			ec.Rename[from] = to
+		case "drop":
+			claims := d.RemainingArgs()
+			if len(claims) == 0 {
+				return d.ArgErr()
+			}
+
+			ec.Drop = append(ec.Drop, claims...)
+			continue
+		default:
+			if err := ec.VerifyConfig.unmarshalOption(d); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package jwt_signer
+
+import (
+	"crypto"
+	"crypto/hmac"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	hashClaimHash = "hash"
+	hashClaimHMAC = "hmac"
+
+	hashEncodingHex       = "hex"
+	hashEncodingBase64URL = "base64url"
+)
+
+var hashClaimAlgorithms = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// HashClaim holds the digest of a value instead of the value itself, so a token can be bound to an identifier such
+// as a session id without carrying it.
+type HashClaim struct {
+	// Function is hash for a plain digest or hmac for one keyed with hash_claim_key.
+	Function string `json:"function"`
+
+	// Algorithm is sha256, sha384 or sha512.
+	Algorithm string `json:"algorithm"`
+
+	// Value is digested after placeholders are replaced, a value resolving empty counts as a missing claim source.
+	Value string `json:"value"`
+}
+
+func (s *JwtSigner) validateHashClaims() error {
+	if len(s.HashClaims) == 0 {
+		if s.HashClaimKey != "" || s.HashClaimEncoding != "" {
+			return fmt.Errorf("hash_claim_key and hash_claim_encoding require a hash or hmac claim")
+		}
+
+		return nil
+	}
+
+	switch s.HashClaimEncoding {
+	case hashEncodingHex, hashEncodingBase64URL:
+	default:
+		return fmt.Errorf("unknown hash_claim_encoding: %s", s.HashClaimEncoding)
+	}
+
+	for claim, hc := range s.HashClaims {
+		if _, ok := hashClaimAlgorithms[hc.Algorithm]; !ok {
+			return fmt.Errorf("unsupported algorithm for hash claim %s: %s", claim, hc.Algorithm)
+		}
+
+		switch hc.Function {
+		case hashClaimHash:
+		case hashClaimHMAC:
+			if s.HashClaimKey == "" {
+				return fmt.Errorf("hmac claim %s requires hash_claim_key", claim)
+			}
+		default:
+			return fmt.Errorf("unknown function for hash claim %s: %s", claim, hc.Function)
+		}
+
+		if hc.Value == "" {
+			return fmt.Errorf("hash claim %s requires a value", claim)
+		}
+
+		if _, ok := s.Claims[claim]; ok {
+			return fmt.Errorf("claim %s is defined both as a claim value and as a hash", claim)
+		}
+
+		if _, ok := s.EnvClaims[claim]; ok {
+			return fmt.Errorf("claim %s is defined both from the environment and as a hash", claim)
+		}
+	}
+
+	return nil
+}
+
+// addHashClaims digests the values of the hash claims into cs.
+func (s *JwtSigner) addHashClaims(cs jwt.MapClaims, repl *caddy.Replacer, missing missingFunc) error {
+	for claim, hc := range s.HashClaims {
+		val := repl.ReplaceAll(hc.Value, "")
+		if val == "" {
+			if err := missing(claim, "hash input "+hc.Value); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		h := hashClaimAlgorithms[hc.Algorithm]
+
+		hasher := h.New()
+		if hc.Function == hashClaimHMAC {
+			key := repl.ReplaceAll(s.HashClaimKey, "")
+			if key == "" {
+				return fmt.Errorf("required parameter empty after replacements: hash_claim_key")
+			}
+
+			hasher = hmac.New(h.New, []byte(key))
+		}
+
+		hasher.Write([]byte(val))
+		sum := hasher.Sum(nil)
+
+		if s.HashClaimEncoding == hashEncodingBase64URL {
+			cs[claim] = base64.RawURLEncoding.EncodeToString(sum)
+		} else {
+			cs[claim] = hex.EncodeToString(sum)
+		}
+	}
+
+	return nil
+}
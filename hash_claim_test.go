@@ -0,0 +1,123 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHashClaims(t *testing.T) {
+	tests := []struct {
+		name, cfg, want string
+	}{
+		{
+			name: "sha256 hex",
+			cfg:  `sid hash sha256 {http.request.header.X-Session}`,
+			// FIPS 180-2 appendix B.1
+			want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+		{
+			name: "sha256 base64url",
+			cfg: `sid hash sha256 {http.request.header.X-Session}
+				options {
+					hash_claim_encoding base64url
+				}`,
+			want: "ungWv48Bz-pBQUDeXa4iI7ADYaOWF3qctBD_YfIAFa0",
+		},
+		{
+			name: "hmac sha256",
+			cfg: `sid hmac sha256 {http.request.header.X-Data}
+				options {
+					hash_claim_key Jefe
+				}`,
+			// RFC 4231 test case 2
+			want: "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				`+tt.cfg+`
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Session", "abc")
+			r.Header.Set("X-Data", "what do ya want for nothing?")
+
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := parseTestToken(t, repl)["sid"]; got != tt.want {
+				t.Fatalf("sid = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashClaimMissingValue(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sid hash sha256 {http.request.header.X-Session}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	if sid, ok := parseTestToken(t, repl)["sid"]; ok {
+		t.Fatalf("sid set without a value: %v", sid)
+	}
+}
+
+func TestValidateHashClaims(t *testing.T) {
+	tests := []struct {
+		name, cfg, err string
+	}{
+		{
+			name: "unknown algorithm",
+			cfg:  `sid hash md5 {http.request.header.X-Session}`,
+			err:  "unsupported algorithm for hash claim sid: md5",
+		},
+		{
+			name: "hmac without a key",
+			cfg:  `sid hmac sha256 {http.request.header.X-Session}`,
+			err:  "hmac claim sid requires hash_claim_key",
+		},
+		{
+			name: "unknown encoding",
+			cfg: `sid hash sha256 {http.request.header.X-Session}
+				options {
+					hash_claim_encoding base32
+				}`,
+			err: "unknown hash_claim_encoding: base32",
+		},
+		{
+			name: "key without a hash claim",
+			cfg: `options {
+					hash_claim_key k
+				}`,
+			err: "require a hash or hmac claim",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				`+tt.cfg+`
+			}`)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
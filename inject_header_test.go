@@ -0,0 +1,62 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInjectHeader(t *testing.T) {
+	tests := []struct {
+		name, cfg, header, prefix string
+	}{
+		{
+			name:   "bearer by default",
+			cfg:    `inject_header`,
+			header: "Authorization",
+			prefix: "Bearer ",
+		},
+		{
+			name: "custom scheme",
+			cfg: `inject_header
+				header_prefix Token`,
+			header: "Authorization",
+			prefix: "Token ",
+		},
+		{
+			name: "raw token",
+			cfg: `inject_header X-Token
+				header_prefix ""`,
+			header: "X-Token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				sub alice
+				options {
+					`+tt.cfg+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+			if tosStr == "" {
+				t.Fatal("no token signed")
+			}
+
+			if got, want := r.Header.Get(tt.header), tt.prefix+tosStr; got != want {
+				t.Fatalf("%s = %q, want %q", tt.header, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package jwt_signer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(&JwtIntrospector{})
+	httpcaddyfile.RegisterHandlerDirective("jwt_signer_introspect", parseIntrospectorCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("jwt_signer_introspect", httpcaddyfile.Before, "respond")
+}
+
+// introspectionClaims are the members of an RFC 7662 response which are copied from the token when present.
+var introspectionClaims = []string{"scope", "client_id", "username", "token_type", "exp", "iat", "nbf", "sub", "aud", "iss", "jti"}
+
+// JwtIntrospector is a terminal handler answering RFC 7662 introspection requests for tokens issued by
+// jwt_signer, so internal services need not verify them themselves.
+type JwtIntrospector struct {
+	KeyConfig
+
+	Revocation *RevocationConfig `json:"revocation,omitempty"`
+
+	// Callers authenticate with this Bearer secret, a verified client certificate, or both when both are set.
+	ClientSecret      string `json:"client_secret,omitempty"`
+	RequireClientCert bool   `json:"require_client_cert,omitempty"`
+
+	// Claims lists custom claims included in responses for active tokens besides the standard ones.
+	Claims []string `json:"claims,omitempty"`
+
+	RequestBodyLimitBytes int64 `json:"request_body_limit_bytes,omitempty"`
+
+	key          *verificationKey
+	clientSecret []byte
+	l            *zap.Logger
+}
+
+func (*JwtIntrospector) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.jwt_signer_introspect",
+		New: func() caddy.Module { return new(JwtIntrospector) },
+	}
+}
+
+func (in *JwtIntrospector) Provision(ctx caddy.Context) error {
+	in.l = ctx.Logger()
+
+	key, err := in.KeyConfig.loadVerification()
+	if err != nil {
+		return err
+	}
+
+	in.key = key
+
+	if in.RequestBodyLimitBytes == 0 {
+		in.RequestBodyLimitBytes = defaultRequestBodyLimit
+	}
+
+	if in.ClientSecret != "" {
+		in.clientSecret = []byte(caddy.NewReplacer().ReplaceKnown(in.ClientSecret, ""))
+	}
+
+	if in.Revocation != nil {
+		return in.Revocation.provision(ctx, in.l)
+	}
+
+	return nil
+}
+
+func (in *JwtIntrospector) Validate() error {
+	if in.ClientSecret == "" && !in.RequireClientCert {
+		return fmt.Errorf("introspection requires client_secret or require_client_cert")
+	}
+
+	if in.ClientSecret != "" && len(in.clientSecret) == 0 {
+		return fmt.Errorf("client_secret is empty after replacements")
+	}
+
+	if in.RequestBodyLimitBytes < 0 {
+		return fmt.Errorf("request body limit must not be negative")
+	}
+
+	return nil
+}
+
+func (in *JwtIntrospector) Cleanup() error {
+	if in.Revocation != nil {
+		in.Revocation.cleanup()
+	}
+
+	return nil
+}
+
+func (in *JwtIntrospector) authenticate(r *http.Request) bool {
+	// a certificate the server requested but did not verify proves nothing
+	if in.RequireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return false
+	}
+
+	if len(in.clientSecret) > 0 {
+		presented := []byte(bearerToken(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(presented, in.clientSecret) != 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (in *JwtIntrospector) ServeHTTP(w http.ResponseWriter, r *http.Request, _ caddyhttp.Handler) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return caddyhttp.Error(http.StatusMethodNotAllowed, fmt.Errorf("introspection requires POST"))
+	}
+
+	if !in.authenticate(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return caddyhttp.Error(http.StatusUnauthorized, fmt.Errorf("introspection caller not authenticated"))
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, in.RequestBodyLimitBytes)
+	if err := r.ParseForm(); err != nil {
+		if bodyTooLarge(err) {
+			return caddyhttp.Error(http.StatusRequestEntityTooLarge, err)
+		}
+
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("parsing introspection request: %w", err))
+	}
+
+	tosStr := r.PostForm.Get("token")
+	if tosStr == "" {
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("no token presented for introspection"))
+	}
+
+	resp := map[string]any{"active": false}
+
+	cs, err := in.key.parse(tosStr, jwt.WithJSONNumber())
+	if err == nil && in.Revocation != nil {
+		if jti, _ := cs["jti"].(string); jti != "" && in.Revocation.list.contains(jti) {
+			err = errTokenRevoked
+		}
+	}
+
+	if err != nil {
+		in.l.Debug("Introspected token is not active", zap.Error(err))
+	} else {
+		resp["active"] = true
+
+		for _, claims := range [][]string{introspectionClaims, in.Claims} {
+			for _, k := range claims {
+				if v, ok := cs[k]; ok {
+					resp[k] = v
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return json.NewEncoder(w).Encode(resp)
+}
+
+func (in *JwtIntrospector) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+
+	d.Args(&in.Secret)
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "secret":
+			if !d.Args(&in.Secret) {
+				return d.ArgErr()
+			}
+		case "key_file":
+			if !d.Args(&in.KeyFile) {
+				return d.ArgErr()
+			}
+		case "client_secret":
+			if !d.Args(&in.ClientSecret) {
+				return d.ArgErr()
+			}
+		case "require_client_cert":
+			in.RequireClientCert = true
+		case "claims":
+			claims := d.RemainingArgs()
+			if len(claims) == 0 {
+				return d.ArgErr()
+			}
+
+			in.Claims = append(in.Claims, claims...)
+		case "request_body_limit_bytes", "max_body_bytes":
+			limit, err := parseSizeArg(d)
+			if err != nil {
+				return err
+			}
+
+			in.RequestBodyLimitBytes = limit
+		case "revocation":
+			in.Revocation = &RevocationConfig{}
+			if err := in.Revocation.unmarshalCaddyfile(d); err != nil {
+				return err
+			}
+
+			continue
+		default:
+			return d.Errf("unknown jwt_signer_introspect option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
+
+func parseIntrospectorCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	in := JwtIntrospector{}
+	err := in.UnmarshalCaddyfile(h.Dispenser)
+	return &in, err
+}
+
+var (
+	_ caddy.Provisioner           = (*JwtIntrospector)(nil)
+	_ caddy.Validator             = (*JwtIntrospector)(nil)
+	_ caddy.CleanerUpper          = (*JwtIntrospector)(nil)
+	_ caddyhttp.MiddlewareHandler = (*JwtIntrospector)(nil)
+	_ caddyfile.Unmarshaler       = (*JwtIntrospector)(nil)
+)
@@ -0,0 +1,145 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func provisionIntrospector(t *testing.T, cfg string) *JwtIntrospector {
+	t.Helper()
+
+	in := &JwtIntrospector{}
+	if err := in.UnmarshalCaddyfile(caddyfile.NewTestDispenser(cfg)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+
+	if err := in.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = in.Cleanup() })
+
+	if err := in.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return in
+}
+
+func TestIntrospectAuthentication(t *testing.T) {
+	tosStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &x509.Certificate{Raw: []byte("client")}
+
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	verified := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+
+	tests := []struct {
+		name, options, auth string
+		tls                 *tls.ConnectionState
+		wantStatus          int
+	}{
+		{
+			name:       "no certificate",
+			options:    "require_client_cert",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unverified certificate",
+			options:    "require_client_cert",
+			tls:        unverified,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "verified certificate",
+			options: "require_client_cert",
+			tls:     verified,
+		},
+		{
+			name:       "wrong client secret",
+			options:    "client_secret caller-secret",
+			auth:       "Bearer other",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "client secret",
+			options: "client_secret caller-secret",
+			auth:    "Bearer caller-secret",
+		},
+		{
+			name:       "client secret without a verified certificate",
+			options:    "client_secret caller-secret\nrequire_client_cert",
+			auth:       "Bearer caller-secret",
+			tls:        unverified,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "client secret and verified certificate",
+			options: "client_secret caller-secret\nrequire_client_cert",
+			auth:    "Bearer caller-secret",
+			tls:     verified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := provisionIntrospector(t, `jwt_signer_introspect `+testSecret+` {
+				`+tt.options+`
+			}`)
+
+			form := url.Values{"token": {tosStr}}.Encode()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Authorization", tt.auth)
+			r.TLS = tt.tls
+
+			w := httptest.NewRecorder()
+
+			err := in.ServeHTTP(w, r, nopHandler)
+			if tt.wantStatus != 0 {
+				var he caddyhttp.HandlerError
+				if !errors.As(err, &he) || he.StatusCode != tt.wantStatus {
+					t.Fatalf("error %v, want status %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var resp map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp["active"] != true || resp["sub"] != "alice" {
+				t.Fatalf("response %v, want the token active", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package jwt_signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// IdempotentJTIConfig derives the jti from an idempotency key sent by the client, so a retried request is issued a
+// token with the same jti instead of a new one. Requests without the key get a random jti.
+type IdempotentJTIConfig struct {
+	// From is a placeholder holding the idempotency key, such as {http.request.header.Idempotency-Key}.
+	From string `json:"from"`
+
+	// Secret is the HMAC key the jti is derived with, the signing secret by default. It has to stay the same across
+	// restarts and instances for retries to keep their jti.
+	Secret string `json:"secret,omitempty"`
+}
+
+func (ic *IdempotentJTIConfig) validate(signingSecret string) error {
+	if !strings.Contains(ic.From, "{") {
+		return fmt.Errorf("idempotent_jti requires a placeholder holding the idempotency key: %s", ic.From)
+	}
+
+	if ic.Secret == "" && signingSecret == "" {
+		return fmt.Errorf("idempotent_jti requires a secret when not signing with one")
+	}
+
+	return nil
+}
+
+func (ic *IdempotentJTIConfig) generate(repl *caddy.Replacer, signingSecret string) (string, error) {
+	idem := repl.ReplaceAll(ic.From, "")
+	if idem == "" {
+		return newTokenID()
+	}
+
+	secret := ic.Secret
+	if secret == "" {
+		secret = signingSecret
+	}
+
+	mac := hmac.New(sha256.New, []byte(repl.ReplaceAll(secret, "")))
+	mac.Write([]byte(idem))
+
+	// the same length as a random jti
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+func (ic *IdempotentJTIConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&ic.From) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "secret":
+			if !d.Args(&ic.Secret) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown idempotent_jti option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
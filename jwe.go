@@ -0,0 +1,233 @@
+package jwt_signer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+const (
+	jweAlgorithmECDHES     = "ECDH-ES"
+	jweAlgorithmRSAOAEP    = "RSA-OAEP"
+	jweAlgorithmRSAOAEP256 = "RSA-OAEP-256"
+
+	jweEncA128GCM = "A128GCM"
+	jweEncA192GCM = "A192GCM"
+	jweEncA256GCM = "A256GCM"
+)
+
+type jweEncrypter struct {
+	alg       string
+	enc       string
+	keyLen    int
+	recipient *ecdh.PublicKey
+	crv       string
+	rsa       *rsa.PublicKey
+}
+
+func jweKeyLen(enc string) (int, error) {
+	switch enc {
+	case jweEncA128GCM:
+		return 16, nil
+	case jweEncA192GCM:
+		return 24, nil
+	case jweEncA256GCM:
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupported jwe_enc: %s", enc)
+	}
+}
+
+func loadJWEEncrypter(alg, enc, keyFile string) (*jweEncrypter, error) {
+	switch alg {
+	case jweAlgorithmECDHES, jweAlgorithmRSAOAEP, jweAlgorithmRSAOAEP256:
+	default:
+		return nil, fmt.Errorf("unsupported jwe_algorithm: %s", alg)
+	}
+
+	keyLen, err := jweKeyLen(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyFile == "" {
+		return nil, fmt.Errorf("jwe_algorithm requires jwe_recipient_key_file")
+	}
+
+	path := caddy.NewReplacer().ReplaceKnown(keyFile, "")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading recipient key file: %w", err)
+	}
+
+	pub, err := parsePublicKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("recipient key file %s: %w", path, err)
+	}
+
+	if alg != jweAlgorithmECDHES {
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA recipient key, got %T", alg, pub)
+		}
+
+		if rsaPub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%s requires an RSA recipient key of at least %d bits", alg, minRSAKeyBits)
+		}
+
+		return &jweEncrypter{alg: alg, enc: enc, keyLen: keyLen, rsa: rsaPub}, nil
+	}
+
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s requires an EC recipient key, got %T", alg, pub)
+	}
+
+	recipient, err := ecPub.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("recipient key: %w", err)
+	}
+
+	return &jweEncrypter{
+		alg:       alg,
+		enc:       enc,
+		keyLen:    keyLen,
+		recipient: recipient,
+		crv:       ecPub.Curve.Params().Name,
+	}, nil
+}
+
+// encrypt wraps a signed token into a compact JWE (RFC 7516). The content key is either agreed directly with
+// ECDH-ES (RFC 7518, section 4.6) using a fresh ephemeral key, or generated per token and wrapped with RSA-OAEP
+// (section 4.3).
+func (e *jweEncrypter) encrypt(plaintext []byte) (string, error) {
+	if e.rsa != nil {
+		return e.encryptRSA(plaintext)
+	}
+
+	eph, err := e.recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	z, err := eph.ECDH(e.recipient)
+	if err != nil {
+		return "", err
+	}
+
+	// the public key is encoded uncompressed, 0x04 followed by the coordinates
+	point := eph.PublicKey().Bytes()[1:]
+	size := len(point) / 2
+
+	header, err := json.Marshal(map[string]any{
+		"alg": e.alg,
+		"enc": e.enc,
+		"cty": "JWT",
+		"epk": map[string]string{
+			"kty": "EC",
+			"crv": e.crv,
+			"x":   base64.RawURLEncoding.EncodeToString(point[:size]),
+			"y":   base64.RawURLEncoding.EncodeToString(point[size:]),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	cek := concatKDF(z, e.enc, nil, nil, e.keyLen)
+
+	return sealJWE(header, nil, cek, plaintext)
+}
+
+func (e *jweEncrypter) encryptRSA(plaintext []byte) (string, error) {
+	header, err := json.Marshal(map[string]any{
+		"alg": e.alg,
+		"enc": e.enc,
+		"cty": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	cek := make([]byte, e.keyLen)
+	if _, err := rand.Read(cek); err != nil {
+		return "", err
+	}
+
+	var h hash.Hash = sha1.New()
+	if e.alg == jweAlgorithmRSAOAEP256 {
+		h = sha256.New()
+	}
+
+	wrapped, err := rsa.EncryptOAEP(h, rand.Reader, e.rsa, cek, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return sealJWE(header, wrapped, cek, plaintext)
+}
+
+// sealJWE encrypts the plaintext with AES-GCM under the content key and assembles the compact serialization.
+func sealJWE(header, encryptedKey, cek, plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	protected := base64.RawURLEncoding.EncodeToString(header)
+
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return protected + "." +
+		base64.RawURLEncoding.EncodeToString(encryptedKey) + "." +
+		base64.RawURLEncoding.EncodeToString(iv) + "." +
+		base64.RawURLEncoding.EncodeToString(ciphertext) + "." +
+		base64.RawURLEncoding.EncodeToString(tag), nil
+}
+
+// concatKDF is the single-step KDF of NIST SP 800-56A with SHA-256, parameterized as RFC 7518, section 4.6.2
+// requires for direct key agreement.
+func concatKDF(z []byte, algID string, apu, apv []byte, keyLen int) []byte {
+	var otherInfo []byte
+	for _, field := range [][]byte{[]byte(algID), apu, apv} {
+		otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(field)))
+		otherInfo = append(otherInfo, field...)
+	}
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keyLen*8))
+
+	var out []byte
+	for counter := uint32(1); len(out) < keyLen; counter++ {
+		h := sha256.New()
+		_ = binary.Write(h, binary.BigEndian, counter)
+		h.Write(z)
+		h.Write(otherInfo)
+		out = h.Sum(out)
+	}
+
+	return out[:keyLen]
+}
@@ -0,0 +1,185 @@
+package jwt_signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func b64(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// writePublicKeyPEM writes the key as a PKIX PEM file and returns its path.
+func writePublicKeyPEM(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pub.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// TestConcatKDF follows the ECDH-ES example of RFC 7518, appendix C.
+func TestConcatKDF(t *testing.T) {
+	curve := ecdh.P256()
+
+	alice, err := curve.NewPrivateKey(b64(t, "0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobPoint := append([]byte{4}, b64(t, "weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ")...)
+	bobPoint = append(bobPoint, b64(t, "e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck")...)
+
+	bob, err := curve.NewPublicKey(bobPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := alice.ECDH(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantZ := []byte{158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156, 251, 49, 110, 163,
+		218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196}
+	if !bytes.Equal(z, wantZ) {
+		t.Fatalf("shared secret = %v, want %v", z, wantZ)
+	}
+
+	key := concatKDF(z, jweEncA128GCM, []byte("Alice"), []byte("Bob"), 16)
+	if got := base64.RawURLEncoding.EncodeToString(key); got != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Fatalf("derived key = %s, want VqqN6vgjbSBcIijNcacQGg", got)
+	}
+}
+
+func TestConcatKDFLength(t *testing.T) {
+	z := bytes.Repeat([]byte{1}, 32)
+
+	for _, keyLen := range []int{16, 24, 32, 48, 64} {
+		if got := len(concatKDF(z, jweEncA256GCM, nil, nil, keyLen)); got != keyLen {
+			t.Errorf("concatKDF(%d) returned %d bytes", keyLen, got)
+		}
+	}
+}
+
+// TestJWERoundTrip decrypts the tokens with go-jose, an implementation independent of the one under test.
+func TestJWERoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKeys := map[string]*ecdsa.PrivateKey{}
+	for name, curve := range map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()} {
+		if ecKeys[name], err = ecdsa.GenerateKey(curve, rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		alg, enc string
+		key      crypto.Signer
+	}{
+		{jweAlgorithmECDHES, jweEncA128GCM, ecKeys["P-256"]},
+		{jweAlgorithmECDHES, jweEncA192GCM, ecKeys["P-256"]},
+		{jweAlgorithmECDHES, jweEncA256GCM, ecKeys["P-256"]},
+		{jweAlgorithmECDHES, jweEncA256GCM, ecKeys["P-384"]},
+		{jweAlgorithmECDHES, jweEncA256GCM, ecKeys["P-521"]},
+		{jweAlgorithmRSAOAEP, jweEncA128GCM, rsaKey},
+		{jweAlgorithmRSAOAEP, jweEncA256GCM, rsaKey},
+		{jweAlgorithmRSAOAEP256, jweEncA256GCM, rsaKey},
+	}
+
+	plaintext := []byte("eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJ1In0.c2ln")
+
+	for _, tt := range tests {
+		name := tt.alg + "/" + tt.enc
+		if ec, ok := tt.key.(*ecdsa.PrivateKey); ok {
+			name += "/" + ec.Curve.Params().Name
+		}
+
+		t.Run(name, func(t *testing.T) {
+			e, err := loadJWEEncrypter(tt.alg, tt.enc, writePublicKeyPEM(t, tt.key.Public()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tok, err := e.encrypt(plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			obj, err := jose.ParseEncrypted(tok,
+				[]jose.KeyAlgorithm{jose.KeyAlgorithm(tt.alg)},
+				[]jose.ContentEncryption{jose.ContentEncryption(tt.enc)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cty := obj.Header.ExtraHeaders[jose.HeaderContentType]; cty != "JWT" {
+				t.Errorf("cty = %v, want JWT", cty)
+			}
+
+			got, err := obj.Decrypt(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, plaintext) {
+				t.Fatalf("decrypted %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestJWEEncrypterRejectsKeyType(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadJWEEncrypter(jweAlgorithmECDHES, jweEncA256GCM, writePublicKeyPEM(t, &rsaKey.PublicKey)); err == nil {
+		t.Error("ECDH-ES accepted an RSA recipient key")
+	}
+
+	if _, err := loadJWEEncrypter(jweAlgorithmRSAOAEP, jweEncA256GCM, writePublicKeyPEM(t, &ecKey.PublicKey)); err == nil {
+		t.Error("RSA-OAEP accepted an EC recipient key")
+	}
+
+	if _, err := loadJWEEncrypter(jweAlgorithmECDHES, "A256CBC-HS512", writePublicKeyPEM(t, &ecKey.PublicKey)); err == nil {
+		t.Error("unsupported jwe_enc accepted")
+	}
+}
@@ -0,0 +1,517 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+	defaultJWKSTimeout         = 10 * time.Second
+	defaultJWKSNegativeTTL     = 5 * time.Minute
+
+	// jwksMinRefetch bounds how often tokens with an unknown kid can trigger a fetch.
+	jwksMinRefetch = 10 * time.Second
+
+	maxJWKSBytes       = 1 << 20
+	maxJWKSUnknownKIDs = 1024
+)
+
+// jwksMethods are the algorithms a remote key can verify, HMAC is never accepted from a key set.
+var jwksMethods = []string{
+	jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg(),
+	jwt.SigningMethodPS256.Alg(), jwt.SigningMethodPS384.Alg(), jwt.SigningMethodPS512.Alg(),
+	jwt.SigningMethodES256.Alg(), jwt.SigningMethodES384.Alg(), jwt.SigningMethodES512.Alg(),
+	signingMethodES256K.Alg(), jwt.SigningMethodEdDSA.Alg(),
+}
+
+// keySets are shared by all configs fetching the same key set alike, across config reloads too.
+var keySets = caddy.NewUsagePool()
+
+// JWKSConfig verifies tokens against the keys an identity provider publishes as a JSON Web Key Set (RFC 7517).
+// Keys are cached by kid and refreshed in the background; a failed refresh keeps the keys fetched before.
+type JWKSConfig struct {
+	URL              string         `json:"url"`
+	RefreshInterval  caddy.Duration `json:"refresh_interval,omitempty"`
+	Timeout          caddy.Duration `json:"timeout,omitempty"`
+	CAFile           string         `json:"ca_file,omitempty"`
+	NegativeCacheTTL caddy.Duration `json:"negative_cache_ttl,omitempty"`
+
+	poolKey string
+	keySet  *keySet
+}
+
+// keySet holds the keys fetched from a key set until the last config using it is unloaded.
+type keySet struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	url         string
+	client      *http.Client
+	l           *zap.Logger
+	negativeTTL time.Duration
+
+	fetchMu sync.Mutex
+	mu      sync.RWMutex
+	keys    map[string]*verificationKey
+	unknown map[string]time.Time
+	fetched time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	// private and symmetric key material, only read by signing_jwks
+	D string `json:"d"`
+	P string `json:"p"`
+	Q string `json:"q"`
+	K string `json:"k"`
+}
+
+func (jc *JWKSConfig) provision(ctx caddy.Context, l *zap.Logger) error {
+	if jc.RefreshInterval == 0 {
+		jc.RefreshInterval = caddy.Duration(defaultJWKSRefreshInterval)
+	}
+
+	if jc.Timeout == 0 {
+		jc.Timeout = caddy.Duration(defaultJWKSTimeout)
+	}
+
+	if jc.NegativeCacheTTL == 0 {
+		jc.NegativeCacheTTL = caddy.Duration(defaultJWKSNegativeTTL)
+	}
+
+	url := caddy.NewReplacer().ReplaceKnown(jc.URL, "")
+	jc.poolKey = fmt.Sprintf("%s|%d|%d|%s|%d", url, jc.RefreshInterval, jc.Timeout, jc.CAFile, jc.NegativeCacheTTL)
+
+	val, loaded, err := keySets.LoadOrNew(jc.poolKey, func() (caddy.Destructor, error) {
+		client, err := newJWKSClient(jc.CAFile, time.Duration(jc.Timeout))
+		if err != nil {
+			return nil, err
+		}
+
+		ks := &keySet{
+			url:         url,
+			client:      client,
+			l:           l.With(zap.String("jwks", url)),
+			negativeTTL: time.Duration(jc.NegativeCacheTTL),
+			unknown:     map[string]time.Time{},
+		}
+
+		// fetches are bound to the set's lifetime, so unloading the last config using it aborts requests in flight
+		// and stops the refresh loop; the first one is also aborted with the config being loaded
+		ks.ctx, ks.cancel = context.WithCancel(context.Background())
+		stop := context.AfterFunc(ctx, ks.cancel)
+
+		err = ks.fetch()
+		if !stop() {
+			return nil, ctx.Err()
+		}
+
+		if err != nil {
+			ks.l.Warn("Fetching JWKS failed, keys will be fetched on demand", zap.Error(err))
+		}
+
+		go ks.refreshLoop(time.Duration(jc.RefreshInterval))
+
+		return ks, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	jc.keySet = val.(*keySet)
+
+	if loaded {
+		l.Debug("Reusing JWKS fetched by the previous config", zap.String("jwks", url))
+	}
+
+	return nil
+}
+
+func (ks *keySet) Destruct() error {
+	ks.cancel()
+	return nil
+}
+
+func newJWKSClient(caFile string, timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if caFile != "" {
+		path := caddy.NewReplacer().ReplaceKnown(caFile, "")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading jwks ca_file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("jwks ca_file %s holds no PEM certificates", path)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+func (jc *JWKSConfig) validate() error {
+	if jc.URL == "" {
+		return fmt.Errorf("jwks requires a url")
+	}
+
+	if u, err := url.Parse(caddy.NewReplacer().ReplaceKnown(jc.URL, "")); err != nil ||
+		u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("invalid jwks url: %s", jc.URL)
+	}
+
+	if jc.RefreshInterval < 0 || jc.Timeout < 0 || jc.NegativeCacheTTL < 0 {
+		return fmt.Errorf("jwks durations must not be negative")
+	}
+
+	return nil
+}
+
+func (jc *JWKSConfig) cleanup() {
+	// released once, the key set itself stays usable for requests still in flight
+	if jc.keySet != nil && jc.poolKey != "" {
+		_, _ = keySets.Delete(jc.poolKey)
+		jc.poolKey = ""
+	}
+}
+
+func (ks *keySet) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ks.ctx.Done():
+			return
+		case <-ticker.C:
+			ks.fetchMu.Lock()
+			err := ks.fetch()
+			ks.fetchMu.Unlock()
+
+			if ks.ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				ks.l.Warn("Refreshing JWKS failed, keeping cached keys", zap.Error(err))
+			}
+		}
+	}
+}
+
+// fetch replaces the cached keys with the current key set, the caller holds fetchMu unless provisioning.
+func (ks *keySet) fetch() error {
+	req, err := http.NewRequestWithContext(ks.ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks endpoint responded %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return fmt.Errorf("decoding jwks: %w", err)
+	}
+
+	keys := make(map[string]*verificationKey, len(set.Keys))
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		vk, err := k.verificationKey()
+		if err != nil {
+			ks.l.Debug("Skipping JWKS key", zap.String("kid", k.Kid), zap.Error(err))
+			continue
+		}
+
+		keys[k.Kid] = vk
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("jwks holds no usable signing keys")
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.fetched = time.Now()
+	clear(ks.unknown)
+	ks.mu.Unlock()
+
+	ks.l.Debug("JWKS fetched", zap.Int("keys", len(keys)))
+
+	return nil
+}
+
+func (ks *keySet) cached(kid string) (*verificationKey, bool, time.Time) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	vk, ok := ks.keys[kid]
+	if !ok && kid == "" && len(ks.keys) == 1 {
+		for _, only := range ks.keys {
+			vk, ok = only, true
+		}
+	}
+
+	return vk, ok, ks.unknown[kid]
+}
+
+// lookup finds the key for a kid. An unknown kid triggers one refetch, in case the provider rotated its keys,
+// and is remembered for the negative cache TTL so garbage kids cannot make every request hit the provider.
+func (ks *keySet) lookup(kid string) (*verificationKey, error) {
+	if vk, ok, until := ks.cached(kid); ok {
+		return vk, nil
+	} else if time.Now().Before(until) {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+
+	ks.fetchMu.Lock()
+
+	ks.mu.RLock()
+	stale := time.Since(ks.fetched) >= jwksMinRefetch
+	ks.mu.RUnlock()
+
+	if stale && ks.ctx.Err() == nil {
+		if err := ks.fetch(); err != nil {
+			ks.l.Warn("Refetching JWKS for unknown key id failed", zap.String("kid", kid), zap.Error(err))
+		}
+	}
+
+	ks.fetchMu.Unlock()
+
+	if vk, ok, _ := ks.cached(kid); ok {
+		return vk, nil
+	}
+
+	ks.mu.Lock()
+	now := time.Now()
+	for k, until := range ks.unknown {
+		if now.After(until) {
+			delete(ks.unknown, k)
+		}
+	}
+	if len(ks.unknown) < maxJWKSUnknownKIDs {
+		ks.unknown[kid] = now.Add(ks.negativeTTL)
+	}
+	ks.mu.Unlock()
+
+	return nil, fmt.Errorf("unknown key id: %q", kid)
+}
+
+// parse verifies the token signature with the key named by its kid and requires an expiration.
+func (jc *JWKSConfig) parse(tosStr string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	cs := jwt.MapClaims{}
+
+	opts = append(opts, jwt.WithValidMethods(jwksMethods), jwt.WithExpirationRequired())
+
+	_, err := jwt.ParseWithClaims(tosStr, cs, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		vk, err := jc.keySet.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(vk.methods, t.Method.Alg()) {
+			return nil, fmt.Errorf("algorithm %s does not match key %q", t.Method.Alg(), kid)
+		}
+
+		return vk.key, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+func (k *jwk) verificationKey() (*verificationKey, error) {
+	pub, err := k.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if reason := weakKeyReason(nil, pub); reason != "" {
+		return nil, fmt.Errorf("weak key: %s", reason)
+	}
+
+	vk, err := publicVerificationKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.Alg != "" {
+		if !slices.Contains(vk.methods, k.Alg) {
+			return nil, fmt.Errorf("algorithm %s does not match the key type", k.Alg)
+		}
+
+		vk.methods = []string{k.Alg}
+	}
+
+	return vk, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC coordinates")
+		}
+
+		point := append(append([]byte{4}, x...), y...)
+
+		switch k.Crv {
+		case "P-256":
+			return parseECPoint(elliptic.P256(), x, y, point)
+		case "P-384":
+			return parseECPoint(elliptic.P384(), x, y, point)
+		case "P-521":
+			return parseECPoint(elliptic.P521(), x, y, point)
+		case "secp256k1":
+			if len(x) != 32 || len(y) != 32 {
+				return nil, fmt.Errorf("invalid secp256k1 coordinates")
+			}
+
+			pub, err := secp256k1.ParsePubKey(point)
+			if err != nil {
+				return nil, err
+			}
+
+			return pub.ToECDSA(), nil
+		default:
+			return nil, fmt.Errorf("unsupported EC curve: %s", k.Crv)
+		}
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve: %s", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func parseECPoint(curve elliptic.Curve, x, y, point []byte) (*ecdsa.PublicKey, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, fmt.Errorf("invalid %s coordinates", curve.Params().Name)
+	}
+
+	return ecdsa.ParseUncompressedPublicKey(curve, point)
+}
+
+func (jc *JWKSConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&jc.URL) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch opt := d.Val(); opt {
+		case "refresh_interval", "timeout", "negative_cache_ttl":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid jwks %s %s: %v", opt, durStr, err)
+			}
+
+			switch opt {
+			case "refresh_interval":
+				jc.RefreshInterval = caddy.Duration(dur)
+			case "timeout":
+				jc.Timeout = caddy.Duration(dur)
+			default:
+				jc.NegativeCacheTTL = caddy.Duration(dur)
+			}
+		case "ca_file":
+			if !d.Args(&jc.CAFile) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown jwks option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/goleak"
+)
+
+// serveJWKS serves a key set holding one P-256 key and counts the requests for it.
+func serveJWKS(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "EC",
+		"kid": "k1",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(set)
+	}))
+
+	return srv, &fetches
+}
+
+// hangingServer accepts key set requests and answers none of them until the client gives up. started receives a
+// value for every request.
+func hangingServer(t *testing.T) (*httptest.Server, chan struct{}) {
+	t.Helper()
+
+	started := make(chan struct{}, 16)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+
+	return srv, started
+}
+
+func unmarshalSigner(t *testing.T, cfg string) *JwtSigner {
+	t.Helper()
+
+	s := &JwtSigner{}
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(cfg)); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestJWKSCleanupStopsRefresh(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv, fetches := serveJWKS(t)
+	defer srv.Close()
+
+	s := unmarshalSigner(t, `jwt_signer {
+		options {
+			verify {
+				jwks `+srv.URL+` {
+					refresh_interval 10ms
+				}
+			}
+		}
+	}`)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the refresh loop is running once the set is fetched again after provisioning
+	for deadline := time.Now().Add(5 * time.Second); fetches.Load() < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("key set fetched %d times, want a refresh", fetches.Load())
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := s.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	srv.Close()
+}
+
+func TestJWKSProvisionCancelled(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv, _ := hangingServer(t)
+	defer srv.Close()
+
+	s := unmarshalSigner(t, `jwt_signer {
+		options {
+			verify {
+				jwks `+srv.URL+`
+			}
+		}
+	}`)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	cancel()
+
+	if err := s.Provision(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Provision with a cancelled context returned %v", err)
+	}
+}
+
+func TestJWKSFetchAbortedWithProvision(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv, started := hangingServer(t)
+	defer srv.Close()
+
+	s := unmarshalSigner(t, `jwt_signer {
+		options {
+			verify {
+				jwks `+srv.URL+` {
+					timeout 1m
+				}
+			}
+		}
+	}`)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Provision(ctx) }()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Provision returned %v, want it cancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Provision kept fetching after its context was cancelled")
+	}
+}
@@ -0,0 +1,108 @@
+package jwt_signer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	serializationCompact  = "compact"
+	serializationJSON     = "json"
+	serializationDetached = "detached"
+)
+
+type flattenedJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+func serializeToken(mode string, key *signingKey, tok *jwt.Token) (string, error) {
+	switch mode {
+	case serializationJSON:
+		return signFlattenedJSON(key, tok)
+	case serializationDetached:
+		return signDetached(key, tok)
+	default:
+		return tok.SignedString(key.sign)
+	}
+}
+
+// signFlattenedJSON produces the flattened JWS JSON serialization (RFC 7515, section 7.2.2).
+func signFlattenedJSON(key *signingKey, tok *jwt.Token) (string, error) {
+	signingStr, err := tok.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := key.method.Sign(signingStr, key.sign)
+	if err != nil {
+		return "", err
+	}
+
+	protected, payload, _ := strings.Cut(signingStr, ".")
+
+	out, err := json.Marshal(flattenedJWS{
+		Protected: protected,
+		Payload:   payload,
+		Signature: base64.RawURLEncoding.EncodeToString(sig),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// signDetached signs the unencoded payload (RFC 7797) and returns the compact form with the payload detached,
+// i.e. "<protected>..<signature>". The payload travels separately as plain JSON.
+func signDetached(key *signingKey, tok *jwt.Token) (string, error) {
+	payload, err := json.Marshal(tok.Claims)
+	if err != nil {
+		return "", err
+	}
+
+	return signDetachedPayload(key, tok.Header, payload)
+}
+
+func signDetachedPayload(key *signingKey, header map[string]any, payload []byte) (string, error) {
+	header["b64"] = false
+	header["crit"] = []string{"b64"}
+
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	protected := base64.RawURLEncoding.EncodeToString(rawHeader)
+
+	sig, err := key.method.Sign(protected+"."+string(payload), key.sign)
+	if err != nil {
+		return "", err
+	}
+
+	return protected + ".." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func setDetachedPlaceholders(repl *caddy.Replacer, tosStr string, cs jwt.MapClaims) error {
+	protected, sig, ok := strings.Cut(tosStr, "..")
+	if !ok {
+		return fmt.Errorf("malformed detached token")
+	}
+
+	payload, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+
+	repl.Set("http.jwt_signer.protected", protected)
+	repl.Set("http.jwt_signer.signature", sig)
+	repl.Set("http.jwt_signer.payload", string(payload))
+
+	return nil
+}
@@ -0,0 +1,515 @@
+package jwt_signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+type KeyConfig struct {
+	Secret  string `json:"secret,omitempty"`
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+type signingKey struct {
+	method jwt.SigningMethod
+	sign   any
+	verify any
+
+	// header holds fields identifying the key, such as kid, set in the header of every token it signs
+	header map[string]any
+}
+
+func (kc *KeyConfig) load() (*signingKey, error) {
+	switch {
+	case kc.Secret != "" && kc.KeyFile != "":
+		return nil, fmt.Errorf("secret and key_file are mutually exclusive")
+	case kc.Secret != "":
+		secret := caddy.NewReplacer().ReplaceKnown(kc.Secret, "")
+		if secret == "" {
+			return nil, fmt.Errorf("secret is empty after replacements")
+		}
+
+		return hmacKey(jwt.SigningMethodHS256, secret), nil
+	case kc.KeyFile != "":
+		return loadKeyFile(caddy.NewReplacer().ReplaceKnown(kc.KeyFile, ""))
+	default:
+		return nil, fmt.Errorf("either secret or key_file is required")
+	}
+}
+
+func hmacKey(method jwt.SigningMethod, secret string) *signingKey {
+	return &signingKey{
+		method: method,
+		sign:   []byte(secret),
+		verify: []byte(secret),
+	}
+}
+
+// selfTest signs a test token and verifies it again, so a key which cannot round-trip fails when it is loaded
+// rather than on the first request.
+func (k *signingKey) selfTest() error {
+	tosStr, err := jwt.NewWithClaims(k.method, jwt.MapClaims{"sub": "jwt_signer self-test"}).SignedString(k.sign)
+	if err != nil {
+		return fmt.Errorf("signing test token: %w", err)
+	}
+
+	_, err = jwt.Parse(tosStr, func(*jwt.Token) (any, error) {
+		return k.verify, nil
+	}, jwt.WithValidMethods([]string{k.method.Alg()}))
+	if err != nil {
+		return fmt.Errorf("verifying test token: %w", err)
+	}
+
+	return nil
+}
+
+var errNoneAlgorithm = errors.New(`the "none" algorithm is never allowed for signing`)
+
+var errWeakCurve = errors.New("the P-192 curve is deprecated by NIST and cannot be used")
+
+const minRSAKeyBits = 2048
+
+// weakKeyReason tells why a key is too weak for production use, or returns an empty string for a sound one. HMAC
+// secrets must be at least as long as the output of the hash of their method (RFC 7518, section 3.2), that of
+// HS256 when the method is nil as for secrets verifying any HMAC algorithm.
+func weakKeyReason(method jwt.SigningMethod, key any) string {
+	if secret, ok := key.([]byte); ok {
+		alg, size := jwt.SigningMethodHS256.Alg(), jwt.SigningMethodHS256.Hash.Size()
+		if hm, ok := method.(*jwt.SigningMethodHMAC); ok {
+			alg, size = hm.Alg(), hm.Hash.Size()
+		}
+
+		if len(secret) < size {
+			return fmt.Sprintf("%s secret of %d bytes is shorter than %d bytes", alg, len(secret), size)
+		}
+
+		return ""
+	}
+
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+
+	if k, ok := key.(*rsa.PublicKey); ok && k.N.BitLen() < minRSAKeyBits {
+		return fmt.Sprintf("RSA key of %d bits is shorter than %d bits", k.N.BitLen(), minRSAKeyBits)
+	}
+
+	return ""
+}
+
+// weakSecrets are placeholder secrets commonly found in examples and tutorials, compared case-insensitively.
+var weakSecrets = []string{
+	"changeme", "change-me", "change_me", "changeit", "replaceme", "replace-me", "replace_me",
+	"secret", "secretkey", "secret-key", "secret_key", "mysecret", "my-secret", "my_secret",
+	"supersecret", "super-secret", "super_secret", "topsecret", "jwt-secret", "jwt_secret", "jwtsecret",
+	"your-secret", "your_secret", "yoursecret", "your-256-bit-secret", "your-secret-key", "your_secret_key",
+	"password", "passw0rd", "default", "example", "test", "testing", "dev", "admin",
+	"123456", "12345678", "1234567890", "qwerty", "xxx", "foobar", "s3cr3t",
+}
+
+func isWeakSecret(secret string) bool {
+	secret = strings.TrimSpace(secret)
+
+	for _, weak := range weakSecrets {
+		if strings.EqualFold(secret, weak) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const generatedSecretLength = 32
+
+// generateSecret returns a random HMAC secret, hex encoded so it never contains placeholder braces.
+func generateSecret() (string, error) {
+	buf := make([]byte, generatedSecretLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// resolveHMACMethod maps an algorithm name to an HMAC signing method. Unsigned tokens are rejected explicitly,
+// whatever the source of the name, so a misconfiguration can never downgrade to the none algorithm.
+func resolveHMACMethod(alg string) (jwt.SigningMethod, error) {
+	trimmed := strings.TrimSpace(alg)
+	if trimmed == "" || strings.EqualFold(trimmed, jwt.SigningMethodNone.Alg()) {
+		return nil, errNoneAlgorithm
+	}
+
+	switch trimmed {
+	case jwt.SigningMethodHS256.Alg():
+		return jwt.SigningMethodHS256, nil
+	case jwt.SigningMethodHS384.Alg():
+		return jwt.SigningMethodHS384, nil
+	case jwt.SigningMethodHS512.Alg():
+		return jwt.SigningMethodHS512, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm for secret: %s", alg)
+	}
+}
+
+// loadedKeys are shared by all configs using the same unchanged key file, across config reloads too, so a reload
+// neither parses nor self-tests the key again.
+var loadedKeys = caddy.NewUsagePool()
+
+type loadedKey struct {
+	*signingKey
+}
+
+func (loadedKey) Destruct() error {
+	return nil
+}
+
+// fileIdentity names a file by its path, size and modification time, which change whenever it is replaced.
+func fileIdentity(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s|%d|%d", path, fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// pooledKey returns the key loaded under id by this or a previous config, calling load only for a new one. The
+// handler releases it on cleanup.
+func (s *JwtSigner) pooledKey(id string, load func() (*signingKey, error)) (*signingKey, error) {
+	val, loaded, err := loadedKeys.LoadOrNew(id, func() (caddy.Destructor, error) {
+		key, err := load()
+		if err != nil {
+			return nil, err
+		}
+
+		return loadedKey{key}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.pooledKeys = append(s.pooledKeys, id)
+
+	if loaded {
+		s.l.Debug("Reusing key loaded by the previous config", zap.String("key", id))
+	}
+
+	return val.(loadedKey).signingKey, nil
+}
+
+// loadKeyFileTested loads and self-tests a key file, unless this or a previous config loaded it unchanged before.
+func (s *JwtSigner) loadKeyFileTested(path string) (*signingKey, error) {
+	path = caddy.NewReplacer().ReplaceKnown(path, "")
+
+	id, err := fileIdentity(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	return s.pooledKey(id, func() (*signingKey, error) {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := key.selfTest(); err != nil {
+			return nil, fmt.Errorf("self-test failed: %w", err)
+		}
+
+		return key, nil
+	})
+}
+
+// loadKeyTested loads and self-tests a key, through loadKeyFileTested for a key file.
+func (s *JwtSigner) loadKeyTested(kc *KeyConfig) (*signingKey, error) {
+	if kc.KeyFile != "" && kc.Secret == "" {
+		return s.loadKeyFileTested(kc.KeyFile)
+	}
+
+	key, err := kc.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := key.selfTest(); err != nil {
+		return nil, fmt.Errorf("self-test failed: %w", err)
+	}
+
+	return key, nil
+}
+
+func (s *JwtSigner) releasePooledKeys() {
+	for _, id := range s.pooledKeys {
+		_, _ = loadedKeys.Delete(id)
+	}
+
+	s.pooledKeys = nil
+}
+
+func loadKeyFile(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	key, err := parsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+
+	return asymmetricKey(key)
+}
+
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			if k, ok := parseSecp256k1PEMBlock(block.Type, block.Bytes); ok {
+				return k, nil
+			}
+
+			if isP192PrivateKey(block.Type, block.Bytes) {
+				return nil, errWeakCurve
+			}
+
+			return nil, err
+		}
+
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			if k, ok := parseSecp256k1PEMBlock(block.Type, block.Bytes); ok {
+				return k, nil
+			}
+
+			if isP192PrivateKey(block.Type, block.Bytes) {
+				return nil, errWeakCurve
+			}
+
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+}
+
+func asymmetricKey(key crypto.Signer) (*signingKey, error) {
+	var method jwt.SigningMethod
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		case secp256k1.S256():
+			method = signingMethodES256K
+		default:
+			return nil, fmt.Errorf("unsupported EC curve: %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+
+	return &signingKey{
+		method: method,
+		sign:   key,
+		verify: key.Public(),
+	}, nil
+}
+
+type verificationKey struct {
+	methods []string
+	key     any
+}
+
+// parse verifies the token signature with the key and requires an expiration.
+func (vk *verificationKey) parse(tosStr string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	cs := jwt.MapClaims{}
+
+	opts = append(opts, jwt.WithValidMethods(vk.methods), jwt.WithExpirationRequired())
+
+	_, err := jwt.ParseWithClaims(tosStr, cs, func(*jwt.Token) (any, error) {
+		return vk.key, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// loadVerification loads a key that is only used to check signatures, so a key_file may hold
+// a public key or a certificate instead of a private key.
+func (kc *KeyConfig) loadVerification() (*verificationKey, error) {
+	if kc.Secret != "" && kc.KeyFile != "" {
+		return nil, fmt.Errorf("secret and key_file are mutually exclusive")
+	}
+
+	if kc.KeyFile == "" {
+		sk, err := kc.load()
+		if err != nil {
+			return nil, err
+		}
+
+		return &verificationKey{
+			methods: []string{
+				jwt.SigningMethodHS256.Alg(),
+				jwt.SigningMethodHS384.Alg(),
+				jwt.SigningMethodHS512.Alg(),
+			},
+			key: sk.verify,
+		}, nil
+	}
+
+	path := caddy.NewReplacer().ReplaceKnown(kc.KeyFile, "")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	pub, err := parsePublicKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+
+	return publicVerificationKey(pub)
+}
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			if k, ok := parseSecp256k1PublicKey(block.Bytes); ok {
+				return k, nil
+			}
+
+			if ecCurveOID(block.Bytes).Equal(oidNamedCurveP192) {
+				return nil, errWeakCurve
+			}
+
+			return nil, err
+		}
+
+		return key, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return cert.PublicKey, nil
+	default:
+		key, err := parsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return key.Public(), nil
+	}
+}
+
+func publicVerificationKey(pub crypto.PublicKey) (*verificationKey, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return &verificationKey{
+			methods: []string{
+				jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg(),
+				jwt.SigningMethodPS256.Alg(), jwt.SigningMethodPS384.Alg(), jwt.SigningMethodPS512.Alg(),
+			},
+			key: k,
+		}, nil
+	case *ecdsa.PublicKey:
+		var method jwt.SigningMethod
+
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		case secp256k1.S256():
+			method = signingMethodES256K
+		default:
+			return nil, fmt.Errorf("unsupported EC curve: %s", k.Curve.Params().Name)
+		}
+
+		return &verificationKey{methods: []string{method.Alg()}, key: k}, nil
+	case ed25519.PublicKey:
+		return &verificationKey{methods: []string{jwt.SigningMethodEdDSA.Alg()}, key: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+}
+
+func (kc *KeyConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	var kind, val string
+	if !d.Args(&kind, &val) {
+		return d.ArgErr()
+	}
+
+	switch kind {
+	case "secret":
+		kc.Secret = val
+	case "key_file":
+		kc.KeyFile = val
+	default:
+		return d.Errf("unknown key kind: %s", kind)
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(&JwtMatcher{})
+}
+
+// JwtMatcher matches requests presenting a valid token which carries the configured claim values.
+type JwtMatcher struct {
+	KeyConfig
+	VerifyConfig
+
+	// Claims maps claim names to the values they must have, values may contain placeholders. An array claim
+	// matches if any of its elements has the value.
+	Claims map[string]string `json:"claims,omitempty"`
+
+	Revocation *RevocationConfig `json:"revocation,omitempty"`
+
+	key *verificationKey
+	l   *zap.Logger
+}
+
+func (*JwtMatcher) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.matchers.jwt_signer",
+		New: func() caddy.Module { return new(JwtMatcher) },
+	}
+}
+
+func (m *JwtMatcher) Provision(ctx caddy.Context) error {
+	m.l = ctx.Logger()
+
+	m.VerifyConfig.provision()
+
+	if m.JWKS != nil {
+		if err := m.JWKS.provision(ctx, m.l); err != nil {
+			return err
+		}
+	} else {
+		key, err := m.KeyConfig.loadVerification()
+		if err != nil {
+			return err
+		}
+
+		m.key = key
+	}
+
+	if m.Revocation != nil {
+		return m.Revocation.provision(ctx, m.l)
+	}
+
+	return nil
+}
+
+func (m *JwtMatcher) Validate() error {
+	if err := m.VerifyConfig.validate(); err != nil {
+		return err
+	}
+
+	if m.JWKS != nil && (m.Secret != "" || m.KeyFile != "") {
+		return fmt.Errorf("jwks cannot be combined with secret or key_file")
+	}
+
+	if m.SingleUse || m.ReplayProtection != nil || m.DPoP != nil {
+		return fmt.Errorf("single_use, replay_protection and dpop are not supported by the jwt_signer matcher")
+	}
+
+	return nil
+}
+
+func (m *JwtMatcher) Cleanup() error {
+	if m.Revocation != nil {
+		m.Revocation.cleanup()
+	}
+
+	if m.JWKS != nil {
+		m.JWKS.cleanup()
+	}
+
+	return nil
+}
+
+func (m *JwtMatcher) Match(r *http.Request) bool {
+	match, _ := m.MatchWithError(r)
+	return match
+}
+
+func (m *JwtMatcher) MatchWithError(r *http.Request) (bool, error) {
+	tosStr := m.token(r)
+	if tosStr == "" {
+		return false, nil
+	}
+
+	var (
+		cs  jwt.MapClaims
+		err error
+	)
+
+	if m.JWKS != nil {
+		cs, err = m.JWKS.parse(tosStr, m.parserOptions()...)
+	} else {
+		cs, err = m.key.parse(tosStr, m.parserOptions()...)
+	}
+	if err == nil {
+		err = m.expect(tosStr, cs)
+	}
+	if err == nil {
+		err = m.checkScopes(cs)
+	}
+	if err != nil {
+		m.l.Debug("Token does not match", zap.Error(err))
+		return false, nil
+	}
+
+	if m.Revocation != nil {
+		if jti, _ := cs["jti"].(string); jti != "" && m.Revocation.list.contains(jti) {
+			m.l.Debug("Token does not match", zap.Error(errTokenRevoked))
+			return false, nil
+		}
+	}
+
+	repl, _ := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if repl == nil {
+		repl = caddy.NewReplacer()
+	}
+
+	for claim, want := range m.Claims {
+		want = repl.ReplaceAll(want, "")
+
+		if !claimHasValue(cs[claim], want) {
+			m.l.Debug("Token claim does not match", zap.String("claim", claim))
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func claimHasValue(v any, want string) bool {
+	if arr, ok := v.([]any); ok {
+		for _, el := range arr {
+			if claimString(el) == want {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return v != nil && claimString(v) == want
+}
+
+func (m *JwtMatcher) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		d.Args(&m.Secret)
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "secret":
+				if !d.Args(&m.Secret) {
+					return d.ArgErr()
+				}
+			case "key_file":
+				if !d.Args(&m.KeyFile) {
+					return d.ArgErr()
+				}
+			case "claim":
+				var claim, val string
+				if !d.Args(&claim, &val) {
+					return d.ArgErr()
+				}
+
+				if m.Claims == nil {
+					m.Claims = map[string]string{}
+				}
+
+				m.Claims[claim] = val
+			case "revocation":
+				m.Revocation = &RevocationConfig{}
+				if err := m.Revocation.unmarshalCaddyfile(d); err != nil {
+					return err
+				}
+
+				continue
+			default:
+				if err := m.VerifyConfig.unmarshalOption(d); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		}
+	}
+
+	return nil
+}
+
+var (
+	_ caddy.Provisioner                 = (*JwtMatcher)(nil)
+	_ caddy.Validator                   = (*JwtMatcher)(nil)
+	_ caddy.CleanerUpper                = (*JwtMatcher)(nil)
+	_ caddyhttp.RequestMatcherWithError = (*JwtMatcher)(nil)
+	_ caddyfile.Unmarshaler             = (*JwtMatcher)(nil)
+)
@@ -0,0 +1,112 @@
+package jwt_signer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestMatcher(t *testing.T) {
+	m := &JwtMatcher{}
+	err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`jwt_signer ` + testSecret + ` {
+		claim role admin
+		claim tenant {http.request.header.X-Tenant}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = m.Cleanup() }()
+
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(secret string, cs jwt.MapClaims) string {
+		if _, ok := cs["exp"]; !ok {
+			cs["exp"] = time.Now().Add(time.Minute).Unix()
+		}
+
+		tosStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, cs).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tosStr
+	}
+
+	tests := []struct {
+		name, auth string
+		want       bool
+	}{
+		{
+			name: "valid token",
+			auth: "Bearer " + sign(testSecret, jwt.MapClaims{"role": "admin", "tenant": "acme"}),
+			want: true,
+		},
+		{
+			name: "claim array holding the value",
+			auth: "Bearer " + sign(testSecret, jwt.MapClaims{"role": []string{"user", "admin"}, "tenant": "acme"}),
+			want: true,
+		},
+		{
+			name: "no token",
+		},
+		{
+			name: "wrong claim value",
+			auth: "Bearer " + sign(testSecret, jwt.MapClaims{"role": "user", "tenant": "acme"}),
+		},
+		{
+			name: "claim missing",
+			auth: "Bearer " + sign(testSecret, jwt.MapClaims{"role": "admin"}),
+		},
+		{
+			name: "placeholder value not matching",
+			auth: "Bearer " + sign(testSecret, jwt.MapClaims{"role": "admin", "tenant": "globex"}),
+		},
+		{
+			name: "wrong secret",
+			auth: "Bearer " + sign(strings.Repeat("x", 32), jwt.MapClaims{"role": "admin", "tenant": "acme"}),
+		},
+		{
+			name: "expired",
+			auth: "Bearer " + sign(testSecret, jwt.MapClaims{
+				"role": "admin", "tenant": "acme", "exp": time.Now().Add(-time.Minute).Unix(),
+			}),
+		},
+		{
+			name: "malformed",
+			auth: "Bearer not.a.token",
+		},
+		{
+			name: "other scheme",
+			auth: "Basic " + sign(testSecret, jwt.MapClaims{"role": "admin", "tenant": "acme"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Tenant", "acme")
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			if got := m.Match(r); got != tt.want {
+				t.Fatalf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	onMissingSkip    = "skip"
+	onMissingError   = "error"
+	onMissingDefault = "default"
+)
+
+// missingFunc is called by claim sources for every expected value the request does not carry.
+type missingFunc func(claim, source string) error
+
+func validateOnMissing(mode string, defaults map[string]string) error {
+	switch mode {
+	case "", onMissingSkip, onMissingError:
+	case onMissingDefault:
+		if len(defaults) == 0 {
+			return fmt.Errorf("on_missing_claim_source default requires claim_defaults")
+		}
+	default:
+		return fmt.Errorf("unknown on_missing_claim_source mode: %s", mode)
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) onMissing(cs jwt.MapClaims, repl *caddy.Replacer) missingFunc {
+	return func(claim, source string) error {
+		switch s.OnMissingClaimSource {
+		case onMissingError:
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("missing %s for claim %s", source, claim))
+		case onMissingDefault:
+			def, ok := s.ClaimDefaults[claim]
+			if !ok {
+				break
+			}
+
+			if val := repl.ReplaceAll(def, ""); val != "" {
+				s.l.Debug("Claim source missing, use default", zap.String("claim", claim), zap.String("source", source))
+				cs[claim] = val
+			}
+
+			return nil
+		}
+
+		s.l.Debug("Claim source missing, skip", zap.String("claim", claim), zap.String("source", source))
+
+		return nil
+	}
+}
+
+func (s *JwtSigner) parseClaimDefaultsCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	if s.ClaimDefaults == nil {
+		s.ClaimDefaults = map[string]string{}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		claim := d.Val()
+
+		var val string
+		if !d.Args(&val) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.ClaimDefaults[claim] = val
+	}
+
+	return nil
+}
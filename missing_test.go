@@ -0,0 +1,88 @@
+package jwt_signer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestOnMissingClaimSource(t *testing.T) {
+	sources := []struct {
+		name, claims, options, claim string
+	}{
+		{
+			name:    "query",
+			options: "include_query {\nallow user\n}",
+			claim:   "user",
+		},
+		{
+			name:    "body",
+			options: "claims_from_body {\nuser user.id\n}",
+			claim:   "user",
+		},
+		{
+			name:   "hash",
+			claims: "user hash sha256 {http.request.header.X-User}",
+			claim:  "user",
+		},
+		{
+			name:    "pkce",
+			options: "pkce_claim",
+			claim:   "cc",
+		},
+	}
+
+	modes := []struct {
+		mode       string
+		want       any
+		wantStatus int
+	}{
+		{mode: "skip"},
+		{mode: "error", wantStatus: http.StatusBadRequest},
+		{mode: "default", want: "fallback"},
+	}
+
+	for _, src := range sources {
+		for _, m := range modes {
+			t.Run(src.name+" "+m.mode, func(t *testing.T) {
+				s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+					sub alice
+					`+src.claims+`
+					options {
+						`+src.options+`
+						on_missing_claim_source `+m.mode+`
+						claim_defaults {
+							`+src.claim+` fallback
+						}
+					}
+				}`)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				r, repl := newTestRequest(http.MethodPost, "/", strings.NewReader(`{"user":{}}`))
+				r.Header.Set("Content-Type", "application/json")
+
+				err = s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+				if m.wantStatus != 0 {
+					var he caddyhttp.HandlerError
+					if !errors.As(err, &he) || he.StatusCode != m.wantStatus {
+						t.Fatalf("error %v, want status %d", err, m.wantStatus)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := parseTestToken(t, repl)[src.claim]; got != m.want {
+					t.Fatalf("%s = %v, want %v", src.claim, got, m.want)
+				}
+			})
+		}
+	}
+}
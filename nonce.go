@@ -0,0 +1,259 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultNonceClaim  = "nonce"
+	defaultNonceLength = 16
+	minNonceLength     = 8
+	maxNonceLength     = 64
+
+	defaultNoncePrefix = "jwt_signer/nonces"
+
+	replayStrict     = "strict"
+	replayBestEffort = "best_effort"
+
+	// maxSeenNonces bounds the in-memory front cache, storage stays authoritative for nonces which do not fit.
+	maxSeenNonces = 10000
+)
+
+// NonceConfig adds a random nonce claim to every issued token.
+type NonceConfig struct {
+	Claim string `json:"claim,omitempty"`
+
+	// Length is the number of random bytes, encoded as unpadded base64url.
+	Length int `json:"length,omitempty"`
+}
+
+func (nc *NonceConfig) provision() {
+	if nc.Claim == "" {
+		nc.Claim = defaultNonceClaim
+	}
+
+	if nc.Length == 0 {
+		nc.Length = defaultNonceLength
+	}
+}
+
+func (nc *NonceConfig) validate() error {
+	if nc.Length < minNonceLength || nc.Length > maxNonceLength {
+		return fmt.Errorf("nonce length must be between %d and %d bytes", minNonceLength, maxNonceLength)
+	}
+
+	return nil
+}
+
+func (nc *NonceConfig) generate() (string, error) {
+	buf := make([]byte, nc.Length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (nc *NonceConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		length, err := strconv.Atoi(d.Val())
+		if err != nil {
+			return d.Errf("invalid nonce length %s: %v", d.Val(), err)
+		}
+
+		nc.Length = length
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "claim":
+			if !d.Args(&nc.Claim) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown generate_nonce option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
+
+// ReplayConfig rejects tokens whose nonce was seen before, for as long as the token is valid. Seen nonces are
+// recorded in Caddy's storage behind an in-memory cache. In strict mode the storage lock serializes presentations
+// of a nonce across a cluster; best_effort skips the lock and may let a replay on another instance slip through.
+type ReplayConfig struct {
+	Claim         string `json:"claim,omitempty"`
+	Mode          string `json:"mode,omitempty"`
+	StoragePrefix string `json:"storage_prefix,omitempty"`
+
+	seen   *consumedTokens
+	recent *seenSet
+}
+
+func (rc *ReplayConfig) provision(ctx caddy.Context) {
+	if rc.Claim == "" {
+		rc.Claim = defaultNonceClaim
+	}
+
+	if rc.Mode == "" {
+		rc.Mode = replayStrict
+	}
+
+	if rc.StoragePrefix == "" {
+		rc.StoragePrefix = defaultNoncePrefix
+	}
+
+	rc.seen = &consumedTokens{storage: ctx.Storage(), prefix: rc.StoragePrefix}
+	rc.recent = newSeenSet(maxSeenNonces)
+}
+
+func (rc *ReplayConfig) validate() error {
+	switch rc.Mode {
+	case replayStrict, replayBestEffort:
+	default:
+		return fmt.Errorf("unknown replay_protection mode: %s", rc.Mode)
+	}
+
+	return nil
+}
+
+// seenSet remembers values until they expire, in memory and bounded in size.
+type seenSet struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]int64
+}
+
+func newSeenSet(max int) *seenSet {
+	return &seenSet{max: max, entries: map[string]int64{}}
+}
+
+// add remembers the value until exp, failing if it is already there and not yet expired. A full set forgets
+// nothing and accepts the value without remembering it.
+func (ss *seenSet) add(val string, exp time.Time) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	now := time.Now().Unix()
+
+	if seenExp, ok := ss.entries[val]; ok && seenExp >= now {
+		return errTokenUsed
+	}
+
+	if len(ss.entries) >= ss.max {
+		for v, seenExp := range ss.entries {
+			if seenExp < now {
+				delete(ss.entries, v)
+			}
+		}
+	}
+
+	if len(ss.entries) < ss.max {
+		ss.entries[val] = exp.Unix()
+	}
+
+	return nil
+}
+
+func (ss *seenSet) remove(val string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.entries, val)
+}
+
+func (rc *ReplayConfig) check(ctx context.Context, nonce string, exp time.Time) error {
+	if err := rc.recent.add(nonce, exp); err != nil {
+		return err
+	}
+
+	var err error
+	if rc.Mode == replayStrict {
+		err = rc.seen.consume(ctx, nonce, exp)
+	} else {
+		err = rc.seen.record(ctx, nonce, exp)
+	}
+
+	// a nonce which could not be recorded must stay presentable, and one seen elsewhere is already known to storage
+	if err != nil && !errors.Is(err, errTokenUsed) {
+		rc.recent.remove(nonce)
+	}
+
+	return err
+}
+
+func (s *JwtSigner) checkNonce(w http.ResponseWriter, r *http.Request, rc *ReplayConfig, tosStr string, cs jwt.MapClaims) error {
+	rc.seen.sweep(s.l)
+
+	nonce, _ := cs[rc.Claim].(string)
+	if nonce == "" {
+		return s.rejectPresented(w, tosStr, fmt.Errorf("token has no %s claim", rc.Claim))
+	}
+
+	exp, err := cs.GetExpirationTime()
+	if err != nil || exp == nil {
+		return s.rejectPresented(w, tosStr, fmt.Errorf("token with a nonce has no usable expiration"))
+	}
+
+	err = rc.check(r.Context(), nonce, exp.Time)
+	if errors.Is(err, errTokenUsed) {
+		s.l.Debug("Nonce presented again", zap.String("nonce", nonce))
+		return s.rejectPresented(w, tosStr, err)
+	}
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, fmt.Errorf("recording nonce: %w", err))
+	}
+
+	return nil
+}
+
+func (rc *ReplayConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Args(&rc.Mode)
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "claim":
+			if !d.Args(&rc.Claim) {
+				return d.ArgErr()
+			}
+		case "storage_prefix":
+			if !d.Args(&rc.StoragePrefix) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown replay_protection option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package jwt_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// numericDateClaims are the claims RFC 7519 and OpenID Connect define as NumericDate.
+var numericDateClaims = []string{"iat", "exp", "nbf", "auth_time"}
+
+// normalizeNumericDates turns the date claims into integer seconds, whether they were given as floats, JSON numbers
+// or numeric strings, so verifiers never see 1700000000.0 or "1700000000".
+func normalizeNumericDates(cs jwt.MapClaims) error {
+	for _, k := range numericDateClaims {
+		v, ok := cs[k]
+		if !ok {
+			continue
+		}
+
+		secs, err := numericDate(v)
+		if err != nil {
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("claim %s is not a NumericDate: %w", k, err))
+		}
+
+		cs[k] = secs
+	}
+
+	return nil
+}
+
+func numericDate(v any) (int64, error) {
+	switch val := v.(type) {
+	case int64:
+		return val, nil
+	case int:
+		return int64(val), nil
+	case int32:
+		return int64(val), nil
+	case float64:
+		return floatSeconds(val)
+	case float32:
+		return floatSeconds(float64(val))
+	case json.Number:
+		if secs, err := val.Int64(); err == nil {
+			return secs, nil
+		}
+
+		f, err := val.Float64()
+		if err != nil {
+			return 0, err
+		}
+
+		return floatSeconds(f)
+	case string:
+		if secs, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return secs, nil
+		}
+
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unparsable value %q", val)
+		}
+
+		return floatSeconds(f)
+	default:
+		return 0, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// floatSeconds drops the fraction of a second, RFC 7519 allows it but many verifiers do not.
+func floatSeconds(f float64) (int64, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) || f > math.MaxInt64 || f < math.MinInt64 {
+		return 0, fmt.Errorf("out of range: %v", f)
+	}
+
+	return int64(math.Floor(f)), nil
+}
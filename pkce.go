@@ -0,0 +1,152 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	pkceSourceQuery = "query"
+
+	pkceMethodS256  = "S256"
+	pkceMethodPlain = "plain"
+
+	defaultPKCEClaim = "cc"
+)
+
+// PKCEConfig embeds the code challenge of an authorization request (RFC 7636) in the token, so the code verifier
+// presented at the token endpoint can be checked against it later.
+type PKCEConfig struct {
+	// CodeChallengeSource and CodeChallengeMethodSource tell where the code_challenge and code_challenge_method
+	// parameters are read from, query (default) being the only source for now.
+	CodeChallengeSource       string `json:"code_challenge_source,omitempty"`
+	CodeChallengeMethodSource string `json:"code_challenge_method_source,omitempty"`
+
+	// Claim holds the challenge, cc by default. MethodClaim, when set, also records the method.
+	Claim       string `json:"claim,omitempty"`
+	MethodClaim string `json:"method_claim,omitempty"`
+}
+
+func (pc *PKCEConfig) provision() {
+	if pc.CodeChallengeSource == "" {
+		pc.CodeChallengeSource = pkceSourceQuery
+	}
+
+	if pc.CodeChallengeMethodSource == "" {
+		pc.CodeChallengeMethodSource = pkceSourceQuery
+	}
+
+	if pc.Claim == "" {
+		pc.Claim = defaultPKCEClaim
+	}
+}
+
+func (pc *PKCEConfig) validate() error {
+	if pc.CodeChallengeSource != pkceSourceQuery {
+		return fmt.Errorf("unsupported pkce_claim code_challenge_source: %s", pc.CodeChallengeSource)
+	}
+
+	if pc.CodeChallengeMethodSource != pkceSourceQuery {
+		return fmt.Errorf("unsupported pkce_claim code_challenge_method_source: %s", pc.CodeChallengeMethodSource)
+	}
+
+	if pc.MethodClaim != "" && pc.MethodClaim == pc.Claim {
+		return fmt.Errorf("pkce_claim method_claim must differ from claim: %s", pc.Claim)
+	}
+
+	return nil
+}
+
+func (pc *PKCEConfig) apply(cs jwt.MapClaims, r *http.Request, missing missingFunc, l *zap.Logger) error {
+	query := r.URL.Query()
+
+	challenge := query.Get("code_challenge")
+	if challenge == "" {
+		return missing(pc.Claim, "query parameter code_challenge")
+	}
+
+	// a client sending no method uses plain (RFC 7636, section 4.3)
+	method := query.Get("code_challenge_method")
+	if method == "" {
+		method = pkceMethodPlain
+	}
+
+	if method != pkceMethodS256 && method != pkceMethodPlain {
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("unsupported code_challenge_method: %s", method))
+	}
+
+	if err := checkCodeChallenge(challenge, method); err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	l.Debug("PKCE code challenge included", zap.String("method", method), zap.String("claim", pc.Claim))
+
+	cs[pc.Claim] = challenge
+	if pc.MethodClaim != "" {
+		cs[pc.MethodClaim] = method
+	}
+
+	return nil
+}
+
+// checkCodeChallenge applies the syntax of RFC 7636, section 4.2: 43 to 128 unreserved characters, and exactly 43
+// for the base64url encoded SHA-256 of an S256 challenge.
+func checkCodeChallenge(challenge, method string) error {
+	if len(challenge) < 43 || len(challenge) > 128 {
+		return fmt.Errorf("code_challenge must be 43 to 128 characters long")
+	}
+
+	if method == pkceMethodS256 && len(challenge) != 43 {
+		return fmt.Errorf("S256 code_challenge must be 43 characters long")
+	}
+
+	invalid := func(c rune) bool {
+		return !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c))
+	}
+	if strings.ContainsFunc(challenge, invalid) {
+		return fmt.Errorf("code_challenge holds characters outside of the unreserved set")
+	}
+
+	return nil
+}
+
+func (pc *PKCEConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "code_challenge_source":
+			if !d.Args(&pc.CodeChallengeSource) {
+				return d.ArgErr()
+			}
+		case "code_challenge_method_source":
+			if !d.Args(&pc.CodeChallengeMethodSource) {
+				return d.ArgErr()
+			}
+		case "claim":
+			if !d.Args(&pc.Claim) {
+				return d.ArgErr()
+			}
+		case "method_claim":
+			if !d.Args(&pc.MethodClaim) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown pkce_claim option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package jwt_signer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestPKCEClaim(t *testing.T) {
+	// the code verifier of RFC 7636, appendix B
+	const verifier = "dBjftJeZ4CVP-mJ4NTXTXX3cK9iqqFMqMdzB3uWEtYk"
+
+	sum := sha256.Sum256([]byte(verifier))
+	s256 := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub alice
+		options {
+			pkce_claim {
+				code_challenge_source query
+				code_challenge_method_source query
+				claim cc
+				method_claim ccm
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, challenge, method string
+		wantMethod              string
+		wantErr                 string
+	}{
+		{name: "S256", challenge: s256, method: "S256", wantMethod: "S256"},
+		{name: "plain", challenge: verifier, method: "plain", wantMethod: "plain"},
+		{name: "method defaults to plain", challenge: verifier, wantMethod: "plain"},
+		{name: "longest plain challenge", challenge: strings.Repeat("a~", 64), method: "plain", wantMethod: "plain"},
+		{name: "unsupported method", challenge: s256, method: "S512", wantErr: "unsupported code_challenge_method: S512"},
+		{name: "method in lowercase", challenge: s256, method: "s256", wantErr: "unsupported code_challenge_method: s256"},
+		{name: "S256 of the wrong length", challenge: verifier + "A", method: "S256", wantErr: "S256 code_challenge must be 43 characters long"},
+		{name: "too short", challenge: "abc", method: "plain", wantErr: "code_challenge must be 43 to 128 characters long"},
+		{name: "too long", challenge: strings.Repeat("a", 129), method: "plain", wantErr: "code_challenge must be 43 to 128 characters long"},
+		{name: "reserved characters", challenge: strings.Repeat("a", 41) + "+/", method: "plain", wantErr: "outside of the unreserved set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{"code_challenge": {tt.challenge}}
+			if tt.method != "" {
+				q.Set("code_challenge_method", tt.method)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/authorize?"+q.Encode(), nil)
+
+			err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+			if tt.wantErr != "" {
+				var he caddyhttp.HandlerError
+				if !errors.As(err, &he) || he.StatusCode != http.StatusBadRequest || !strings.Contains(he.Err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want 400 with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cs := parseTestToken(t, repl)
+			if cs["cc"] != tt.challenge || cs["ccm"] != tt.wantMethod {
+				t.Errorf("cc = %v and ccm = %v, want %s and %s", cs["cc"], cs["ccm"], tt.challenge, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestValidatePKCEClaim(t *testing.T) {
+	tests := []struct {
+		name, cfg, wantErr string
+	}{
+		{
+			name: "body source",
+			cfg: `pkce_claim {
+					code_challenge_source body
+				}`,
+			wantErr: "unsupported pkce_claim code_challenge_source: body",
+		},
+		{
+			name: "header method source",
+			cfg: `pkce_claim {
+					code_challenge_method_source header
+				}`,
+			wantErr: "unsupported pkce_claim code_challenge_method_source: header",
+		},
+		{
+			name: "method in the challenge claim",
+			cfg: `pkce_claim {
+					method_claim cc
+				}`,
+			wantErr: "pkce_claim method_claim must differ from claim: cc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				options {
+					`+tt.cfg+`
+				}
+			}`)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PreviousSecret is an HMAC secret which is no longer used for signing but still verifies tokens issued with it.
+type PreviousSecret struct {
+	Secret string `json:"secret"`
+	KeyID  string `json:"kid,omitempty"`
+}
+
+type verificationCandidate struct {
+	name string
+	key  *signingKey
+}
+
+// verificationCandidates lists the keys a presented token is checked against, in order. A token whose kid names
+// previous secrets is only checked against those, any other token against the current secret and then every
+// previous secret.
+func (s *JwtSigner) verificationCandidates(key *signingKey, tosStr string, repl *caddy.Replacer) []verificationCandidate {
+	current := verificationCandidate{name: "secret", key: key}
+
+	if len(s.PreviousSecrets) == 0 {
+		return []verificationCandidate{current}
+	}
+
+	var kid string
+	if tok, _, err := jwt.NewParser().ParseUnverified(tosStr, jwt.MapClaims{}); err == nil {
+		kid, _ = tok.Header["kid"].(string)
+	}
+
+	var all, byKID []verificationCandidate
+
+	for i, ps := range s.PreviousSecrets {
+		secret := repl.ReplaceAll(ps.Secret, "")
+		if secret == "" {
+			continue
+		}
+
+		name := "previous_secrets[" + strconv.Itoa(i) + "]"
+		if ps.KeyID != "" {
+			name = ps.KeyID
+		}
+
+		cand := verificationCandidate{name: name, key: hmacKey(key.method, secret)}
+
+		all = append(all, cand)
+		if kid != "" && ps.KeyID == kid {
+			byKID = append(byKID, cand)
+		}
+	}
+
+	if len(byKID) > 0 {
+		return byKID
+	}
+
+	return append([]verificationCandidate{current}, all...)
+}
+
+func (s *JwtSigner) validatePreviousSecrets() error {
+	if len(s.PreviousSecrets) == 0 {
+		return nil
+	}
+
+	if s.Tenant != "" || s.KeyFile != "" || len(s.ScopedSecrets) > 0 || s.Vault != nil {
+		return fmt.Errorf("previous_secrets cannot be combined with tenant, key_file, scoped_secrets or vault")
+	}
+
+	for _, ps := range s.PreviousSecrets {
+		if ps.Secret == "" {
+			return fmt.Errorf("previous_secrets requires non-empty secrets")
+		}
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) parsePreviousSecretsCaddyfile(d *caddyfile.Dispenser) error {
+	var ps PreviousSecret
+	if d.Args(&ps.Secret) {
+		d.Args(&ps.KeyID)
+		s.PreviousSecrets = append(s.PreviousSecrets, ps)
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		ps = PreviousSecret{Secret: d.Val()}
+		d.Args(&ps.KeyID)
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.PreviousSecrets = append(s.PreviousSecrets, ps)
+	}
+
+	return nil
+}
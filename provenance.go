@@ -0,0 +1,122 @@
+package jwt_signer
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// claimProvenance records which source set each claim while building a token, so operators can tell which of
+// several sources won. It is nil unless debug logging is enabled, and its methods then do nothing.
+type claimProvenance map[string]string
+
+type claimOrigin struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+func (s *JwtSigner) newProvenance() claimProvenance {
+	if !s.l.Core().Enabled(zap.DebugLevel) {
+		return nil
+	}
+
+	return claimProvenance{}
+}
+
+// set attributes the claims to a source, for sources producing their claims apart from the token.
+func (p claimProvenance) set(claims map[string]any, source func(key string) string) {
+	if p == nil {
+		return
+	}
+
+	for k := range claims {
+		p[k] = source(k)
+	}
+}
+
+func (p claimProvenance) setKey(key, source string) {
+	if p != nil {
+		p[key] = source
+	}
+}
+
+// snapshot and changed attribute the claims a source wrote into the token directly.
+func (p claimProvenance) snapshot(cs jwt.MapClaims) jwt.MapClaims {
+	if p == nil {
+		return nil
+	}
+
+	before := make(jwt.MapClaims, len(cs))
+	for k, v := range cs {
+		before[k] = v
+	}
+
+	return before
+}
+
+func (p claimProvenance) changed(before, cs jwt.MapClaims, source func(key string) string) {
+	if p == nil {
+		return
+	}
+
+	for k, v := range cs {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			p[k] = source(k)
+		}
+	}
+}
+
+func (p claimProvenance) log(l *zap.Logger, cs jwt.MapClaims) {
+	if p == nil {
+		return
+	}
+
+	origins := make([]claimOrigin, 0, len(cs))
+	for k, v := range cs {
+		source, ok := p[k]
+		if !ok {
+			source = "registered"
+		}
+
+		origins = append(origins, claimOrigin{Key: k, Value: v, Source: source})
+	}
+
+	sort.Slice(origins, func(i, j int) bool { return origins[i].Key < origins[j].Key })
+
+	l.Debug("Claim provenance", zap.Any("claims", origins))
+}
+
+// placeholderSources names the request value behind a claim filled from a single placeholder.
+var placeholderSources = map[string]string{
+	"http.request.header.":    "header:",
+	"http.request.cookie.":    "cookie:",
+	"http.request.uri.query.": "query:",
+	"http.vars.":              "var:",
+	"env.":                    "env:",
+}
+
+// claimSource describes a configured claim: static for a fixed value, the request value for a lone placeholder
+// and template for anything mixing them.
+func claimSource(v any) string {
+	str, ok := v.(string)
+	if !ok || !strings.Contains(str, "{") {
+		return "static"
+	}
+
+	inner := strings.TrimPrefix(strings.TrimSuffix(str, "}"), "{")
+	if len(inner) != len(str)-2 || strings.ContainsAny(inner, "{}") {
+		return "template"
+	}
+
+	for prefix, source := range placeholderSources {
+		if name, ok := strings.CutPrefix(inner, prefix); ok {
+			return source + name
+		}
+	}
+
+	return "placeholder:" + inner
+}
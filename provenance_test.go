@@ -0,0 +1,138 @@
+package jwt_signer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestClaimProvenance(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub {http.request.header.X-User}
+		host {http.request.header.X-Forwarded-Host}
+		role reader
+		options {
+			request_claims host method
+			subject_from_cert cn {
+				on_missing skip
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	s.l = zap.New(core)
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "mallory")
+	r.Header.Set("X-Forwarded-Host", "api.example.org")
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	if sub := parseTestToken(t, repl)["sub"]; sub != "billing" {
+		t.Fatalf("sub = %v, want the certificate's", sub)
+	}
+
+	entries := logs.FilterMessage("Claim provenance").All()
+	if len(entries) != 1 {
+		t.Fatalf("%d provenance entries, want 1", len(entries))
+	}
+
+	origins, ok := entries[0].ContextMap()["claims"].([]claimOrigin)
+	if !ok {
+		t.Fatalf("claims field %T, want origins", entries[0].ContextMap()["claims"])
+	}
+
+	got := map[string]claimOrigin{}
+	for _, o := range origins {
+		got[o.Key] = o
+	}
+
+	want := []claimOrigin{
+		{Key: "sub", Value: "billing", Source: "cert:cn"},
+		{Key: "host", Value: "api.example.org", Source: "header:X-Forwarded-Host"},
+		{Key: "method", Value: "GET", Source: "request:method"},
+		{Key: "role", Value: "reader", Source: "static"},
+		{Key: "exp", Source: "registered"},
+		{Key: "iat", Source: "registered"},
+	}
+	for _, w := range want {
+		o, ok := got[w.Key]
+		if !ok {
+			t.Errorf("%s missing from the provenance", w.Key)
+			continue
+		}
+
+		if o.Source != w.Source || w.Value != nil && o.Value != w.Value {
+			t.Errorf("%s from %s with %v, want from %s with %v", w.Key, o.Source, o.Value, w.Source, w.Value)
+		}
+	}
+
+	if len(origins) != len(want) {
+		t.Errorf("%d claims in the provenance, want %d: %v", len(origins), len(want), origins)
+	}
+}
+
+func TestClaimProvenanceDisabled(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub alice
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	s.l = zap.New(core)
+
+	if p := s.newProvenance(); p != nil {
+		t.Fatal("provenance recorded without debug logging")
+	}
+
+	r, _ := newTestRequest(http.MethodGet, "/", nil)
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := logs.FilterMessage("Claim provenance").Len(); n != 0 {
+		t.Fatalf("%d provenance entries logged at info level", n)
+	}
+}
+
+func TestClaimSource(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{"reader", "static"},
+		{42.0, "static"},
+		{[]any{"a", "b"}, "static"},
+		{"{http.request.header.X-User-Id}", "header:X-User-Id"},
+		{"{http.request.cookie.session}", "cookie:session"},
+		{"{http.request.uri.query.tenant}", "query:tenant"},
+		{"{http.vars.client_ip}", "var:client_ip"},
+		{"{env.HOME}", "env:HOME"},
+		{"{http.request.host}", "placeholder:http.request.host"},
+		{"user-{http.request.header.X-User}", "template"},
+		{"{http.request.header.A}{http.request.header.B}", "template"},
+	}
+
+	for _, tt := range tests {
+		if got := claimSource(tt.value); got != tt.want {
+			t.Errorf("claimSource(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// push starts an HTTP/2 server push of the cookie-setting endpoint with the token in its Authorization header,
+// so the browser holds the cookie by the time it follows a redirect. Writers without push support, or clients
+// which disabled it, are skipped silently since the token is delivered the usual way regardless.
+func (s *JwtSigner) push(w http.ResponseWriter, repl *caddy.Replacer, tosStr string) {
+	if !s.UseHTTP2Push {
+		return
+	}
+
+	pusher, ok := w.(http.Pusher)
+	if !ok {
+		return
+	}
+
+	target := repl.ReplaceAll(s.HTTP2PushTarget, "")
+
+	err := pusher.Push(target, &http.PushOptions{
+		Header: http.Header{"Authorization": []string{"Bearer " + tosStr}},
+	})
+	if err != nil {
+		s.l.Debug("HTTP/2 push skipped", zap.String("target", target), zap.Error(err))
+		return
+	}
+
+	w.Header().Add("Link", "<"+target+">; rel=preload; as=fetch")
+}
+
+func (s *JwtSigner) validateHTTP2Push() error {
+	if !s.UseHTTP2Push {
+		return nil
+	}
+
+	if s.HTTP2PushTarget == "" {
+		return fmt.Errorf("use_http2_push requires a push target")
+	}
+
+	if !strings.HasPrefix(s.HTTP2PushTarget, "/") && !strings.HasPrefix(s.HTTP2PushTarget, "{") {
+		return fmt.Errorf("http2_push target must be an absolute path: %s", s.HTTP2PushTarget)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordingPusher records the pushes of a handler, failing them with err when set.
+type recordingPusher struct {
+	*httptest.ResponseRecorder
+
+	err     error
+	targets []string
+	auth    []string
+}
+
+func (p *recordingPusher) Push(target string, opts *http.PushOptions) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.targets = append(p.targets, target)
+	p.auth = append(p.auth, opts.Header.Get("Authorization"))
+
+	return nil
+}
+
+func TestHTTP2Push(t *testing.T) {
+	tests := []struct {
+		name, options string
+		pusher        bool
+		pushErr       error
+		wantTarget    string
+	}{
+		{
+			name:       "pushed",
+			options:    "http2_push /session",
+			pusher:     true,
+			wantTarget: "/session",
+		},
+		{
+			name:       "placeholder target",
+			options:    "http2_push /session/{http.request.header.X-App}",
+			pusher:     true,
+			wantTarget: "/session/web",
+		},
+		{
+			name:    "push refused",
+			options: "http2_push /session",
+			pusher:  true,
+			pushErr: http.ErrNotSupported,
+		},
+		{
+			name:    "writer without push",
+			options: "http2_push /session",
+		},
+		{
+			name:   "push not configured",
+			pusher: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				sub alice
+				options {
+					`+tt.options+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-App", "web")
+
+			rec := httptest.NewRecorder()
+			p := &recordingPusher{ResponseRecorder: rec, err: tt.pushErr}
+
+			var w http.ResponseWriter = rec
+			if tt.pusher {
+				w = p
+			}
+
+			if err := s.ServeHTTP(w, r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+			if tosStr == "" {
+				t.Fatal("no token signed")
+			}
+
+			link := rec.Header().Get("Link")
+
+			if tt.wantTarget == "" {
+				if len(p.targets) > 0 || link != "" {
+					t.Fatalf("pushed %v with Link %q", p.targets, link)
+				}
+				return
+			}
+
+			if len(p.targets) != 1 || p.targets[0] != tt.wantTarget || p.auth[0] != "Bearer "+tosStr {
+				t.Fatalf("pushed %v with %v, want %s with the token", p.targets, p.auth, tt.wantTarget)
+			}
+
+			if want := "<" + tt.wantTarget + ">; rel=preload; as=fetch"; link != want {
+				t.Fatalf("Link = %q, want %q", link, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+type IncludeQueryConfig struct {
+	Allow  []string `json:"allow,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+// registeredClaims are the claims of RFC 7519 which clients must never choose themselves.
+var registeredClaims = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+// checkRequestClaim refuses to copy a value of the request into a registered or configured claim, which would let
+// clients set it.
+func (s *JwtSigner) checkRequestClaim(claim string) error {
+	if slices.Contains(registeredClaims, claim) {
+		return fmt.Errorf("registered claim %s cannot be taken from the request", claim)
+	}
+
+	_, static := s.Claims[claim]
+	_, env := s.EnvClaims[claim]
+	_, hash := s.HashClaims[claim]
+	if static || env || hash {
+		return fmt.Errorf("configured claim %s cannot be taken from the request", claim)
+	}
+
+	return nil
+}
+
+func (iq *IncludeQueryConfig) validate(s *JwtSigner) error {
+	if len(iq.Allow) == 0 {
+		return fmt.Errorf("include_query requires the parameters to allow")
+	}
+
+	for _, key := range iq.Allow {
+		if err := s.checkRequestClaim(iq.Prefix + key); err != nil {
+			return fmt.Errorf("include_query: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (iq *IncludeQueryConfig) apply(cs jwt.MapClaims, r *http.Request, missing missingFunc, l *zap.Logger) error {
+	query := r.URL.Query()
+
+	for _, key := range iq.Allow {
+		// only the first value of a repeated parameter is used
+		val := query.Get(key)
+		if val == "" {
+			if err := missing(iq.Prefix+key, "query parameter "+key); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		l.Debug("Query parameter included", zap.String("param", key), zap.String("claim", iq.Prefix+key))
+		cs[iq.Prefix+key] = val
+	}
+
+	return nil
+}
+
+func (iq *IncludeQueryConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "allow":
+			keys := d.RemainingArgs()
+			if len(keys) == 0 {
+				return d.ArgErr()
+			}
+
+			iq.Allow = append(iq.Allow, keys...)
+		case "prefix":
+			if !d.Args(&iq.Prefix) {
+				return d.ArgErr()
+			}
+
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown include_query option: %s", d.Val())
+		}
+	}
+
+	return nil
+}
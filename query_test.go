@@ -0,0 +1,145 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIncludeQuery(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		aud signer
+		options {
+			include_query {
+				allow user order
+				prefix q_
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   map[string]any
+		absent []string
+	}{
+		{
+			name:   "allowed parameters",
+			target: "/?user=alice&order=7",
+			want:   map[string]any{"q_user": "alice", "q_order": "7", "aud": "signer"},
+		},
+		{
+			name:   "first value of a repeated parameter",
+			target: "/?user=alice&user=bob",
+			want:   map[string]any{"q_user": "alice"},
+		},
+		{
+			name:   "missing and empty parameters",
+			target: "/?order=",
+			absent: []string{"q_user", "q_order"},
+		},
+		{
+			name:   "parameters not allowed",
+			target: "/?aud=evil&sub=root&exp=1&q_user=mallory",
+			want:   map[string]any{"aud": "signer"},
+			absent: []string{"sub", "q_aud", "q_sub", "q_user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, repl := newTestRequest(http.MethodGet, tt.target, nil)
+
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			cs := parseTestToken(t, repl)
+			for k, v := range tt.want {
+				if cs[k] != v {
+					t.Errorf("%s = %v, want %v", k, cs[k], v)
+				}
+			}
+			for _, k := range tt.absent {
+				if v, ok := cs[k]; ok {
+					t.Errorf("%s set to %v", k, v)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateIncludeQuery(t *testing.T) {
+	tests := []struct {
+		name, cfg, wantErr string
+	}{
+		{
+			name:    "allow missing",
+			cfg:     `include_query`,
+			wantErr: "include_query requires the parameters to allow",
+		},
+		{
+			name: "registered claim",
+			cfg: `include_query {
+					allow user aud
+				}`,
+			wantErr: "registered claim aud cannot be taken from the request",
+		},
+		{
+			name: "registered claim through the prefix",
+			cfg: `include_query {
+					allow p
+					prefix ex
+				}`,
+			wantErr: "registered claim exp cannot be taken from the request",
+		},
+		{
+			name: "configured claim",
+			cfg: `include_query {
+					allow role
+				}`,
+			wantErr: "configured claim role cannot be taken from the request",
+		},
+		{
+			name: "env claim",
+			cfg: `include_query {
+					allow home
+				}`,
+			wantErr: "configured claim home cannot be taken from the request",
+		},
+		{
+			name: "prefixed away from the configured claim",
+			cfg: `include_query {
+					allow role
+					prefix q_
+				}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				role user
+				home env HOME
+				options {
+					`+tt.cfg+`
+				}
+			}`)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
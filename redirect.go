@@ -0,0 +1,188 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	placementQuery    = "query"
+	placementFragment = "fragment"
+)
+
+type RedirectConfig struct {
+	To         string   `json:"to"`
+	Placement  string   `json:"placement,omitempty"`
+	Param      string   `json:"param,omitempty"`
+	ExpiresIn  bool     `json:"expires_in,omitempty"`
+	StateParam string   `json:"state_param,omitempty"`
+	AllowHosts []string `json:"allow_hosts,omitempty"`
+	Status     int      `json:"status,omitempty"`
+}
+
+func (rc *RedirectConfig) provision() {
+	if rc.Placement == "" {
+		rc.Placement = placementQuery
+	}
+
+	if rc.Param == "" {
+		rc.Param = "token"
+	}
+
+	if rc.Status == 0 {
+		rc.Status = http.StatusFound
+	}
+}
+
+func (rc *RedirectConfig) validate() error {
+	if rc.To == "" {
+		return fmt.Errorf("missing redirect target")
+	}
+
+	switch rc.Placement {
+	case placementQuery, placementFragment:
+	default:
+		return fmt.Errorf("unknown redirect placement: %s", rc.Placement)
+	}
+
+	if rc.Status < 300 || rc.Status > 399 {
+		return fmt.Errorf("redirect status must be 3xx, got %d", rc.Status)
+	}
+
+	return nil
+}
+
+func (rc *RedirectConfig) location(r *http.Request, repl *caddy.Replacer, tosStr string, cs jwt.MapClaims) (string, error) {
+	target := repl.ReplaceAll(rc.To, "")
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid redirect target: %w", err))
+	}
+
+	if !rc.allowed(u, r) {
+		return "", caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("redirect target host not allowed: %s", u.Host))
+	}
+
+	vals := url.Values{}
+	vals.Set(rc.Param, tosStr)
+
+	if rc.ExpiresIn {
+		if exp, ok := cs["exp"].(int64); ok {
+			vals.Set("expires_in", strconv.FormatInt(exp-time.Now().Unix(), 10))
+		}
+	}
+
+	if rc.StateParam != "" {
+		if state := r.URL.Query().Get(rc.StateParam); state != "" {
+			vals.Set("state", state)
+		}
+	}
+
+	var loc string
+	switch rc.Placement {
+	case placementFragment:
+		frag := u.EscapedFragment()
+		u.Fragment, u.RawFragment = "", ""
+
+		if frag != "" {
+			frag += "&"
+		}
+
+		loc = u.String() + "#" + frag + vals.Encode()
+	default:
+		q := u.Query()
+		for k, v := range vals {
+			q[k] = v
+		}
+
+		u.RawQuery = q.Encode()
+		loc = u.String()
+	}
+
+	return loc, nil
+}
+
+// allowed restricts absolute targets to the allowlisted hosts, or to the request host when no allowlist is set.
+func (rc *RedirectConfig) allowed(u *url.URL, r *http.Request) bool {
+	if u.Host == "" {
+		return u.Scheme == "" && !strings.HasPrefix(u.Path, "//")
+	}
+
+	if len(rc.AllowHosts) == 0 {
+		return strings.EqualFold(u.Host, r.Host)
+	}
+
+	return slices.ContainsFunc(rc.AllowHosts, func(h string) bool {
+		return strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname())
+	})
+}
+
+func (rc *RedirectConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&rc.To) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "placement":
+			if !d.Args(&rc.Placement) {
+				return d.ArgErr()
+			}
+		case "fragment":
+			rc.Placement = placementFragment
+		case "param":
+			if !d.Args(&rc.Param) {
+				return d.ArgErr()
+			}
+		case "expires_in":
+			rc.ExpiresIn = true
+		case "state_param":
+			if !d.Args(&rc.StateParam) {
+				return d.ArgErr()
+			}
+		case "allow_hosts":
+			hosts := d.RemainingArgs()
+			if len(hosts) == 0 {
+				return d.ArgErr()
+			}
+
+			rc.AllowHosts = append(rc.AllowHosts, hosts...)
+			continue
+		case "status":
+			var statusStr string
+			if !d.Args(&statusStr) {
+				return d.ArgErr()
+			}
+
+			status, err := strconv.Atoi(statusStr)
+			if err != nil {
+				return d.Errf("invalid redirect status %s: %v", statusStr, err)
+			}
+
+			rc.Status = status
+		default:
+			return d.Errf("unknown redirect option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	onInvalidReject = "reject"
+	onInvalidIssue  = "issue"
+)
+
+// RefreshConfig re-issues a presented token with the same claims once it gets close to expiry.
+type RefreshConfig struct {
+	VerifyConfig
+
+	Within    caddy.Duration `json:"if_expiring_within,omitempty"`
+	OnInvalid string         `json:"on_invalid,omitempty"`
+}
+
+func (rc *RefreshConfig) provision() {
+	rc.VerifyConfig.provision()
+
+	if rc.OnInvalid == "" {
+		rc.OnInvalid = onInvalidReject
+	}
+}
+
+func (rc *RefreshConfig) validate() error {
+	if err := rc.VerifyConfig.validate(); err != nil {
+		return err
+	}
+
+	if rc.JWKS != nil || len(rc.Scopes) > 0 {
+		return fmt.Errorf("refresh verifies the module's own tokens and cannot use jwks or require_scope")
+	}
+
+	if rc.Within <= 0 {
+		return fmt.Errorf("refresh_if_expiring_within must be positive")
+	}
+
+	switch rc.OnInvalid {
+	case onInvalidReject, onInvalidIssue:
+	default:
+		return fmt.Errorf("unknown refresh on_invalid mode: %s", rc.OnInvalid)
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) serveRefresh(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	var (
+		presented jwt.MapClaims
+		err       = fmt.Errorf("no token presented")
+	)
+
+	tosStr := s.Refresh.token(r)
+	if tosStr != "" {
+		presented, err = s.parse(tosStr, repl, append(s.Refresh.parserOptions(), jwt.WithJSONNumber())...)
+		if err == nil {
+			err = s.Refresh.expect(tosStr, presented)
+		}
+		if err == nil {
+			err = s.checkRevoked(presented)
+		}
+	}
+
+	if err != nil {
+		if s.Refresh.OnInvalid == onInvalidReject {
+			return s.rejectPresented(w, tosStr, err)
+		}
+
+		s.l.Debug("No valid token presented, issue a new one", zap.Error(err))
+
+		return s.issue(w, r, repl, next, nil)
+	}
+
+	exp, err := presented.GetExpirationTime()
+	if err != nil {
+		return caddyhttp.Error(http.StatusUnauthorized, err)
+	}
+
+	if remaining := time.Until(exp.Time); remaining > time.Duration(s.Refresh.Within) {
+		s.l.Debug("Token is still fresh, skip signing", zap.Duration("remaining", remaining))
+
+		repl.Set("http.jwt_signer.digest_str", tosStr)
+		setIssuedClaimPlaceholders(repl, "", presented)
+		s.exportVars(r, presented)
+
+		return next.ServeHTTP(w, withClaims(r, presented))
+	}
+
+	keep := make(jwt.MapClaims, len(presented))
+	for k, v := range presented {
+		keep[k] = v
+	}
+
+	for _, k := range reissueStripClaims {
+		delete(keep, k)
+	}
+
+	s.l.Debug("Token is due for refresh", zap.Int("claims", len(keep)))
+
+	return s.issue(w, r, repl, next, keep)
+}
+
+func (rc *RefreshConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	var durStr string
+	if !d.Args(&durStr) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	dur, err := caddy.ParseDuration(durStr)
+	if err != nil {
+		return d.Errf("invalid refresh_if_expiring_within %s: %v", durStr, err)
+	}
+
+	rc.Within = caddy.Duration(dur)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "on_invalid":
+			if !d.Args(&rc.OnInvalid) {
+				return d.ArgErr()
+			}
+
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		default:
+			if err := rc.VerifyConfig.unmarshalOption(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
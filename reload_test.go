@@ -0,0 +1,167 @@
+package jwt_signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeECKey writes a new P-256 key to path, dated mtime.
+func writeECKey(t *testing.T, path string, mtime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	writeECKey(t, path, time.Now().Add(-time.Hour))
+
+	cfg := `jwt_signer 5m {
+		sub alice
+		options {
+			key_file ` + path + `
+		}
+	}`
+
+	first, err := provisionSigner(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := fileIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as on a reload, the new config is provisioned before the old one is cleaned up
+	prev := first
+	for cycle := range 3 {
+		next, err := provisionSigner(t, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = prev.Cleanup()
+
+		if next.moduleKey != first.moduleKey {
+			t.Fatalf("cycle %d parsed the unchanged key file again", cycle)
+		}
+		if refs, _ := loadedKeys.References(id); refs != 1 {
+			t.Fatalf("cycle %d: key held %d times, want 1", cycle, refs)
+		}
+
+		prev = next
+	}
+
+	// a replaced file is told apart by its modification time, and the old key goes with the last config using it
+	writeECKey(t, path, time.Now())
+
+	next, err := provisionSigner(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = prev.Cleanup()
+
+	if next.moduleKey == first.moduleKey {
+		t.Fatal("replaced key file not loaded again")
+	}
+	if refs, ok := loadedKeys.References(id); ok || refs != 0 {
+		t.Fatalf("old key held %d times after the reload", refs)
+	}
+}
+
+func TestReloadJWKS(t *testing.T) {
+	srv, fetches := serveJWKS(t)
+	defer srv.Close()
+
+	cfg := `jwt_signer {
+		options {
+			verify {
+				jwks ` + srv.URL + `
+			}
+		}
+	}`
+
+	prev, err := provisionSigner(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := prev.Verify.JWKS.keySet
+
+	for cycle := range 3 {
+		next, err := provisionSigner(t, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = prev.Cleanup()
+		// Caddy cleans up once, a second call must not release the set under the new config
+		_ = prev.Cleanup()
+
+		if next.Verify.JWKS.keySet != set {
+			t.Fatalf("cycle %d fetched a key set of its own", cycle)
+		}
+
+		prev = next
+	}
+
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("key set fetched %d times, want once", n)
+	}
+
+	// once no config uses the set, the next one fetches it anew
+	_ = prev.Cleanup()
+
+	if _, err := provisionSigner(t, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("key set fetched %d times, want twice", n)
+	}
+}
+
+func TestReloadTokenCache(t *testing.T) {
+	prev, _ := cachingSigner(t, `token_cache_ttl 1m`)
+	cachedRequest(t, prev, "alice", "5m")
+
+	for cycle := range 3 {
+		next, nm := cachingSigner(t, `token_cache_ttl 1m`)
+		_ = prev.Cleanup()
+
+		if next.tokenCache == prev.tokenCache {
+			t.Fatalf("cycle %d took over the token cache of the old config", cycle)
+		}
+
+		cachedRequest(t, next, "alice", "5m")
+		cachedRequest(t, next, "alice", "5m")
+
+		if n := nm.signed.Load(); n != 1 {
+			t.Fatalf("cycle %d signed %d tokens, want 1 from its own cache", cycle, n)
+		}
+
+		prev = next
+	}
+}
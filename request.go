@@ -0,0 +1,68 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// requestClaimPlaceholders maps the claims offered by request_claims to the placeholders filling them.
+var requestClaimPlaceholders = map[string]string{
+	"method":     "{http.request.method}",
+	"scheme":     "{http.request.scheme}",
+	"proto":      "{http.request.proto}",
+	"host":       "{http.request.host}",
+	"path":       "{http.request.uri.path}",
+	"uri":        "{http.request.uri}",
+	"query":      "{http.request.uri.query}",
+	"remote_ip":  "{http.request.remote.host}",
+	"client_ip":  "{http.vars.client_ip}",
+	"user_agent": "{http.request.header.User-Agent}",
+}
+
+func validateRequestClaims(names []string) error {
+	for _, name := range names {
+		if _, ok := requestClaimPlaceholders[name]; !ok {
+			known := make([]string, 0, len(requestClaimPlaceholders))
+			for k := range requestClaimPlaceholders {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+
+			return fmt.Errorf("unknown request claim %s, expected one of: %s", name, strings.Join(known, ", "))
+		}
+	}
+
+	return nil
+}
+
+func requestClaimsPattern(names []string) jwt.MapClaims {
+	pat := make(jwt.MapClaims, len(names))
+	for _, name := range names {
+		pat[name] = requestClaimPlaceholders[name]
+	}
+
+	return pat
+}
+
+// stripPathPrefix removes the prefix from the path when it covers whole segments, so /api/v1 is stripped from
+// /api/v1/users but not from /api/v10.
+func stripPathPrefix(path, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return path
+	}
+
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || rest != "" && !strings.HasPrefix(rest, "/") {
+		return path
+	}
+
+	if rest == "" {
+		return "/"
+	}
+
+	return rest
+}
@@ -0,0 +1,80 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestPathClaim(t *testing.T) {
+	tests := []struct {
+		name, strip, path, want string
+	}{
+		{name: "no prefix configured", path: "/api/v1/users", want: "/api/v1/users"},
+		{name: "prefix stripped", strip: "/api/v1", path: "/api/v1/users/7", want: "/users/7"},
+		{name: "prefix with trailing slash", strip: "/api/v1/", path: "/api/v1/users", want: "/users"},
+		{name: "path equal to the prefix", strip: "/api/v1", path: "/api/v1", want: "/"},
+		{name: "prefix not on a segment boundary", strip: "/api/v1", path: "/api/v10/users", want: "/api/v10/users"},
+		{name: "path outside the prefix", strip: "/api/v1", path: "/health", want: "/health"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strip := ""
+			if tt.strip != "" {
+				strip = "path_prefix_strip " + tt.strip
+			}
+
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				options {
+					request_path_claim orig_path
+					`+strip+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, tt.path+"?page=2", nil)
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := parseTestToken(t, repl)["orig_path"]; got != tt.want {
+				t.Errorf("orig_path = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePathPrefixStrip(t *testing.T) {
+	tests := []struct {
+		name, options, wantErr string
+	}{
+		{
+			name:    "without a claim",
+			options: `path_prefix_strip /api`,
+			wantErr: "path_prefix_strip requires a request_path_claim",
+		},
+		{
+			name: "relative prefix",
+			options: `request_path_claim orig_path
+					path_prefix_strip api`,
+			wantErr: "path_prefix_strip must start with a slash: api",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				options {
+					`+tt.options+`
+				}
+			}`)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package jwt_signer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	tokenFromBody = "body"
+
+	resignExpRecompute = "recompute"
+	resignExpCap       = "cap"
+)
+
+// ResignConfig verifies a token issued by the upstream in its response and replaces it with one signed with the
+// module's key, translating tokens between the upstream's key and the one external consumers trust.
+type ResignConfig struct {
+	VerifyConfig
+
+	Key *KeyConfig `json:"key,omitempty"`
+
+	// Claims lists the upstream claims to carry over, all of them when empty. Rename applies afterwards.
+	Claims []string          `json:"claims,omitempty"`
+	Rename map[string]string `json:"rename,omitempty"`
+
+	// Exp is recompute (default) to give the token the configured duration, or cap to also never let it outlive
+	// the upstream token.
+	Exp string `json:"exp,omitempty"`
+
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	key *verificationKey
+}
+
+func (rc *ResignConfig) provision(ctx caddy.Context, l *zap.Logger) error {
+	if rc.From == "" {
+		rc.From = tokenFromBody
+	}
+
+	if rc.Exp == "" {
+		rc.Exp = resignExpRecompute
+	}
+
+	if rc.MaxSize == 0 {
+		rc.MaxSize = defaultSignResponseMaxSize
+	}
+
+	if rc.JWKS != nil {
+		if err := rc.JWKS.provision(ctx, l); err != nil {
+			return fmt.Errorf("resign jwks: %w", err)
+		}
+	}
+
+	if rc.Key != nil {
+		key, err := rc.Key.loadVerification()
+		if err != nil {
+			return fmt.Errorf("resign key: %w", err)
+		}
+
+		rc.key = key
+	}
+
+	return nil
+}
+
+func (rc *ResignConfig) validate() error {
+	switch rc.From {
+	case tokenFromBody:
+		if rc.Name != "" {
+			return fmt.Errorf("resign from body takes no name")
+		}
+	case tokenFromHeader:
+		if rc.Name == "" {
+			return fmt.Errorf("resign from header requires a header name")
+		}
+	default:
+		return fmt.Errorf("unknown resign token source: %s", rc.From)
+	}
+
+	// the source was checked above, the remaining verify options are checked as usual
+	vc := rc.VerifyConfig
+	vc.From, vc.Name = tokenFromHeader, "Authorization"
+
+	if err := vc.validate(); err != nil {
+		return err
+	}
+
+	if len(rc.Scopes) > 0 || rc.SingleUse || rc.ReplayProtection != nil || rc.DPoP != nil {
+		return fmt.Errorf("resign cannot use require_scope, single_use, replay_protection or dpop")
+	}
+
+	if rc.Key != nil && rc.JWKS != nil {
+		return fmt.Errorf("resign key and jwks are mutually exclusive")
+	}
+
+	for from, to := range rc.Rename {
+		if from == "" || to == "" {
+			return fmt.Errorf("resign rename requires non-empty claim names")
+		}
+	}
+
+	switch rc.Exp {
+	case resignExpRecompute, resignExpCap:
+	default:
+		return fmt.Errorf("unknown resign exp mode: %s", rc.Exp)
+	}
+
+	if rc.MaxSize < 0 {
+		return fmt.Errorf("resign max_size must not be negative")
+	}
+
+	return nil
+}
+
+func (rc *ResignConfig) mapClaims(in jwt.MapClaims) jwt.MapClaims {
+	out := make(jwt.MapClaims, len(in))
+
+	if len(rc.Claims) == 0 {
+		for k, v := range in {
+			out[k] = v
+		}
+	} else {
+		for _, k := range rc.Claims {
+			if v, ok := in[k]; ok {
+				out[k] = v
+			}
+		}
+	}
+
+	for _, k := range reissueStripClaims {
+		delete(out, k)
+	}
+
+	for from, to := range rc.Rename {
+		if v, ok := out[from]; ok {
+			delete(out, from)
+			out[to] = v
+		}
+	}
+
+	return out
+}
+
+// upstreamToken reads the token from the recorded response, an Authorization header has to use the Bearer scheme.
+func (rc *ResignConfig) upstreamToken(h http.Header, body []byte) string {
+	if rc.From == tokenFromBody {
+		return strings.TrimSpace(string(body))
+	}
+
+	val := h.Get(rc.Name)
+	if !strings.EqualFold(rc.Name, "Authorization") {
+		return val
+	}
+
+	return bearerToken(val)
+}
+
+func (s *JwtSigner) parseUpstream(tosStr string, repl *caddy.Replacer) (jwt.MapClaims, error) {
+	opts := s.Resign.parserOptions()
+
+	if s.Resign.JWKS != nil {
+		return s.Resign.JWKS.parse(tosStr, opts...)
+	}
+
+	if s.Resign.key == nil {
+		return s.parse(tosStr, repl, opts...)
+	}
+
+	return s.Resign.key.parse(tosStr, opts...)
+}
+
+func (s *JwtSigner) serveResign(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	rc := s.Resign
+
+	rec := &bodyRecorder{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		limit:                 rc.MaxSize,
+	}
+
+	if err := next.ServeHTTP(rec, r); err != nil {
+		return err
+	}
+
+	if rec.passThrough {
+		s.l.Debug("Upstream response is not successful, passed it on", zap.Int("status", rec.status))
+		return nil
+	}
+
+	if rec.overflow {
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("response body exceeds %d bytes", rc.MaxSize))
+	}
+
+	inStr := rc.upstreamToken(w.Header(), rec.buf.Bytes())
+	if inStr == "" {
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("upstream response carries no token"))
+	}
+
+	in, err := s.parseUpstream(inStr, repl)
+	if err == nil {
+		err = rc.expect(inStr, in)
+	}
+	if err != nil {
+		s.l.Error("Upstream token rejected", zap.Error(err))
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("upstream token rejected: %w", err))
+	}
+
+	s.setClaimPlaceholders(repl, in)
+
+	if rc.Exp == resignExpCap {
+		if exp, err := in.GetExpirationTime(); err == nil && exp != nil {
+			r = r.WithContext(context.WithValue(r.Context(), deadlineKey{}, exp.Time))
+		}
+	}
+
+	extra, err := s.extraClaims(repl)
+	if err != nil {
+		return err
+	}
+
+	tosStr, cs, err := s.sign(r, repl, rc.mapClaims(in), extra)
+	if errors.Is(err, errSkipSigning) {
+		w.WriteHeader(rec.Status())
+		_, err = w.Write(rec.buf.Bytes())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	s.l.Debug("Upstream token re-signed", zap.Int("upstream_claims", len(in)), zap.Int("claims", len(cs)))
+
+	s.deliver(w, r, repl, tosStr, cs)
+	s.noStore(w)
+
+	if rc.From == tokenFromHeader {
+		val := tosStr
+		if strings.EqualFold(rc.Name, "Authorization") {
+			val = "Bearer " + tosStr
+		}
+
+		w.Header().Set(rc.Name, val)
+		w.WriteHeader(rec.Status())
+		_, err = w.Write(rec.buf.Bytes())
+		return err
+	}
+
+	h := w.Header()
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", "application/jwt")
+	h.Set("Content-Length", strconv.Itoa(len(tosStr)))
+
+	w.WriteHeader(rec.Status())
+	_, err = io.WriteString(w, tosStr)
+	return err
+}
+
+func (rc *ResignConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Args(&rc.From)
+
+	if rc.From == tokenFromHeader && !d.Args(&rc.Name) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "key":
+			rc.Key = &KeyConfig{}
+			if err := rc.Key.unmarshalCaddyfile(d); err != nil {
+				return err
+			}
+
+			continue
+		case "claims":
+			claims := d.RemainingArgs()
+			if len(claims) == 0 {
+				return d.ArgErr()
+			}
+
+			rc.Claims = append(rc.Claims, claims...)
+			continue
+		case "rename":
+			var from, to string
+			if !d.Args(&from, &to) {
+				return d.ArgErr()
+			}
+
+			if rc.Rename == nil {
+				rc.Rename = map[string]string{}
+			}
+
+			rc.Rename[from] = to
+		case "exp":
+			if !d.Args(&rc.Exp) {
+				return d.ArgErr()
+			}
+		case "max_size":
+			var sizeStr string
+			if !d.Args(&sizeStr) {
+				return d.ArgErr()
+			}
+
+			size, err := humanize.ParseBytes(sizeStr)
+			if err != nil {
+				return d.Errf("invalid resign max_size %s: %v", sizeStr, err)
+			}
+
+			rc.MaxSize = int64(size)
+		default:
+			if err := rc.VerifyConfig.unmarshalOption(d); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
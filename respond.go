@@ -0,0 +1,128 @@
+package jwt_signer
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type RespondJSONConfig struct {
+	OmitToken bool   `json:"omit_token,omitempty"`
+	CSRFClaim string `json:"csrf_claim,omitempty"`
+
+	// Status is the response status, 200 by default. It must be 2xx unless AllowAnyStatus is set.
+	Status         int  `json:"status,omitempty"`
+	AllowAnyStatus bool `json:"allow_any_status,omitempty"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+	CSRFToken   string `json:"csrf_token,omitempty"`
+}
+
+func (rj *RespondJSONConfig) provision() {
+	if rj.Status == 0 {
+		rj.Status = http.StatusOK
+	}
+}
+
+func (rj *RespondJSONConfig) validate() error {
+	if rj.AllowAnyStatus {
+		if rj.Status < 200 || rj.Status > 599 {
+			return fmt.Errorf("respond_json status must be between 200 and 599, got %d", rj.Status)
+		}
+
+		return nil
+	}
+
+	if rj.Status < 200 || rj.Status > 299 {
+		return fmt.Errorf("respond_json status must be 2xx unless allow_any_status is set, got %d", rj.Status)
+	}
+
+	return nil
+}
+
+func newCSRFToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (rj *RespondJSONConfig) write(w http.ResponseWriter, tosStr string, cs jwt.MapClaims) error {
+	resp := tokenResponse{}
+
+	if !rj.OmitToken {
+		resp.AccessToken = tosStr
+		resp.TokenType = "Bearer"
+
+		if boundThumbprint(cs) != "" {
+			resp.TokenType = dpopScheme
+		}
+	}
+
+	if exp, ok := cs["exp"].(int64); ok {
+		resp.ExpiresIn = exp - time.Now().Unix()
+	}
+
+	resp.Scope, _ = cs["scope"].(string)
+
+	if rj.CSRFClaim != "" {
+		resp.CSRFToken, _ = cs[rj.CSRFClaim].(string)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(rj.Status)
+
+	return json.NewEncoder(w).Encode(resp)
+}
+
+func (rj *RespondJSONConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "omit_token":
+			rj.OmitToken = true
+		case "csrf":
+			rj.CSRFClaim = "csrf"
+			d.Args(&rj.CSRFClaim)
+		case "status":
+			var statusStr string
+			if !d.Args(&statusStr) {
+				return d.ArgErr()
+			}
+
+			status, err := strconv.Atoi(statusStr)
+			if err != nil {
+				return d.Errf("invalid respond_json status %s: %v", statusStr, err)
+			}
+
+			rj.Status = status
+		case "allow_any_status":
+			rj.AllowAnyStatus = true
+		default:
+			return d.Errf("unknown respond_json option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
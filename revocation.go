@@ -0,0 +1,237 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/certmagic"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRevocationPrefix   = "jwt_signer/revoked"
+	defaultRevocationInterval = time.Minute
+)
+
+var errTokenRevoked = errors.New("token has been revoked")
+
+// revocationLists are shared by all handlers using the same storage prefix, across config reloads too.
+var revocationLists = caddy.NewUsagePool()
+
+// RevocationConfig rejects presented tokens whose jti was revoked through the admin API.
+type RevocationConfig struct {
+	Prefix          string         `json:"storage_prefix,omitempty"`
+	RefreshInterval caddy.Duration `json:"refresh_interval,omitempty"`
+
+	list *revocationList
+}
+
+// revocationEntry is what the storage keeps per revoked jti, entries are pruned once the token would have expired.
+type revocationEntry struct {
+	JTI string `json:"jti"`
+	Exp int64  `json:"exp"`
+}
+
+type revocationList struct {
+	storage certmagic.Storage
+	prefix  string
+	l       *zap.Logger
+
+	mu      sync.RWMutex
+	revoked map[string]int64
+
+	stop chan struct{}
+}
+
+func (rc *RevocationConfig) provision(ctx caddy.Context, l *zap.Logger) error {
+	if rc.Prefix == "" {
+		rc.Prefix = defaultRevocationPrefix
+	}
+
+	if rc.RefreshInterval == 0 {
+		rc.RefreshInterval = caddy.Duration(defaultRevocationInterval)
+	}
+
+	if rc.RefreshInterval < 0 {
+		return fmt.Errorf("revocation refresh_interval must not be negative")
+	}
+
+	val, _, err := revocationLists.LoadOrNew(rc.Prefix, func() (caddy.Destructor, error) {
+		list := &revocationList{
+			storage: ctx.Storage(),
+			prefix:  rc.Prefix,
+			l:       l,
+			revoked: map[string]int64{},
+			stop:    make(chan struct{}),
+		}
+
+		list.refresh()
+		go list.run(time.Duration(rc.RefreshInterval))
+
+		return list, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rc.list = val.(*revocationList)
+
+	return nil
+}
+
+func (rc *RevocationConfig) cleanup() {
+	if rc.list != nil {
+		_, _ = revocationLists.Delete(rc.Prefix)
+	}
+}
+
+// checkRevoked fails for a presented token whose jti is on the revocation list.
+func (s *JwtSigner) checkRevoked(cs jwt.MapClaims) error {
+	if s.Revocation == nil {
+		return nil
+	}
+
+	jti, _ := cs["jti"].(string)
+	if jti == "" || !s.Revocation.list.contains(jti) {
+		return nil
+	}
+
+	return errTokenRevoked
+}
+
+func (rl *revocationList) contains(jti string) bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	_, ok := rl.revoked[jti]
+
+	return ok
+}
+
+func (rl *revocationList) key(jti string) string {
+	sum := sha256.Sum256([]byte(jti))
+	return path.Join(rl.prefix, hex.EncodeToString(sum[:]))
+}
+
+func (rl *revocationList) revoke(ctx context.Context, jti string, exp int64) error {
+	raw, err := json.Marshal(revocationEntry{JTI: jti, Exp: exp})
+	if err != nil {
+		return err
+	}
+
+	if err := rl.storage.Store(ctx, rl.key(jti), raw); err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	rl.revoked[jti] = exp
+	rl.mu.Unlock()
+
+	return nil
+}
+
+func (rl *revocationList) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+			rl.refresh()
+		}
+	}
+}
+
+// refresh reloads the list from storage and prunes entries of tokens which have expired by now.
+func (rl *revocationList) refresh() {
+	ctx := context.Background()
+	now := time.Now().Unix()
+
+	keys, err := rl.storage.List(ctx, rl.prefix, false)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		rl.l.Warn("Loading revocation list failed, keep the current one", zap.Error(err))
+		return
+	}
+
+	revoked := make(map[string]int64, len(keys))
+
+	for _, key := range keys {
+		raw, err := rl.storage.Load(ctx, key)
+		if err != nil {
+			continue
+		}
+
+		var entry revocationEntry
+		if err := json.Unmarshal(raw, &entry); err != nil || entry.JTI == "" {
+			continue
+		}
+
+		if entry.Exp != 0 && entry.Exp < now {
+			_ = rl.storage.Delete(ctx, key)
+			continue
+		}
+
+		revoked[entry.JTI] = entry.Exp
+	}
+
+	rl.mu.Lock()
+	rl.revoked = revoked
+	rl.mu.Unlock()
+
+	rl.l.Debug("Revocation list refreshed", zap.Int("revoked", len(revoked)))
+}
+
+func (rl *revocationList) Destruct() error {
+	close(rl.stop)
+	return nil
+}
+
+func (rc *RevocationConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "storage_prefix":
+			if !d.Args(&rc.Prefix) {
+				return d.ArgErr()
+			}
+		case "refresh_interval":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid refresh_interval %s: %v", durStr, err)
+			}
+
+			rc.RefreshInterval = caddy.Duration(dur)
+		default:
+			return d.Errf("unknown revocation option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
+
+var _ caddy.Destructor = (*revocationList)(nil)
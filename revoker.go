@@ -0,0 +1,205 @@
+package jwt_signer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(&JwtRevoker{})
+	httpcaddyfile.RegisterHandlerDirective("jwt_signer_revoke", parseRevokerCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("jwt_signer_revoke", httpcaddyfile.Before, "respond")
+}
+
+// JwtRevoker is a terminal handler revoking tokens issued by jwt_signer, following RFC 7009: the token is sent
+// as the token form parameter or as a Bearer token, and the response is 200 whether or not it was valid.
+type JwtRevoker struct {
+	KeyConfig
+
+	Revocation *RevocationConfig `json:"revocation,omitempty"`
+
+	// AllowJTI accepts a bare jti form parameter (and an optional exp) in place of a token, for admin tooling.
+	// Protect the route accordingly.
+	AllowJTI bool `json:"allow_jti,omitempty"`
+
+	RequestBodyLimitBytes int64 `json:"request_body_limit_bytes,omitempty"`
+
+	key *verificationKey
+	l   *zap.Logger
+}
+
+func (*JwtRevoker) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.jwt_signer_revoke",
+		New: func() caddy.Module { return new(JwtRevoker) },
+	}
+}
+
+func (rv *JwtRevoker) Provision(ctx caddy.Context) error {
+	rv.l = ctx.Logger()
+
+	key, err := rv.KeyConfig.loadVerification()
+	if err != nil {
+		return err
+	}
+
+	rv.key = key
+
+	if rv.RequestBodyLimitBytes <= 0 {
+		rv.RequestBodyLimitBytes = defaultRequestBodyLimit
+	}
+
+	if rv.Revocation == nil {
+		rv.Revocation = &RevocationConfig{}
+	}
+
+	return rv.Revocation.provision(ctx, rv.l)
+}
+
+func (rv *JwtRevoker) Cleanup() error {
+	rv.Revocation.cleanup()
+	return nil
+}
+
+func (rv *JwtRevoker) ServeHTTP(w http.ResponseWriter, r *http.Request, _ caddyhttp.Handler) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return caddyhttp.Error(http.StatusMethodNotAllowed, fmt.Errorf("revocation requires POST"))
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, rv.RequestBodyLimitBytes)
+	if err := r.ParseForm(); err != nil {
+		if bodyTooLarge(err) {
+			return caddyhttp.Error(http.StatusRequestEntityTooLarge, err)
+		}
+
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("parsing revocation request: %w", err))
+	}
+
+	tosStr := r.PostForm.Get("token")
+	if tosStr == "" {
+		tosStr = bearerToken(r.Header.Get("Authorization"))
+	}
+
+	var (
+		jti, sub string
+		exp      int64
+	)
+
+	switch {
+	case tosStr != "":
+		cs, err := rv.key.parse(tosStr)
+		if err != nil {
+			// an invalid token cannot be used anyway, RFC 7009 has the revocation succeed
+			rv.l.Debug("Ignore invalid token presented for revocation", zap.Error(err))
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+
+		jti, _ = cs["jti"].(string)
+		sub, _ = cs["sub"].(string)
+
+		if e, err := cs.GetExpirationTime(); err == nil && e != nil {
+			exp = e.Unix()
+		}
+	case rv.AllowJTI && r.PostForm.Get("jti") != "":
+		jti = r.PostForm.Get("jti")
+
+		if expStr := r.PostForm.Get("exp"); expStr != "" {
+			var err error
+			if exp, err = strconv.ParseInt(expStr, 10, 64); err != nil {
+				return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("invalid exp: %s", expStr))
+			}
+		}
+	default:
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("no token presented for revocation"))
+	}
+
+	if jti == "" {
+		rv.l.Debug("Token presented for revocation has no jti, nothing to revoke")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	if err := rv.Revocation.list.revoke(r.Context(), jti, exp); err != nil {
+		return caddyhttp.Error(http.StatusServiceUnavailable, fmt.Errorf("storing revocation: %w", err))
+	}
+
+	rv.l.Info("Token revoked",
+		zap.String("jti", jti),
+		zap.String("sub", sub),
+		zap.String("remote_ip", r.RemoteAddr),
+		zap.Bool("by_jti", tosStr == ""),
+	)
+
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+func (rv *JwtRevoker) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+
+	d.Args(&rv.Secret)
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "secret":
+			if !d.Args(&rv.Secret) {
+				return d.ArgErr()
+			}
+		case "key_file":
+			if !d.Args(&rv.KeyFile) {
+				return d.ArgErr()
+			}
+		case "allow_jti":
+			rv.AllowJTI = true
+		case "request_body_limit_bytes", "max_body_bytes":
+			limit, err := parseSizeArg(d)
+			if err != nil {
+				return err
+			}
+
+			rv.RequestBodyLimitBytes = limit
+		case "revocation":
+			rv.Revocation = &RevocationConfig{}
+			if err := rv.Revocation.unmarshalCaddyfile(d); err != nil {
+				return err
+			}
+
+			continue
+		default:
+			return d.Errf("unknown jwt_signer_revoke option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
+
+func parseRevokerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	rv := JwtRevoker{}
+	err := rv.UnmarshalCaddyfile(h.Dispenser)
+	return &rv, err
+}
+
+var (
+	_ caddy.Provisioner           = (*JwtRevoker)(nil)
+	_ caddy.CleanerUpper          = (*JwtRevoker)(nil)
+	_ caddyhttp.MiddlewareHandler = (*JwtRevoker)(nil)
+	_ caddyfile.Unmarshaler       = (*JwtRevoker)(nil)
+)
@@ -0,0 +1,376 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	rotationFamilyClaim = "fam"
+
+	defaultRotationPrefix    = "jwt_signer/families"
+	defaultRotationFamilyTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errTokenReused   = errors.New("refresh token was already rotated")
+	errFamilyRevoked = errors.New("refresh token family is unknown, expired or revoked")
+)
+
+// RotationConfig rotates refresh tokens: every presented token is exchanged for the next generation of its
+// family, and a token presented twice revokes the whole family.
+type RotationConfig struct {
+	VerifyConfig
+
+	OnInvalid string         `json:"on_invalid,omitempty"`
+	Prefix    string         `json:"storage_prefix,omitempty"`
+	FamilyTTL caddy.Duration `json:"family_ttl,omitempty"`
+
+	storage   certmagic.Storage
+	lastSweep atomic.Int64
+
+	// sweeps are bound to the handler's lifetime, Cleanup stops them and waits for the one running
+	ctx    context.Context
+	cancel context.CancelFunc
+	sweeps sync.WaitGroup
+}
+
+// familyRecord is what the storage keeps per family, only the latest jti may be rotated.
+type familyRecord struct {
+	Latest  string    `json:"latest"`
+	Parent  string    `json:"parent,omitempty"`
+	Revoked bool      `json:"revoked,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+func (rc *RotationConfig) provision(ctx caddy.Context) {
+	rc.VerifyConfig.provision()
+
+	if rc.OnInvalid == "" {
+		rc.OnInvalid = onInvalidReject
+	}
+
+	if rc.Prefix == "" {
+		rc.Prefix = defaultRotationPrefix
+	}
+
+	if rc.FamilyTTL == 0 {
+		rc.FamilyTTL = caddy.Duration(defaultRotationFamilyTTL)
+	}
+
+	rc.storage = ctx.Storage()
+	rc.ctx, rc.cancel = context.WithCancel(ctx)
+}
+
+func (rc *RotationConfig) cleanup() {
+	if rc.cancel == nil {
+		return
+	}
+
+	rc.cancel()
+	rc.sweeps.Wait()
+}
+
+func (rc *RotationConfig) validate() error {
+	if err := rc.VerifyConfig.validate(); err != nil {
+		return err
+	}
+
+	if rc.JWKS != nil || len(rc.Scopes) > 0 {
+		return fmt.Errorf("rotation verifies the module's own tokens and cannot use jwks or require_scope")
+	}
+
+	switch rc.OnInvalid {
+	case onInvalidReject, onInvalidIssue:
+	default:
+		return fmt.Errorf("unknown rotation on_invalid mode: %s", rc.OnInvalid)
+	}
+
+	if rc.FamilyTTL < 0 {
+		return fmt.Errorf("rotation family_ttl must not be negative")
+	}
+
+	return nil
+}
+
+func (rc *RotationConfig) familyKey(fam string) string {
+	return path.Join(rc.Prefix, fam)
+}
+
+func (rc *RotationConfig) load(ctx context.Context, fam string) (*familyRecord, error) {
+	raw, err := rc.storage.Load(ctx, rc.familyKey(fam))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &familyRecord{}
+	if err := json.Unmarshal(raw, rec); err != nil {
+		return nil, fmt.Errorf("family %s: %w", fam, err)
+	}
+
+	if time.Now().After(rec.Expires) {
+		return nil, nil
+	}
+
+	return rec, nil
+}
+
+func (rc *RotationConfig) store(ctx context.Context, fam string, rec *familyRecord) error {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return rc.storage.Store(ctx, rc.familyKey(fam), raw)
+}
+
+// rotate checks that jti is the latest generation of the family and records next as its successor.
+// Storage locking makes sure concurrent rotations of the same generation cannot both succeed.
+func (rc *RotationConfig) rotate(ctx context.Context, fam, jti, next string) error {
+	lockKey := rc.familyKey(fam) + ".lock"
+	if err := rc.storage.Lock(ctx, lockKey); err != nil {
+		return fmt.Errorf("locking family %s: %w", fam, err)
+	}
+	defer rc.storage.Unlock(context.WithoutCancel(ctx), lockKey)
+
+	rec, err := rc.load(ctx, fam)
+	if err != nil {
+		return err
+	}
+
+	if rec == nil || rec.Revoked {
+		return errFamilyRevoked
+	}
+
+	if rec.Latest != jti {
+		rec.Revoked = true
+		if err := rc.store(ctx, fam, rec); err != nil {
+			return err
+		}
+
+		return errTokenReused
+	}
+
+	return rc.store(ctx, fam, &familyRecord{
+		Latest:  next,
+		Parent:  jti,
+		Expires: time.Now().Add(time.Duration(rc.FamilyTTL)),
+	})
+}
+
+// sweep drops expired families, at most once per hour.
+func (rc *RotationConfig) sweep(l *zap.Logger) {
+	now := time.Now()
+
+	last := rc.lastSweep.Load()
+	if now.Sub(time.Unix(0, last)) < time.Hour || !rc.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	rc.sweeps.Add(1)
+	go func() {
+		defer rc.sweeps.Done()
+
+		ctx := rc.ctx
+
+		keys, err := rc.storage.List(ctx, rc.Prefix, false)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				l.Warn("Listing refresh token families failed", zap.Error(err))
+			}
+
+			return
+		}
+
+		for _, key := range keys {
+			if ctx.Err() != nil {
+				return
+			}
+
+			raw, err := rc.storage.Load(ctx, key)
+			if err != nil {
+				continue
+			}
+
+			rec := &familyRecord{}
+			if json.Unmarshal(raw, rec) == nil && now.After(rec.Expires) {
+				_ = rc.storage.Delete(ctx, key)
+			}
+		}
+	}()
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (s *JwtSigner) serveRotation(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	rc := s.Rotation
+	rc.sweep(s.l)
+
+	var (
+		presented jwt.MapClaims
+		err       = fmt.Errorf("no token presented")
+	)
+
+	tosStr := rc.token(r)
+	if tosStr != "" {
+		presented, err = s.parse(tosStr, repl, append(rc.parserOptions(), jwt.WithJSONNumber())...)
+		if err == nil {
+			err = rc.expect(tosStr, presented)
+		}
+		if err == nil {
+			err = s.checkRevoked(presented)
+		}
+	}
+
+	jti, err := rotationID(presented, "jti", err)
+	fam, err := rotationID(presented, rotationFamilyClaim, err)
+
+	nextID, idErr := newTokenID()
+	if idErr != nil {
+		return idErr
+	}
+
+	if err != nil {
+		if rc.OnInvalid == onInvalidReject {
+			return s.rejectPresented(w, tosStr, err)
+		}
+
+		if fam, err = newTokenID(); err != nil {
+			return err
+		}
+
+		s.l.Debug("No valid refresh token presented, start a new family", zap.String("family", fam))
+
+		issued, cs, err := s.signIssued(r, repl, jwt.MapClaims{"jti": nextID, rotationFamilyClaim: fam})
+		if err != nil {
+			return s.skipOrFail(w, r, next, err)
+		}
+
+		err = rc.store(r.Context(), fam, &familyRecord{
+			Latest:  nextID,
+			Expires: time.Now().Add(time.Duration(rc.FamilyTTL)),
+		})
+		if err != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, fmt.Errorf("storing refresh token family: %w", err))
+		}
+
+		return s.output(w, r, repl, next, issued, cs)
+	}
+
+	keep := make(jwt.MapClaims, len(presented))
+	for k, v := range presented {
+		keep[k] = v
+	}
+
+	for _, k := range reissueStripClaims {
+		delete(keep, k)
+	}
+
+	keep["jti"] = nextID
+
+	// the next generation is signed before it becomes the latest, so a failure to sign leaves the presented token
+	// valid instead of turning its retry into a reuse
+	issued, cs, err := s.signIssued(r, repl, keep)
+	if err != nil {
+		return s.skipOrFail(w, r, next, err)
+	}
+
+	if err := rc.rotate(r.Context(), fam, jti, nextID); err != nil {
+		switch {
+		case errors.Is(err, errTokenReused):
+			s.l.Warn("Refresh token reused, family revoked", zap.String("family", fam), zap.String("jti", jti))
+			return s.rejectPresented(w, tosStr, err)
+		case errors.Is(err, errFamilyRevoked):
+			return s.rejectPresented(w, tosStr, err)
+		default:
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+	}
+
+	s.l.Debug("Refresh token rotated", zap.String("family", fam), zap.String("parent", jti))
+
+	return s.output(w, r, repl, next, issued, cs)
+}
+
+func rotationID(cs jwt.MapClaims, claim string, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	id, ok := cs[claim].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("refresh token has no %s claim", claim)
+	}
+
+	return id, nil
+}
+
+func (rc *RotationConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "on_invalid":
+			if !d.Args(&rc.OnInvalid) {
+				return d.ArgErr()
+			}
+		case "storage_prefix":
+			if !d.Args(&rc.Prefix) {
+				return d.ArgErr()
+			}
+		case "family_ttl":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid family_ttl %s: %v", durStr, err)
+			}
+
+			rc.FamilyTTL = caddy.Duration(dur)
+		default:
+			if err := rc.VerifyConfig.unmarshalOption(d); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,321 @@
+package jwt_signer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/goleak"
+)
+
+// storageContext provides a context whose storage lives in a temporary directory.
+func storageContext(t *testing.T) caddy.Context {
+	t.Helper()
+
+	// a config without storage gets the default one
+	defaultStorage := caddy.DefaultStorage
+	caddy.DefaultStorage = &certmagic.FileStorage{Path: t.TempDir()}
+	defer func() { caddy.DefaultStorage = defaultStorage }()
+
+	parent, err := caddy.ProvisionContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := caddy.NewContext(parent)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+// memStorage keeps values in memory and locks without polling, unlike the file storage which checks its locks
+// once a second, too slow for many requests contending for one family.
+type memStorage struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	locks  map[string]chan struct{}
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{values: map[string][]byte{}, locks: map[string]chan struct{}{}}
+}
+
+func (ms *memStorage) Lock(ctx context.Context, name string) error {
+	for {
+		ms.mu.Lock()
+		held, ok := ms.locks[name]
+		if !ok {
+			ms.locks[name] = make(chan struct{})
+			ms.mu.Unlock()
+
+			return nil
+		}
+		ms.mu.Unlock()
+
+		select {
+		case <-held:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (ms *memStorage) Unlock(_ context.Context, name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	close(ms.locks[name])
+	delete(ms.locks, name)
+
+	return nil
+}
+
+func (ms *memStorage) Store(_ context.Context, key string, value []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.values[key] = value
+
+	return nil
+}
+
+func (ms *memStorage) Load(_ context.Context, key string) ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	value, ok := ms.values[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	return value, nil
+}
+
+func (ms *memStorage) Delete(_ context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.values, key)
+
+	return nil
+}
+
+func (ms *memStorage) Exists(_ context.Context, key string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	_, ok := ms.values[key]
+
+	return ok
+}
+
+func (ms *memStorage) List(_ context.Context, prefix string, _ bool) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var keys []string
+	for key := range ms.values {
+		if strings.HasPrefix(key, prefix+"/") {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (ms *memStorage) Stat(_ context.Context, key string) (certmagic.KeyInfo, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	value, ok := ms.values[key]
+	if !ok {
+		return certmagic.KeyInfo{}, fs.ErrNotExist
+	}
+
+	return certmagic.KeyInfo{Key: key, Size: int64(len(value)), IsTerminal: true}, nil
+}
+
+func rotatingSigner(t *testing.T, dur string) *JwtSigner {
+	t.Helper()
+
+	s, err := provisionSignerIn(t, storageContext(t), `jwt_signer `+dur+` `+testSecret+` {
+		sub alice
+		options {
+			rotate_refresh_tokens {
+				on_invalid issue
+				from header X-Refresh
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// rotateToken presents the refresh token and returns the next generation, or the status the request failed with.
+func rotateToken(t *testing.T, s *JwtSigner, tosStr string, headers ...string) (string, int) {
+	t.Helper()
+
+	r, repl := newTestRequest(http.MethodPost, "/", nil)
+	if tosStr != "" {
+		r.Header.Set("X-Refresh", tosStr)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+
+	var he caddyhttp.HandlerError
+	if errors.As(err, &he) {
+		return "", he.StatusCode
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError
+	}
+
+	next, _ := repl.GetString("http.jwt_signer.digest_str")
+
+	return next, http.StatusOK
+}
+
+func TestRotation(t *testing.T) {
+	type step struct {
+		present    int // index of the issued token to present, -1 for none
+		wantStatus int
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "chain",
+			steps: []step{{-1, 200}, {0, 200}, {1, 200}, {2, 200}},
+		},
+		{
+			name:  "reuse revokes the family",
+			steps: []step{{-1, 200}, {0, 200}, {0, 401}, {1, 401}},
+		},
+		{
+			name:  "reuse of an older generation",
+			steps: []step{{-1, 200}, {0, 200}, {1, 200}, {0, 401}, {2, 401}},
+		},
+		{
+			name:  "families are independent",
+			steps: []step{{-1, 200}, {-1, 200}, {0, 200}, {0, 401}, {1, 200}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := rotatingSigner(t, "1h")
+
+			var issued []string
+			for i, st := range tt.steps {
+				presented := ""
+				if st.present >= 0 {
+					presented = issued[st.present]
+				}
+
+				next, status := rotateToken(t, s, presented)
+				if status != st.wantStatus {
+					t.Fatalf("step %d: status %d, want %d", i, status, st.wantStatus)
+				}
+
+				if next != "" {
+					issued = append(issued, next)
+				}
+			}
+		})
+	}
+}
+
+func TestRotationSigningFailureKeepsToken(t *testing.T) {
+	s := rotatingSigner(t, "{http.request.header.X-TTL}")
+
+	first, status := rotateToken(t, s, "", "X-TTL", "1h")
+	if status != http.StatusOK {
+		t.Fatalf("status %d issuing the first token", status)
+	}
+
+	// the next generation cannot be signed without a duration, the presented token has to stay the latest
+	if _, status := rotateToken(t, s, first); status != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500 without a duration", status)
+	}
+
+	if _, status := rotateToken(t, s, first, "X-TTL", "1h"); status != http.StatusOK {
+		t.Fatalf("status %d retrying the rotation, want 200", status)
+	}
+}
+
+func TestRotationConcurrent(t *testing.T) {
+	s := rotatingSigner(t, "1h")
+	s.Rotation.storage = newMemStorage()
+
+	first, _ := rotateToken(t, s, "")
+
+	const n = 20
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+	)
+
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, status := rotateToken(t, s, first)
+
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if statuses[http.StatusOK] != 1 || statuses[http.StatusUnauthorized] != n-1 {
+		t.Fatalf("statuses %v, want one rotation and %d rejections", statuses, n-1)
+	}
+}
+
+func TestRotationCleanupStopsSweep(t *testing.T) {
+	ctx := storageContext(t)
+
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s, err := provisionSignerIn(t, ctx, `jwt_signer 1h `+testSecret+` {
+		sub alice
+		options {
+			rotate_refresh_tokens {
+				on_invalid issue
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first request starts a sweep
+	if _, status := rotateToken(t, s, ""); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+
+	if err := s.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+}
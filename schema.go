@@ -0,0 +1,212 @@
+package jwt_signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	onSchemaErrorError = "error"
+	onSchemaErrorSkip  = "skip"
+	onSchemaErrorWarn  = "warn"
+
+	claimsSchemaURL = "claims_schema.json"
+)
+
+var errSkipSigning = errors.New("signing skipped")
+
+type ClaimsSchemaError struct {
+	Violations []string
+}
+
+func (e *ClaimsSchemaError) Error() string {
+	return fmt.Sprintf("claims do not match schema: %s", strings.Join(e.Violations, "; "))
+}
+
+func compileClaimsSchema(file string, inline json.RawMessage) (*jsonschema.Schema, error) {
+	src := []byte(inline)
+
+	if file != "" {
+		var err error
+		if src, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("reading claims schema: %w", err)
+		}
+	}
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(claimsSchemaURL, bytes.NewReader(src)); err != nil {
+		return nil, fmt.Errorf("loading claims schema: %w", err)
+	}
+
+	schema, err := c.Compile(claimsSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("compiling claims schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+func validateClaimsSchema(schema *jsonschema.Schema, cs jwt.MapClaims) error {
+	// Round-trip through JSON so the validator sees exactly what ends up in the token.
+	raw, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+
+	err = schema.Validate(doc)
+
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+
+	schemaErr := &ClaimsSchemaError{}
+
+	var collect func(*jsonschema.ValidationError)
+	collect = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			loc := ve.InstanceLocation
+			if loc == "" {
+				loc = "/"
+			}
+
+			schemaErr.Violations = append(schemaErr.Violations, fmt.Sprintf("%s: %s", loc, ve.Message))
+			return
+		}
+
+		for _, cause := range ve.Causes {
+			collect(cause)
+		}
+	}
+	collect(ve)
+
+	return schemaErr
+}
+
+func (s *JwtSigner) parseClaimsSchemaCaddyfile(d *caddyfile.Dispenser) error {
+	var kind, val string
+	if !d.Args(&kind, &val) {
+		return d.ArgErr()
+	}
+
+	switch kind {
+	case "file":
+		s.ClaimsSchemaFile = val
+	case "inline":
+		s.ClaimsSchema = json.RawMessage(val)
+	default:
+		return d.Errf("unknown claims_schema source: %s", kind)
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	return nil
+}
+
+// GenerateClaimsSchema describes the structure of the tokens issued by the handler as a JSON Schema. Claims with
+// placeholders are optional since they are dropped whenever they resolve empty, static ones are required.
+func (s *JwtSigner) GenerateClaimsSchema() ([]byte, error) {
+	schema := claimsObjectSchema(s.Claims)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+
+	props := schema["properties"].(map[string]any)
+	props["iat"] = map[string]any{"type": "integer"}
+	props["exp"] = map[string]any{"type": "integer"}
+
+	required := schema["required"].([]string)
+	required = append(required, "iat", "exp")
+
+	if len(s.Audience) > 0 {
+		switch {
+		case s.AudienceAlwaysArray:
+			props["aud"] = map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+		case s.FanOut:
+			props["aud"] = map[string]any{"type": "string"}
+		default:
+			props["aud"] = map[string]any{"type": []string{"string", "array"}, "items": map[string]any{"type": "string"}}
+		}
+	}
+
+	if s.SignResponse {
+		props["payload"] = map[string]any{}
+		required = append(required, "payload")
+	}
+
+	slices.Sort(required)
+	schema["required"] = required
+
+	return json.Marshal(schema)
+}
+
+func claimsObjectSchema(cs map[string]any) map[string]any {
+	props := make(map[string]any, len(cs))
+	required := []string{}
+
+	for k, v := range cs {
+		prop, static := claimValueSchema(v)
+		props[k] = prop
+
+		if static {
+			required = append(required, k)
+		}
+	}
+
+	slices.Sort(required)
+
+	return map[string]any{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+func claimValueSchema(v any) (map[string]any, bool) {
+	switch val := v.(type) {
+	case string:
+		return map[string]any{"type": "string"}, !strings.Contains(val, "{")
+	case bool:
+		return map[string]any{"type": "boolean"}, true
+	case float64, json.Number, int, int64:
+		return map[string]any{"type": "number"}, true
+	case map[string]any:
+		return nestedObjectSchema(val)
+	case jwt.MapClaims:
+		return nestedObjectSchema(val)
+	case []any:
+		// an array is dropped once all of its elements resolve empty, so it is required only with a static one
+		required := slices.ContainsFunc(val, func(e any) bool {
+			str, ok := e.(string)
+			return !ok || !strings.Contains(str, "{")
+		})
+
+		return map[string]any{"type": "array"}, required
+	default:
+		return map[string]any{}, true
+	}
+}
+
+// nestedObjectSchema reports the object as required only when it has a required member, since a nested object
+// whose members all resolve empty is dropped as a whole.
+func nestedObjectSchema(cs map[string]any) (map[string]any, bool) {
+	schema := claimsObjectSchema(cs)
+	return schema, len(schema["required"].([]string)) > 0
+}
@@ -0,0 +1,227 @@
+package jwt_signer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClaimsSchema = `{
+	"type": "object",
+	"required": ["sub", "role"],
+	"properties": {
+		"sub": {"type": "string"},
+		"role": {"enum": ["user", "admin"]},
+		"level": {"type": "integer"},
+		"iat": {"type": "integer"},
+		"exp": {"type": "integer"}
+	},
+	"additionalProperties": false
+}`
+
+func writeClaimsSchema(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "claims.schema.json")
+	if err := os.WriteFile(path, []byte(testClaimsSchema), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestClaimsSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  string
+		headers map[string]string
+		err     string
+	}{
+		{
+			name:    "valid",
+			claims:  `role {http.request.header.X-Role}`,
+			headers: map[string]string{"X-Role": "admin"},
+		},
+		{
+			name:   "required property missing",
+			claims: `role {http.request.header.X-Role}`,
+			err:    "/: missing properties: 'role'",
+		},
+		{
+			name:   "wrong type",
+			claims: "role user\nlevel 3",
+			err:    "/level: expected integer, but got string",
+		},
+		{
+			name:    "additional property",
+			claims:  "role user\ndebug {http.request.header.X-Debug}",
+			headers: map[string]string{"X-Debug": "1"},
+			err:     "additionalProperties 'debug' not allowed",
+		},
+	}
+
+	schema := writeClaimsSchema(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				sub u
+				`+tt.claims+`
+				options {
+					claims_schema file `+schema+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			err = s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				parseTestToken(t, repl)
+				return
+			}
+
+			var he caddyhttp.HandlerError
+			var schemaErr *ClaimsSchemaError
+			if !errors.As(err, &he) || he.StatusCode != http.StatusBadRequest || !errors.As(err, &schemaErr) {
+				t.Fatalf("error %v, want a 400 schema error", err)
+			}
+
+			if !strings.Contains(strings.Join(schemaErr.Violations, "\n"), tt.err) {
+				t.Fatalf("violations %q, want one containing %q", schemaErr.Violations, tt.err)
+			}
+		})
+	}
+}
+
+func TestOnSchemaError(t *testing.T) {
+	schema := writeClaimsSchema(t)
+
+	for _, mode := range []string{"skip", "warn"} {
+		t.Run(mode, func(t *testing.T) {
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				sub u
+				options {
+					claims_schema file `+schema+`
+					on_schema_error `+mode+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+			if signed := tosStr != ""; signed != (mode == "warn") {
+				t.Fatalf("token %q in mode %s", tosStr, mode)
+			}
+		})
+	}
+}
+
+func TestGenerateClaimsSchema(t *testing.T) {
+	s := &JwtSigner{Claims: jwt.MapClaims{
+		"sub":   "svc",
+		"admin": false,
+		"level": 3.0,
+		"user":  "{http.request.header.X-User}",
+		"org": map[string]any{
+			"id":   "acme",
+			"team": "{http.request.header.X-Team}",
+		},
+	}}
+
+	raw, err := s.GenerateClaimsSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := compileClaimsSchema("", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    string
+	}{
+		{
+			name: "all claims",
+			claims: jwt.MapClaims{
+				"sub": "svc", "admin": false, "level": 3, "user": "alice",
+				"org": map[string]any{"id": "acme", "team": "ops"},
+			},
+		},
+		{
+			name:   "placeholder claims left out",
+			claims: jwt.MapClaims{"sub": "svc", "admin": true, "level": 1, "org": map[string]any{"id": "acme"}},
+		},
+		{
+			name:   "static claim missing",
+			claims: jwt.MapClaims{"admin": false, "level": 3, "org": map[string]any{"id": "acme"}},
+			err:    "missing properties: 'sub'",
+		},
+		{
+			name:   "nested static claim missing",
+			claims: jwt.MapClaims{"sub": "svc", "admin": false, "level": 3, "org": map[string]any{"team": "ops"}},
+			err:    "/org: missing properties: 'id'",
+		},
+		{
+			name:   "wrong type",
+			claims: jwt.MapClaims{"sub": "svc", "admin": "no", "level": 3, "org": map[string]any{"id": "acme"}},
+			err:    "/admin: expected boolean, but got string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := jwt.MapClaims{"iat": 1700000000, "exp": 1700000300}
+			for k, v := range tt.claims {
+				cs[k] = v
+			}
+
+			err := validateClaimsSchema(schema, cs)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("expiry required", func(t *testing.T) {
+		cs := jwt.MapClaims{"sub": "svc", "admin": false, "level": 3, "org": map[string]any{"id": "acme"}}
+
+		err := validateClaimsSchema(schema, cs)
+		if err == nil || !strings.Contains(err.Error(), "'exp', 'iat'") {
+			t.Fatalf("error %v, want iat and exp missing", err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package jwt_signer
+
+import (
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// scopedKey swaps in the secret scoped to the token's audience, so that only the intended recipient can verify
+// the token. Audiences without a scoped secret use the default key, and a token whose audiences would need
+// different secrets cannot be signed at all.
+func (s *JwtSigner) scopedKey(key *signingKey, repl *caddy.Replacer, aud any) (*signingKey, error) {
+	if len(s.ScopedSecrets) == 0 {
+		return key, nil
+	}
+
+	var auds []string
+
+	switch v := aud.(type) {
+	case string:
+		auds = []string{v}
+	case []string:
+		auds = v
+	case []any:
+		for _, a := range v {
+			if str, ok := a.(string); ok {
+				auds = append(auds, str)
+			}
+		}
+	}
+
+	var (
+		scope  string
+		secret string
+		scoped bool
+	)
+
+	for i, a := range auds {
+		sec, ok := s.ScopedSecrets[a]
+		if i > 0 && (ok != scoped || sec != secret) {
+			return nil, fmt.Errorf("audiences %s and %s are scoped to different secrets", scope, a)
+		}
+
+		scope, secret, scoped = a, sec, ok
+	}
+
+	if !scoped {
+		return key, nil
+	}
+
+	if secret = repl.ReplaceAll(secret, ""); secret == "" {
+		return nil, fmt.Errorf("required parameter empty after replacements: scoped secret for %s", scope)
+	}
+
+	return hmacKey(key.method, secret), nil
+}
+
+func (s *JwtSigner) parseScopedSecretsCaddyfile(d *caddyfile.Dispenser) error {
+	if s.ScopedSecrets == nil {
+		s.ScopedSecrets = map[string]string{}
+	}
+
+	var scope, secret string
+	if d.Args(&scope, &secret) {
+		s.ScopedSecrets[scope] = secret
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		scope = d.Val()
+		if !d.Args(&secret) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.ScopedSecrets[scope] = secret
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestScopedSecrets(t *testing.T) {
+	secrets := map[string]string{
+		"default": testSecret,
+		"billing": strings.Repeat("b", 32),
+		"search":  strings.Repeat("s", 32),
+	}
+
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		aud {http.request.header.X-Aud}
+		options {
+			scoped_secrets {
+				billing `+secrets["billing"]+`
+				search `+secrets["search"]+`
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		aud, verifiesWith string
+	}{
+		{aud: "billing", verifiesWith: "billing"},
+		{aud: "search", verifiesWith: "search"},
+		{aud: "mail", verifiesWith: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.aud, func(t *testing.T) {
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Aud", tt.aud)
+
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+
+			for scope, secret := range secrets {
+				_, err := jwt.Parse(tosStr, func(*jwt.Token) (any, error) { return []byte(secret), nil },
+					jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience(tt.aud))
+
+				if valid := scope == tt.verifiesWith; (err == nil) != valid {
+					t.Errorf("verifying with the %s secret: %v", scope, err)
+				}
+			}
+		})
+	}
+}
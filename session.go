@@ -0,0 +1,258 @@
+package jwt_signer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	onInvalidAnonymous = "anonymous"
+	onInvalidRedirect  = "redirect"
+
+	defaultAuthTimeClaim = "auth_time"
+)
+
+// deadlineKey carries an absolute end for the issued token, such as the end of a session, exp never lies after it.
+type deadlineKey struct{}
+
+// SessionConfig keeps a sliding browser session in the token cookie: a valid cookie is passed through, and
+// re-issued with a pushed out exp once it gets within the cookie's refresh_before of expiry.
+type SessionConfig struct {
+	VerifyConfig
+
+	// MaxLifetime caps the session counted from the auth time claim, which is carried over on every re-issue.
+	MaxLifetime   caddy.Duration `json:"max_lifetime,omitempty"`
+	AuthTimeClaim string         `json:"auth_time_claim,omitempty"`
+
+	// OnInvalid is anonymous to pass requests without a valid session on, or redirect to send them to LoginURL.
+	OnInvalid string `json:"on_invalid,omitempty"`
+	LoginURL  string `json:"login_url,omitempty"`
+}
+
+func (sc *SessionConfig) provision(cookie *CookieConfig) {
+	if sc.From == "" && cookie != nil {
+		sc.From, sc.Name = tokenFromCookie, cookie.Name
+	}
+
+	sc.VerifyConfig.provision()
+
+	if sc.AuthTimeClaim == "" {
+		sc.AuthTimeClaim = defaultAuthTimeClaim
+	}
+
+	if sc.OnInvalid == "" {
+		sc.OnInvalid = onInvalidAnonymous
+	}
+}
+
+func (sc *SessionConfig) validate(cookie *CookieConfig) error {
+	if cookie == nil || cookie.RefreshBefore <= 0 {
+		return fmt.Errorf("session requires a cookie with a positive refresh_before")
+	}
+
+	if sc.From != tokenFromCookie || sc.Name != cookie.Name {
+		return fmt.Errorf("session reads its token from the %s cookie and cannot use from", cookie.Name)
+	}
+
+	if err := sc.VerifyConfig.validate(); err != nil {
+		return err
+	}
+
+	if sc.JWKS != nil || len(sc.Scopes) > 0 || sc.SingleUse {
+		return fmt.Errorf("session verifies the module's own tokens and cannot use jwks, require_scope or single_use")
+	}
+
+	if sc.MaxLifetime < 0 {
+		return fmt.Errorf("session max_lifetime must not be negative")
+	}
+
+	if sc.MaxLifetime != 0 && sc.MaxLifetime <= cookie.RefreshBefore {
+		return fmt.Errorf("session max_lifetime must exceed the cookie refresh_before")
+	}
+
+	switch sc.OnInvalid {
+	case onInvalidAnonymous:
+		if sc.LoginURL != "" {
+			return fmt.Errorf("session login_url requires on_invalid redirect")
+		}
+	case onInvalidRedirect:
+		if sc.LoginURL == "" {
+			return fmt.Errorf("session on_invalid redirect requires a login URL")
+		}
+	default:
+		return fmt.Errorf("unknown session on_invalid mode: %s", sc.OnInvalid)
+	}
+
+	return nil
+}
+
+// authTime reads the time the session was established, a token issued outside of the session mode has none yet
+// and counts from its iat.
+func (sc *SessionConfig) authTime(cs jwt.MapClaims) (time.Time, error) {
+	if _, ok := cs[sc.AuthTimeClaim]; !ok {
+		iat, err := cs.GetIssuedAt()
+		if err != nil || iat == nil {
+			return time.Time{}, fmt.Errorf("session token has neither %s nor iat", sc.AuthTimeClaim)
+		}
+
+		return iat.Time, nil
+	}
+
+	// parsed the way iat is, so the claim takes the same NumericDate forms
+	at, err := jwt.MapClaims{"iat": cs[sc.AuthTimeClaim]}.GetIssuedAt()
+	if err != nil || at == nil {
+		return time.Time{}, fmt.Errorf("session token has an invalid %s", sc.AuthTimeClaim)
+	}
+
+	return at.Time, nil
+}
+
+func (s *JwtSigner) serveSession(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	var (
+		presented jwt.MapClaims
+		authTime  time.Time
+		err       = fmt.Errorf("no session cookie presented")
+	)
+
+	tosStr := s.Session.token(r)
+	if tosStr != "" {
+		presented, err = s.parse(tosStr, repl, append(s.Session.parserOptions(), jwt.WithJSONNumber())...)
+		if err == nil {
+			err = s.Session.expect(tosStr, presented)
+		}
+		if err == nil {
+			err = s.checkRevoked(presented)
+		}
+		if err == nil {
+			authTime, err = s.Session.authTime(presented)
+		}
+	}
+
+	var deadline time.Time
+	if err == nil && s.Session.MaxLifetime != 0 {
+		deadline = authTime.Add(time.Duration(s.Session.MaxLifetime))
+		if !time.Now().Before(deadline) {
+			err = fmt.Errorf("session exceeded its max_lifetime of %s", time.Duration(s.Session.MaxLifetime))
+		}
+	}
+
+	if err != nil {
+		return s.noSession(w, r, repl, next, tosStr != "", err)
+	}
+
+	exp, err := presented.GetExpirationTime()
+	if err != nil {
+		return s.noSession(w, r, repl, next, true, err)
+	}
+
+	remaining := time.Until(exp.Time)
+	capped := !deadline.IsZero() && !exp.Time.Before(deadline)
+
+	if remaining > time.Duration(s.Cookie.RefreshBefore) || capped {
+		s.l.Debug("Session is still fresh, skip signing", zap.Duration("remaining", remaining), zap.Bool("capped", capped))
+
+		repl.Set("http.jwt_signer.digest_str", tosStr)
+		setIssuedClaimPlaceholders(repl, "", presented)
+		s.exportVars(r, presented)
+
+		return next.ServeHTTP(w, withClaims(r, presented))
+	}
+
+	keep := make(jwt.MapClaims, len(presented))
+	for k, v := range presented {
+		keep[k] = v
+	}
+
+	for _, k := range reissueStripClaims {
+		delete(keep, k)
+	}
+
+	keep[s.Session.AuthTimeClaim] = authTime.Unix()
+
+	if !deadline.IsZero() {
+		r = r.WithContext(context.WithValue(r.Context(), deadlineKey{}, deadline))
+	}
+
+	s.l.Debug("Session is due for refresh", zap.Time("auth_time", authTime), zap.Time("deadline", deadline))
+
+	return s.issue(w, r, repl, next, keep)
+}
+
+// noSession handles a request without a valid session, a rejected cookie is cleared so the browser stops
+// presenting it.
+func (s *JwtSigner) noSession(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler, presented bool, err error) error {
+	s.l.Debug("No valid session", zap.Error(err))
+
+	if presented {
+		cookie := s.Cookie.build("", nil)
+		cookie.MaxAge = -1
+
+		http.SetCookie(w, cookie)
+	}
+
+	if s.Session.OnInvalid == onInvalidAnonymous {
+		return next.ServeHTTP(w, r)
+	}
+
+	s.noStore(w)
+
+	w.Header().Set("Location", repl.ReplaceAll(s.Session.LoginURL, ""))
+	w.WriteHeader(http.StatusFound)
+
+	return nil
+}
+
+func (sc *SessionConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "max_lifetime":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid session max_lifetime %s: %v", durStr, err)
+			}
+
+			sc.MaxLifetime = caddy.Duration(dur)
+		case "auth_time_claim":
+			if !d.Args(&sc.AuthTimeClaim) {
+				return d.ArgErr()
+			}
+		case "on_invalid":
+			if !d.Args(&sc.OnInvalid) {
+				return d.ArgErr()
+			}
+
+			if sc.OnInvalid == onInvalidRedirect && !d.Args(&sc.LoginURL) {
+				return d.ArgErr()
+			}
+		default:
+			if err := sc.VerifyConfig.unmarshalOption(d); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package jwt_signer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+const sharedSecretPrefix = "jwt_signer/secrets/"
+
+// loadSharedSecret reads the secret from Caddy's storage, shared by every node of a cluster. The first node to
+// find none generates and stores it under the storage lock, so all nodes converge on the same secret.
+func loadSharedSecret(ctx context.Context, storage certmagic.Storage, name string, l *zap.Logger) (string, error) {
+	key := sharedSecretPrefix + name
+
+	secret, err := storage.Load(ctx, key)
+	if err == nil {
+		return string(secret), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if err := storage.Lock(ctx, key+".lock"); err != nil {
+		return "", fmt.Errorf("locking %s: %w", key, err)
+	}
+	defer storage.Unlock(context.WithoutCancel(ctx), key+".lock")
+
+	// another node may have stored it while we waited for the lock
+	secret, err = storage.Load(ctx, key)
+	if err == nil {
+		return string(secret), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	generated, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	if err := storage.Store(ctx, key, []byte(generated)); err != nil {
+		return "", fmt.Errorf("storing shared secret: %w", err)
+	}
+
+	l.Info("Generated shared secret", zap.String("key", key))
+
+	return generated, nil
+}
+
+func (s *JwtSigner) provisionSharedSecret(ctx caddy.Context) error {
+	if s.SharedSecretStorageKey == "" {
+		return nil
+	}
+
+	if s.Secret != "" || s.AutoGenerateSecret {
+		return fmt.Errorf("shared_secret_storage_key cannot be combined with secret or auto_generate_secret")
+	}
+
+	secret, err := loadSharedSecret(ctx, ctx.Storage(), s.SharedSecretStorageKey, s.l)
+	if err != nil {
+		return fmt.Errorf("loading shared secret %s: %w", s.SharedSecretStorageKey, err)
+	}
+
+	s.Secret = secret
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package jwt_signer
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// missingStorage holds back the first n loads, all missing the secret, until each of the n nodes made one, so
+// every node goes on to the lock instead of one storing the secret before the others looked.
+type missingStorage struct {
+	*memStorage
+
+	mu      sync.Mutex
+	pending int
+	ready   chan struct{}
+}
+
+func (ms *missingStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	ms.mu.Lock()
+	first := ms.pending > 0
+	if first {
+		ms.pending--
+		if ms.pending == 0 {
+			close(ms.ready)
+		}
+	}
+	ms.mu.Unlock()
+
+	if first {
+		<-ms.ready
+	}
+
+	return ms.memStorage.Load(ctx, key)
+}
+
+func TestLoadSharedSecretConcurrent(t *testing.T) {
+	const n = 8
+
+	storage := &missingStorage{memStorage: newMemStorage(), pending: n, ready: make(chan struct{})}
+
+	secrets := make([]string, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			secrets[i], errs[i] = loadSharedSecret(context.Background(), storage, "cluster", zap.NewNop())
+		}()
+	}
+
+	wg.Wait()
+
+	for i := range n {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if secrets[i] != secrets[0] {
+			t.Fatalf("node %d loaded %q, node 0 %q", i, secrets[i], secrets[0])
+		}
+	}
+
+	stored, err := storage.memStorage.Load(context.Background(), sharedSecretPrefix+"cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != secrets[0] {
+		t.Fatalf("stored %q, nodes loaded %q", stored, secrets[0])
+	}
+}
+
+func TestSharedSecretProvision(t *testing.T) {
+	ctx := storageContext(t)
+
+	const cfg = `jwt_signer 5m {
+		sub alice
+		options {
+			shared_secret_storage_key cluster
+		}
+	}`
+
+	signers := make([]*JwtSigner, 2)
+	errs := make([]error, 2)
+
+	var wg sync.WaitGroup
+	for i := range signers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			signers[i], errs[i] = provisionSignerIn(t, ctx, cfg)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if signers[0].Secret == "" || signers[0].Secret != signers[1].Secret {
+		t.Fatalf("secrets %q and %q, want the same one", signers[0].Secret, signers[1].Secret)
+	}
+
+	other, err := provisionSignerIn(t, storageContext(t), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Secret == signers[0].Secret {
+		t.Fatal("a node with other storage loaded the same secret")
+	}
+}
@@ -0,0 +1,134 @@
+package jwt_signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ecKeySet holds a P-256 key under kid k1, with its private part when withPrivate is set.
+func ecKeySet(t *testing.T, priv *ecdsa.PrivateKey, withPrivate bool) []byte {
+	t.Helper()
+
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	key := map[string]string{
+		"kty": "EC",
+		"kid": "k1",
+		"crv": "P-256",
+		"x":   enc(priv.X.FillBytes(make([]byte, 32))),
+		"y":   enc(priv.Y.FillBytes(make([]byte, 32))),
+	}
+	if withPrivate {
+		key["d"] = enc(priv.D.FillBytes(make([]byte, 32)))
+	}
+
+	set, err := json.Marshal(map[string]any{"keys": []map[string]string{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return set
+}
+
+func TestSignBodyVerifiesAgainstJWKS(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := ecKeySet(t, priv, true)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(private)
+	}))
+	defer srv.Close()
+
+	s, err := provisionSigner(t, `jwt_signer 5m {
+		options {
+			signing_jwks `+srv.URL+` k1 {
+				insecure
+			}
+			sign_body
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const body = `{"event":"paid","id":7}`
+
+	r, _ := newTestRequest(http.MethodPost, "/", nil)
+	w := httptest.NewRecorder()
+
+	err = s.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := w.Write([]byte(body))
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Body.String() != body {
+		t.Fatalf("body %q, want %q", w.Body.String(), body)
+	}
+
+	protected, sig, ok := strings.Cut(w.Header().Get("X-Body-Signature"), "..")
+	if !ok {
+		t.Fatalf("signature %q is not a detached JWS", w.Header().Get("X-Body-Signature"))
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(protected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header map[string]any
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		t.Fatal(err)
+	}
+
+	if header["alg"] != "ES256" || header["kid"] != "k1" || header["b64"] != false {
+		t.Fatalf("header %v, want ES256 with kid k1 and b64 false", header)
+	}
+
+	// a verifier only knows the published key set and picks the key by the kid of the header
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(ecKeySet(t, priv, false), &set); err != nil {
+		t.Fatal(err)
+	}
+
+	var vk *verificationKey
+	for _, k := range set.Keys {
+		if k.Kid == header["kid"] {
+			if vk, err = k.verificationKey(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if vk == nil {
+		t.Fatalf("no key %v in the key set", header["kid"])
+	}
+
+	rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signed := range []string{body, body + " "} {
+		err := jwt.SigningMethodES256.Verify(protected+"."+signed, rawSig, vk.key)
+		if valid := signed == body; (err == nil) != valid {
+			t.Fatalf("verifying %q: %v", signed, err)
+		}
+	}
+}
@@ -1,15 +1,30 @@
 package jwt_signer
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"go.uber.org/zap"
 )
 
@@ -19,190 +34,3477 @@ func init() {
 	httpcaddyfile.RegisterDirectiveOrder("jwt_signer", httpcaddyfile.Before, "redir")
 }
 
+const (
+	payloadEncodingBase64 = "base64url"
+	payloadEncodingJSON   = "json"
+
+	defaultSignResponseMaxSize = 1 << 20
+
+	durationSourceConfig = "config"
+	durationSourceCookie = "cookie:"
+
+	nestedClaimsPartial      = "partial"
+	nestedClaimsAllOrNothing = "all_or_nothing"
+
+	timestampUnix        = "unix"
+	timestampRFC3339     = "rfc3339"
+	timestampRFC3339Nano = "rfc3339nano"
+
+	concurrencyParallel = "parallel"
+	concurrencySerial   = "serial"
+)
+
 type JwtSigner struct {
+	Name   string `json:"name,omitempty"`
 	Dur    string `json:"duration"`
 	Secret string `json:"secret"`
-	Claims jwt.MapClaims
-	l      *zap.Logger
+
+	KeyFile string `json:"key_file,omitempty"`
+	Claims  jwt.MapClaims
+
+	// CertFile holds the certificate of the key_file key, leaf first, whose thumbprint is set as x5t#S256 in the
+	// token header. IncludeX5C also sets the certificates in the file as x5c.
+	CertFile   string `json:"cert_file,omitempty"`
+	IncludeX5C bool   `json:"include_x5c,omitempty"`
+
+	// SigningJWKS fetches the signing key by kid from a key set holding symmetric or private keys.
+	SigningJWKS *SigningJWKSConfig `json:"signing_jwks,omitempty"`
+
+	// StrictCurve fails the configuration on undersized keys instead of only warning about them.
+	StrictCurve bool `json:"strict_curve,omitempty"`
+
+	// DenyWeakSecrets fails the configuration when a secret is a well-known placeholder such as "changeme".
+	DenyWeakSecrets bool `json:"deny_weak_secrets,omitempty"`
+
+	// AutoGenerateSecret signs with a random secret generated on every provision when no secret is configured.
+	// Tokens do not survive a reload, so this is meant for development only.
+	AutoGenerateSecret bool `json:"auto_generate_secret,omitempty"`
+
+	// SharedSecretStorageKey names a secret kept in Caddy's storage, generated by the first node of a cluster to
+	// need it, so every node signs with the same secret.
+	SharedSecretStorageKey string `json:"shared_secret_storage_key,omitempty"`
+
+	// Concurrency is serial to sign one token at a time, for keys such as PKCS #11 HSM signers which are not safe
+	// for concurrent use.
+	Concurrency string `json:"concurrency,omitempty"`
+
+	ClaimsFile string `json:"claims_file,omitempty"`
+
+	// UseClaimGroups merges the named claim groups below the configured claims, ClaimGroups holds their definitions.
+	UseClaimGroups []string               `json:"use_claim_groups,omitempty"`
+	ClaimGroups    map[string]*ClaimGroup `json:"claim_groups,omitempty"`
+
+	EnvClaims map[string]string `json:"env_claims,omitempty"`
+
+	// HashClaims are set to the digest of their value, HMAC digests keyed with HashClaimKey. HashClaimEncoding is
+	// hex (default) or base64url.
+	HashClaims        map[string]*HashClaim `json:"hash_claims,omitempty"`
+	HashClaimKey      string                `json:"hash_claim_key,omitempty"`
+	HashClaimEncoding string                `json:"hash_claim_encoding,omitempty"`
+
+	LabelClaim string `json:"label_claim,omitempty"`
+	Label      string `json:"label,omitempty"`
+
+	SignResponse         bool   `json:"sign_response,omitempty"`
+	SignResponseEncoding string `json:"sign_response_encoding,omitempty"`
+	SignResponseMaxSize  int64  `json:"sign_response_max_size,omitempty"`
+
+	SignBody *SignBodyConfig `json:"sign_body,omitempty"`
+
+	ExportVars []string `json:"export_vars,omitempty"`
+
+	// SkipIfEmpty lists placeholders which must all be non-empty for a token to be issued, otherwise the request is
+	// passed on untouched, e.g. {http.auth.user.id} to issue tokens to authenticated users only.
+	SkipIfEmpty []string `json:"skip_if_empty,omitempty"`
+
+	InjectHeader string  `json:"inject_header,omitempty"`
+	HeaderPrefix *string `json:"header_prefix,omitempty"`
+
+	Verify   *VerifyConfig   `json:"verify,omitempty"`
+	Exchange *ExchangeConfig `json:"exchange,omitempty"`
+	Resign   *ResignConfig   `json:"resign,omitempty"`
+	Refresh  *RefreshConfig  `json:"refresh,omitempty"`
+	Rotation *RotationConfig `json:"rotation,omitempty"`
+	Session  *SessionConfig  `json:"session,omitempty"`
+
+	// CSRF issues session-bound double-submit tokens on safe requests and requires them on unsafe ones.
+	CSRF *CSRFConfig `json:"csrf,omitempty"`
+
+	// AuthEndpoint answers verified requests itself instead of passing them on, for forward_auth.
+	AuthEndpoint *AuthEndpointConfig `json:"auth_endpoint,omitempty"`
+
+	ClientCredentials *ClientCredentialsConfig `json:"client_credentials,omitempty"`
+
+	Vault *VaultConfig `json:"vault,omitempty"`
+
+	// GenerateNonce adds a random nonce claim to every token, checked by replay_protection on the verifying side.
+	GenerateNonce *NonceConfig `json:"generate_nonce,omitempty"`
+
+	// IdempotentJTI gives every token a jti derived from the client's idempotency key, so retries keep their jti.
+	IdempotentJTI *IdempotentJTIConfig `json:"idempotent_jti,omitempty"`
+
+	// DPoP binds issued tokens to the key of the DPoP proof presented with the token request.
+	DPoP *DPoPConfig `json:"dpop,omitempty"`
+
+	// Preset shapes tokens for a well-known purpose, assertion makes RFC 7523 client assertions.
+	Preset string `json:"preset,omitempty"`
+
+	Revocation *RevocationConfig `json:"revocation,omitempty"`
+
+	Cookie      *CookieConfig      `json:"cookie,omitempty"`
+	Redirect    *RedirectConfig    `json:"redirect,omitempty"`
+	RespondJSON *RespondJSONConfig `json:"respond_json,omitempty"`
+
+	// AllowCaching drops the Cache-Control: no-store and Pragma: no-cache headers set on responses carrying the
+	// token itself, i.e. respond_json, redirect and sign_response.
+	AllowCaching bool `json:"allow_caching,omitempty"`
+
+	Challenge *ChallengeConfig `json:"challenge,omitempty"`
+
+	// UseHTTP2Push is experimental: it pushes HTTP2PushTarget, a cookie-setting endpoint, carrying the issued token
+	// to HTTP/2 clients ahead of the response.
+	UseHTTP2Push    bool   `json:"use_http2_push,omitempty"`
+	HTTP2PushTarget string `json:"http2_push_target,omitempty"`
+
+	DurationUnit string         `json:"duration_unit,omitempty"`
+	MinDuration  caddy.Duration `json:"min_duration,omitempty"`
+	MaxDuration  caddy.Duration `json:"max_duration,omitempty"`
+
+	DurationSource string `json:"duration_source,omitempty"`
+
+	TokenCacheTTL caddy.Duration `json:"token_cache_ttl,omitempty"`
+
+	// TokenCacheReuse is the fraction of a cached token's lifetime after which it is no longer handed out, e.g. 0.8.
+	TokenCacheReuse float64 `json:"token_cache_reuse,omitempty"`
+	TokenCacheSize  int     `json:"token_cache_size,omitempty"`
+
+	// SigningTimeout bounds the handler's own work per request, such as requesting tokens from Vault, time spent in
+	// the following handlers excluded. It defaults to 5s for handlers waiting on a backend and is off otherwise.
+	SigningTimeout caddy.Duration `json:"signing_timeout,omitempty"`
+
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// AlgorithmAllow lists the algorithms a placeholder algorithm may resolve to, anything else fails the request.
+	// HMAC algorithms sign with the secret, any other needs its key in AlgorithmKeys, mapping it to a key file.
+	AlgorithmAllow []string          `json:"algorithm_allow,omitempty"`
+	AlgorithmKeys  map[string]string `json:"algorithm_keys,omitempty"`
+
+	Tenant     string                `json:"tenant,omitempty"`
+	TenantKeys map[string]*KeyConfig `json:"tenant_keys,omitempty"`
+
+	ScopedSecrets map[string]string `json:"scoped_secrets,omitempty"`
+
+	// PreviousSecrets keep verifying tokens signed before a secret rotation, signing only uses secret.
+	PreviousSecrets []PreviousSecret `json:"previous_secrets,omitempty"`
+
+	ClaimAliases map[string]string `json:"claim_aliases,omitempty"`
+
+	TrimClaims bool `json:"trim_claims,omitempty"`
+
+	// NestedClaims is partial (default) to keep the non-empty keys of a nested block, or all_or_nothing to
+	// omit the whole block as soon as one of its values resolves empty.
+	NestedClaims string `json:"nested_claims,omitempty"`
+
+	// StrictPlaceholders fails the request when a claim resolves empty instead of leaving the claim out.
+	StrictPlaceholders bool `json:"strict_placeholders,omitempty"`
+
+	IncludeQuery  *IncludeQueryConfig `json:"include_query,omitempty"`
+	RequestClaims []string            `json:"request_claims,omitempty"`
+
+	// RequestPathClaim names a claim holding the request path, without PathPrefixStrip when it starts with it.
+	RequestPathClaim string `json:"request_path_claim,omitempty"`
+	PathPrefixStrip  string `json:"path_prefix_strip,omitempty"`
+
+	SubjectFromCert *CertSubjectConfig `json:"subject_from_cert,omitempty"`
+
+	// PKCE stores the code challenge of an authorization request in the token.
+	PKCE *PKCEConfig `json:"pkce_claim,omitempty"`
+
+	// AtHashFrom and CHashFrom hold the access token and authorization code an OIDC ID token is issued with, for
+	// the at_hash and c_hash claims.
+	AtHashFrom string `json:"at_hash_from,omitempty"`
+	CHashFrom  string `json:"c_hash_from,omitempty"`
+
+	ClaimsFromBody        *BodyClaimsConfig `json:"claims_from_body,omitempty"`
+	RequestBodyLimitBytes int64             `json:"request_body_limit_bytes,omitempty"`
+	BodyContentType       string            `json:"body_content_type,omitempty"`
+
+	OnMissingClaimSource string            `json:"on_missing_claim_source,omitempty"`
+	ClaimDefaults        map[string]string `json:"claim_defaults,omitempty"`
+
+	OutputSerialization string `json:"output_serialization,omitempty"`
+	TimestampFormat     string `json:"timestamp_format,omitempty"`
+
+	// NumericDates coerces iat, exp, nbf and auth_time to integer seconds before signing, whatever source set them.
+	NumericDates bool `json:"numeric_dates,omitempty"`
+
+	// CompressClaims gzips all claims but iat, exp, sub and iss into a single payload claim, for very large claim
+	// sets. Consumers have to know to expand it.
+	CompressClaims bool `json:"compress_claims,omitempty"`
+
+	JWEAlgorithm        string `json:"jwe_algorithm,omitempty"`
+	JWEEnc              string `json:"jwe_enc,omitempty"`
+	JWERecipientKeyFile string `json:"jwe_recipient_key_file,omitempty"`
+
+	Audience []string `json:"audience,omitempty"`
+	FanOut   bool     `json:"fan_out,omitempty"`
+
+	// AudienceAlwaysArray serializes even a single audience as an array, for verifiers which insist on one.
+	AudienceAlwaysArray bool `json:"audience_always_array,omitempty"`
+
+	ClaimsSchemaFile string          `json:"claims_schema_file,omitempty"`
+	ClaimsSchema     json.RawMessage `json:"claims_schema,omitempty"`
+	OnSchemaError    string          `json:"on_schema_error,omitempty"`
+
+	durationUnit   time.Duration
+	durationCookie string
+	durStatic      bool
+	staticDur      time.Duration
+	moduleKey      *signingKey
+	pooledKeys     []string
+	secretKey      *signingKey
+	envClaims      jwt.MapClaims
+	consumed       *consumedTokens
+	tenantKeys     map[string]*signingKey
+	algorithmKeys  map[string]*signingKey
+	claimsSchema   *jsonschema.Schema
+	tokenCache     *tokenCache
+	staticClaims   jwt.MapClaims
+	dynamicClaims  jwt.MapClaims
+	signMu         sync.Mutex
+	jwe            *jweEncrypter
+
+	weakKeys []string
+
+	l *zap.Logger
+}
+
+// checkKeyStrength warns about an undersized key, Validate refuses it later with strict_curve.
+func (s *JwtSigner) checkKeyStrength(name string, method jwt.SigningMethod, key any) {
+	if reason := weakKeyReason(method, key); reason != "" {
+		s.l.Warn("Weak key configured", zap.String("key", name), zap.String("reason", reason))
+		s.weakKeys = append(s.weakKeys, name+": "+reason)
+	}
+}
+
+// checkWeakSecrets refuses HMAC secrets found in weakSecrets. Secrets are checked after replacing environment
+// placeholders, secrets depending on the request cannot be checked.
+func (s *JwtSigner) checkWeakSecrets() error {
+	secrets := map[string]string{"secret": s.Secret}
+
+	for tenant, kc := range s.TenantKeys {
+		if kc != nil {
+			secrets["tenant "+tenant] = kc.Secret
+		}
+	}
+
+	for scope, secret := range s.ScopedSecrets {
+		secrets["scoped secret for "+scope] = secret
+	}
+
+	for i, ps := range s.PreviousSecrets {
+		secrets["previous secret "+strconv.Itoa(i)] = ps.Secret
+	}
+
+	if s.Exchange != nil && s.Exchange.Key != nil {
+		secrets["exchange key"] = s.Exchange.Key.Secret
+	}
+
+	repl := caddy.NewReplacer()
+
+	for name, secret := range secrets {
+		if isWeakSecret(repl.ReplaceKnown(secret, "")) {
+			return fmt.Errorf("%s is a well-known placeholder secret, refused by deny_weak_secrets", name)
+		}
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) Provision(ctx caddy.Context) error {
+	s.l = ctx.Logger()
+
+	if s.SigningTimeout == 0 && s.waitsOnBackend() {
+		s.SigningTimeout = caddy.Duration(defaultSigningTimeout)
+	}
+
+	s.durationUnit = time.Second
+	if s.DurationUnit != "" {
+		unit, err := time.ParseDuration("1" + s.DurationUnit)
+		if err != nil || unit <= 0 {
+			return fmt.Errorf("invalid duration unit: %s", s.DurationUnit)
+		}
+
+		s.durationUnit = unit
+	}
+
+	if s.Dur != "" && !strings.Contains(s.Dur, "{") {
+		dur, err := parseTokenDuration(s.Dur, s.durationUnit)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %s", s.Dur)
+		}
+
+		s.durStatic, s.staticDur = true, dur
+	}
+
+	if s.ClaimsFile != "" {
+		fileClaims, err := loadClaimsFile(caddy.NewReplacer().ReplaceKnown(s.ClaimsFile, ""))
+		if err != nil {
+			return err
+		}
+
+		for k, v := range s.Claims {
+			fileClaims[k] = v
+		}
+
+		s.Claims = fileClaims
+	}
+
+	if len(s.UseClaimGroups) > 0 {
+		if err := s.resolveClaimGroups(); err != nil {
+			return err
+		}
+	}
+
+	if s.MinDuration == 0 {
+		s.MinDuration = caddy.Duration(time.Second)
+	}
+
+	s.provisionPreset()
+
+	if s.LabelClaim != "" && s.Label == "" {
+		s.Label = s.Name
+	}
+
+	if len(s.HashClaims) > 0 && s.HashClaimEncoding == "" {
+		s.HashClaimEncoding = hashEncodingHex
+	}
+
+	s.envClaims = jwt.MapClaims{}
+	for claim, name := range s.EnvClaims {
+		val := os.Getenv(name)
+		if s.TrimClaims {
+			val = strings.TrimSpace(val)
+		}
+
+		if val != "" {
+			s.envClaims[claim] = val
+		}
+	}
+
+	if err := s.provisionSharedSecret(ctx); err != nil {
+		return err
+	}
+
+	if s.AutoGenerateSecret && s.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return fmt.Errorf("generating secret: %w", err)
+		}
+
+		s.Secret = secret
+		s.l.Warn("Signing with an ephemeral generated secret, tokens will not survive a reload; do not use in production",
+			zap.String("secret", secret))
+	}
+
+	if s.KeyFile != "" {
+		key, err := s.loadModuleKey()
+		if err != nil {
+			return err
+		}
+
+		s.moduleKey = key
+		s.checkKeyStrength("key_file", key.method, key.sign)
+	}
+
+	if s.SigningJWKS != nil {
+		if err := s.SigningJWKS.provision(ctx, s.l); err != nil {
+			return err
+		}
+	}
+
+	if err := s.provisionAlgorithmKeys(); err != nil {
+		return err
+	}
+
+	s.durationCookie, _ = strings.CutPrefix(s.DurationSource, durationSourceCookie)
+
+	if s.SignResponse {
+		if s.SignResponseEncoding == "" {
+			s.SignResponseEncoding = payloadEncodingBase64
+		}
+		if s.SignResponseMaxSize == 0 {
+			s.SignResponseMaxSize = defaultSignResponseMaxSize
+		}
+	}
+
+	if s.Verify != nil {
+		s.Verify.provision()
+
+		if s.Verify.JWKS != nil {
+			if err := s.Verify.JWKS.provision(ctx, s.l); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.SignBody != nil {
+		s.SignBody.provision()
+	}
+
+	if s.Exchange != nil {
+		if err := s.Exchange.provision(ctx, s.l); err != nil {
+			return err
+		}
+
+		if s.Exchange.key != nil {
+			s.checkKeyStrength("exchange key", nil, s.Exchange.key.key)
+		}
+	}
+
+	if s.Resign != nil {
+		if err := s.Resign.provision(ctx, s.l); err != nil {
+			return err
+		}
+
+		if s.Resign.key != nil {
+			s.checkKeyStrength("resign key", nil, s.Resign.key.key)
+		}
+	}
+
+	if s.Vault != nil {
+		s.Vault.provision()
+	}
+
+	if s.ClientCredentials != nil && s.RespondJSON == nil {
+		s.RespondJSON = &RespondJSONConfig{}
+	}
+
+	if s.RespondJSON != nil {
+		s.RespondJSON.provision()
+	}
+
+	if s.Refresh != nil {
+		s.Refresh.provision()
+	}
+
+	if s.Rotation != nil {
+		s.Rotation.provision(ctx)
+	}
+
+	if s.Session != nil {
+		s.Session.provision(s.Cookie)
+	}
+
+	if s.CSRF != nil {
+		if err := s.CSRF.provision(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.Revocation != nil {
+		if err := s.Revocation.provision(ctx, s.l); err != nil {
+			return err
+		}
+	}
+
+	if s.Verify != nil && s.Verify.SingleUse || s.Exchange != nil && s.Exchange.SingleUse {
+		s.consumed = &consumedTokens{storage: ctx.Storage(), prefix: defaultConsumedPrefix}
+	}
+
+	if s.Verify != nil && s.Verify.ReplayProtection != nil {
+		s.Verify.ReplayProtection.provision(ctx)
+	}
+
+	if s.Exchange != nil && s.Exchange.ReplayProtection != nil {
+		s.Exchange.ReplayProtection.provision(ctx)
+	}
+
+	if s.GenerateNonce != nil {
+		s.GenerateNonce.provision()
+	}
+
+	if s.DPoP != nil {
+		s.DPoP.provision()
+	}
+
+	if s.SubjectFromCert != nil {
+		s.SubjectFromCert.provision()
+	}
+
+	if s.PKCE != nil {
+		s.PKCE.provision()
+	}
+
+	if s.JWEAlgorithm != "" {
+		if s.JWEEnc == "" {
+			s.JWEEnc = jweEncA256GCM
+		}
+
+		enc, err := loadJWEEncrypter(s.JWEAlgorithm, s.JWEEnc, s.JWERecipientKeyFile)
+		if err != nil {
+			return err
+		}
+
+		s.jwe = enc
+	}
+
+	if s.TokenCacheTTL > 0 || s.TokenCacheReuse > 0 {
+		if s.TokenCacheSize == 0 {
+			s.TokenCacheSize = defaultTokenCacheSize
+		}
+
+		s.tokenCache = newTokenCache(time.Duration(s.TokenCacheTTL), s.TokenCacheReuse, s.TokenCacheSize)
+	}
+
+	if (s.ClaimsFromBody != nil || s.ClientCredentials != nil) && s.RequestBodyLimitBytes == 0 {
+		s.RequestBodyLimitBytes = defaultRequestBodyLimit
+	}
+
+	if s.ClaimsFromBody != nil && s.BodyContentType == "" {
+		s.BodyContentType = bodyContentTypeJSON
+	}
+
+	if s.Cookie != nil {
+		s.Cookie.provision()
+	}
+
+	if s.Redirect != nil {
+		s.Redirect.provision()
+	}
+
+	if len(s.TenantKeys) > 0 {
+		s.tenantKeys = make(map[string]*signingKey, len(s.TenantKeys))
+
+		for tenant, kc := range s.TenantKeys {
+			key, err := s.loadKeyTested(kc)
+			if err != nil {
+				return fmt.Errorf("loading key of tenant %s: %w", tenant, err)
+			}
+
+			s.tenantKeys[tenant] = key
+			s.checkKeyStrength("tenant "+tenant, key.method, key.sign)
+		}
+	}
+
+	if s.ClaimsSchemaFile != "" || len(s.ClaimsSchema) > 0 {
+		if s.ClaimsSchemaFile != "" && len(s.ClaimsSchema) > 0 {
+			return fmt.Errorf("claims schema file and inline claims schema are mutually exclusive")
+		}
+
+		schema, err := compileClaimsSchema(s.ClaimsSchemaFile, s.ClaimsSchema)
+		if err != nil {
+			return err
+		}
+
+		s.claimsSchema = schema
+
+		if s.OnSchemaError == "" {
+			s.OnSchemaError = onSchemaErrorError
+		}
+	}
+
+	s.partitionClaims()
+
+	if err := s.provisionSecretKey(); err != nil {
+		return err
+	}
+
+	registerSigner(s)
+
+	s.l.Debug("Provisioned", zap.String("duration", s.Dur), zap.Any("claims", s.Claims))
+
+	return nil
+}
+
+// loadModuleKey loads the key_file key along with the header fields of its cert_file, again only when either file
+// changed since a previous config loaded them.
+func (s *JwtSigner) loadModuleKey() (*signingKey, error) {
+	repl := caddy.NewReplacer()
+	keyPath, certPath := repl.ReplaceKnown(s.KeyFile, ""), repl.ReplaceKnown(s.CertFile, "")
+
+	id, err := fileIdentity(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	if certPath != "" {
+		certID, err := fileIdentity(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading cert file: %w", err)
+		}
+
+		id += fmt.Sprintf("|%s|%t", certID, s.IncludeX5C)
+	}
+
+	return s.pooledKey(id, func() (*signingKey, error) {
+		key, err := loadKeyFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := key.selfTest(); err != nil {
+			return nil, fmt.Errorf("key_file self-test failed: %w", err)
+		}
+
+		if certPath != "" {
+			if key.header, err = signingCertHeader(certPath, key, s.IncludeX5C); err != nil {
+				return nil, err
+			}
+		}
+
+		return key, nil
+	})
 }
 
-func (s *JwtSigner) Provision(ctx caddy.Context) error {
-	s.l = ctx.Logger()
+// provisionSecretKey builds the HMAC key once when neither the secret nor the algorithm depends on the request,
+// and round-trips a token with it. Keys loaded from files are tested as they are loaded.
+func (s *JwtSigner) provisionSecretKey() error {
+	if s.moduleKey != nil || s.Tenant != "" || len(s.AlgorithmAllow) > 0 || s.Secret == "" {
+		return nil
+	}
+
+	if strings.Contains(s.Secret, "{") || strings.Contains(s.Algorithm, "{") {
+		return nil
+	}
+
+	key, err := s.key(caddy.NewReplacer())
+	if err != nil {
+		return err
+	}
+
+	if err := key.selfTest(); err != nil {
+		return fmt.Errorf("secret self-test failed: %w", err)
+	}
+
+	s.checkKeyStrength("secret", key.method, key.sign)
+	s.secretKey = key
+
+	return nil
+}
+
+func (s *JwtSigner) Cleanup() error {
+	unregisterSigner(s)
+
+	s.releasePooledKeys()
+
+	if s.Revocation != nil {
+		s.Revocation.cleanup()
+	}
+
+	if s.Verify != nil && s.Verify.JWKS != nil {
+		s.Verify.JWKS.cleanup()
+	}
+
+	if s.Exchange != nil && s.Exchange.JWKS != nil {
+		s.Exchange.JWKS.cleanup()
+	}
+
+	if s.SigningJWKS != nil {
+		s.SigningJWKS.cleanup()
+	}
+
+	if s.Rotation != nil {
+		s.Rotation.cleanup()
+	}
+
+	return nil
+}
+
+func (s *JwtSigner) Validate() error {
+	vals := map[string]string{}
+
+	if s.Verify == nil && s.SignBody == nil && s.Vault == nil && s.durationCookie == "" {
+		vals["duration"] = s.Dur
+	}
+
+	if s.Tenant == "" && s.KeyFile == "" && s.SigningJWKS == nil && s.Vault == nil && (s.Verify == nil || s.Verify.JWKS == nil) && !s.keyedAlgorithmsOnly() {
+		vals["secret"] = s.Secret
+	}
+
+	for key, val := range vals {
+		if val == "" {
+			return fmt.Errorf("missing required parameter: %s", key)
+		}
+	}
+
+	if s.AutoGenerateSecret && (s.KeyFile != "" || s.Tenant != "" || s.Vault != nil) {
+		return fmt.Errorf("auto_generate_secret cannot be combined with key_file, tenant or vault")
+	}
+
+	if s.SharedSecretStorageKey != "" && (s.KeyFile != "" || s.Tenant != "" || s.Vault != nil) {
+		return fmt.Errorf("shared_secret_storage_key cannot be combined with key_file, tenant or vault")
+	}
+
+	if s.StrictCurve && len(s.weakKeys) > 0 {
+		return fmt.Errorf("weak keys refused by strict_curve: %s", strings.Join(s.weakKeys, "; "))
+	}
+
+	if s.DenyWeakSecrets {
+		if err := s.checkWeakSecrets(); err != nil {
+			return err
+		}
+	}
+
+	if s.MinDuration <= 0 {
+		return fmt.Errorf("min_duration must be positive")
+	}
+
+	switch s.Concurrency {
+	case "", concurrencyParallel, concurrencySerial:
+	default:
+		return fmt.Errorf("unknown concurrency mode: %s", s.Concurrency)
+	}
+
+	if s.MaxDuration != 0 && s.MaxDuration < s.MinDuration {
+		return fmt.Errorf("max_duration must not be below min_duration")
+	}
+
+	switch {
+	case s.DurationSource == "", s.DurationSource == durationSourceConfig:
+	case strings.HasPrefix(s.DurationSource, durationSourceCookie) && s.durationCookie != "":
+	default:
+		return fmt.Errorf("unknown duration source: %s", s.DurationSource)
+	}
+
+	if s.durStatic {
+		if err := s.checkDuration(s.staticDur); err != nil {
+			return err
+		}
+	}
+
+	if s.SignResponse {
+		switch s.SignResponseEncoding {
+		case payloadEncodingBase64, payloadEncodingJSON:
+		default:
+			return fmt.Errorf("unknown response payload encoding: %s", s.SignResponseEncoding)
+		}
+
+		if s.SignResponseMaxSize < 0 {
+			return fmt.Errorf("response max size must not be negative")
+		}
+	}
+
+	if s.Cookie != nil {
+		if err := s.Cookie.validate(); err != nil {
+			return err
+		}
+	}
+
+	if s.Redirect != nil {
+		if err := s.Redirect.validate(); err != nil {
+			return err
+		}
+
+		if s.SignResponse {
+			return fmt.Errorf("redirect cannot be combined with sign_response")
+		}
+	}
+
+	if s.Verify != nil {
+		if err := s.Verify.validate(); err != nil {
+			return err
+		}
+
+		if s.SignResponse || s.Cookie != nil || s.Redirect != nil || s.RespondJSON != nil || s.FanOut {
+			return fmt.Errorf("verify cannot be combined with signing outputs")
+		}
+	}
+
+	if s.SignBody != nil {
+		if err := s.SignBody.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.SignResponse {
+			return fmt.Errorf("sign_body cannot be combined with verify or sign_response")
+		}
+	}
+
+	if s.Exchange != nil {
+		if err := s.Exchange.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.SignBody != nil || s.SignResponse || s.FanOut {
+			return fmt.Errorf("exchange cannot be combined with verify, sign_body, sign_response or fan_out")
+		}
+	}
+
+	if err := s.validatePreset(); err != nil {
+		return err
+	}
+
+	if s.Vault != nil {
+		if err := s.Vault.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.Exchange != nil || s.Refresh != nil || s.Rotation != nil || s.ClientCredentials != nil ||
+			s.SignBody != nil || s.SignResponse || s.FanOut || s.Cookie != nil && s.Cookie.RefreshBefore != 0 {
+			return fmt.Errorf("vault cannot be combined with other modes or cookie refresh_before")
+		}
+
+		if s.Secret != "" || s.KeyFile != "" || s.Tenant != "" || s.JWEAlgorithm != "" || s.tokenCaching() {
+			return fmt.Errorf("vault cannot be combined with local keys, jwe_algorithm or token caching")
+		}
+	}
+
+	if s.ClientCredentials != nil {
+		if err := s.ClientCredentials.validate(s); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.Exchange != nil || s.Refresh != nil || s.Rotation != nil || s.SignBody != nil || s.SignResponse || s.FanOut || s.Cookie != nil || s.Redirect != nil {
+			return fmt.Errorf("client_credentials cannot be combined with other modes, cookie or redirect")
+		}
+	}
+
+	if s.Refresh != nil {
+		if err := s.Refresh.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.Exchange != nil || s.SignBody != nil || s.SignResponse || s.FanOut {
+			return fmt.Errorf("refresh_if_expiring_within cannot be combined with verify, exchange, sign_body, sign_response or fan_out")
+		}
+
+		if s.Cookie != nil && s.Cookie.RefreshBefore != 0 {
+			return fmt.Errorf("refresh_if_expiring_within cannot be combined with cookie refresh_before")
+		}
+	}
+
+	if s.Rotation != nil {
+		if err := s.Rotation.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.Exchange != nil || s.Refresh != nil || s.SignBody != nil || s.SignResponse || s.FanOut {
+			return fmt.Errorf("rotate_refresh_tokens cannot be combined with verify, exchange, refresh_if_expiring_within, sign_body, sign_response or fan_out")
+		}
+
+		if s.JWEAlgorithm != "" || s.Cookie != nil && s.Cookie.RefreshBefore != 0 {
+			return fmt.Errorf("rotate_refresh_tokens cannot be combined with jwe_algorithm or cookie refresh_before")
+		}
+	}
+
+	if s.Session != nil {
+		if err := s.Session.validate(s.Cookie); err != nil {
+			return err
+		}
+
+		if s.Exchange != nil || s.SignBody != nil || s.SignResponse || s.FanOut || s.Redirect != nil || s.RespondJSON != nil {
+			return fmt.Errorf("session cannot be combined with exchange, sign_body, sign_response, fan_out, redirect or respond_json")
+		}
+	}
+
+	if len(s.SkipIfEmpty) > 0 && s.Verify != nil {
+		return fmt.Errorf("skip_if_empty cannot be combined with verify")
+	}
+
+	for _, ph := range s.SkipIfEmpty {
+		if !strings.Contains(ph, "{") {
+			return fmt.Errorf("skip_if_empty %s is not a placeholder", ph)
+		}
+	}
+
+	if s.AuthEndpoint != nil {
+		if err := s.AuthEndpoint.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify == nil {
+			return fmt.Errorf("auth_endpoint requires verify")
+		}
+	}
+
+	if s.Resign != nil {
+		if err := s.Resign.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.SignBody != nil || s.SignResponse || s.Exchange != nil || s.ClientCredentials != nil ||
+			s.Vault != nil || s.Refresh != nil || s.Rotation != nil || s.Session != nil || s.CSRF != nil {
+			return fmt.Errorf("resign cannot be combined with another mode")
+		}
+
+		if s.FanOut || s.Redirect != nil || s.RespondJSON != nil {
+			return fmt.Errorf("resign replaces the token in the response and cannot use fan_out, redirect or respond_json")
+		}
+	}
+
+	if s.CSRF != nil {
+		if err := s.CSRF.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.SignBody != nil || s.SignResponse || s.Exchange != nil || s.ClientCredentials != nil ||
+			s.Vault != nil || s.Refresh != nil || s.Rotation != nil || s.Session != nil {
+			return fmt.Errorf("csrf cannot be combined with another mode")
+		}
+
+		if s.FanOut || s.Cookie != nil || s.Redirect != nil || s.RespondJSON != nil {
+			return fmt.Errorf("csrf delivers its token in its own cookie and cannot use fan_out, cookie, redirect or respond_json")
+		}
+
+		// a token naming the user could be passed off as a session token, as it is signed with the same key
+		if len(s.Claims) > 0 || len(s.EnvClaims) > 0 || len(s.HashClaims) > 0 || len(s.RequestClaims) > 0 || s.RequestPathClaim != "" ||
+			s.SubjectFromCert != nil || s.PKCE != nil {
+			return fmt.Errorf("csrf tokens carry no configured claims")
+		}
+	}
+
+	if s.Refresh != nil && s.Refresh.SingleUse || s.Rotation != nil && s.Rotation.SingleUse {
+		return fmt.Errorf("single_use is only supported with verify and exchange")
+	}
+
+	if s.Refresh != nil && s.Refresh.ReplayProtection != nil || s.Rotation != nil && s.Rotation.ReplayProtection != nil ||
+		s.Session != nil && s.Session.ReplayProtection != nil {
+		return fmt.Errorf("replay_protection is only supported with verify and exchange")
+	}
+
+	if s.Exchange != nil && s.Exchange.DPoP != nil || s.Refresh != nil && s.Refresh.DPoP != nil ||
+		s.Rotation != nil && s.Rotation.DPoP != nil || s.Session != nil && s.Session.DPoP != nil {
+		return fmt.Errorf("dpop proofs of presented tokens are only checked with verify")
+	}
+
+	if s.DPoP != nil {
+		if err := s.DPoP.validate(); err != nil {
+			return err
+		}
+
+		if s.Verify != nil || s.SignBody != nil || s.SignResponse || s.Vault != nil {
+			return fmt.Errorf("dpop binding cannot be combined with verify, sign_body, sign_response or vault, use dpop inside verify instead")
+		}
+	}
+
+	if s.GenerateNonce != nil {
+		if err := s.GenerateNonce.validate(); err != nil {
+			return err
+		}
+
+		if _, ok := s.Claims[s.GenerateNonce.Claim]; ok {
+			return fmt.Errorf("claim %s is defined both as a claim value and by generate_nonce", s.GenerateNonce.Claim)
+		}
+
+		if s.tokenCaching() {
+			return fmt.Errorf("generate_nonce cannot be combined with token caching, every token needs a fresh nonce")
+		}
+	}
+
+	if s.IdempotentJTI != nil {
+		if err := s.IdempotentJTI.validate(s.Secret); err != nil {
+			return err
+		}
+
+		if _, ok := s.Claims["jti"]; ok {
+			return fmt.Errorf("claim jti is defined both as a claim value and by idempotent_jti")
+		}
+
+		if s.tokenCaching() || s.Preset == presetAssertion || s.Rotation != nil {
+			return fmt.Errorf("idempotent_jti cannot be combined with token caching, preset assertion or rotate_refresh_tokens")
+		}
+	}
+
+	if s.Revocation != nil && s.Verify == nil && s.Exchange == nil && s.Refresh == nil && s.Rotation == nil && s.Session == nil {
+		return fmt.Errorf("revocation requires verify, exchange, refresh_if_expiring_within, rotate_refresh_tokens or session")
+	}
+
+	if s.RespondJSON != nil {
+		if err := s.RespondJSON.validate(); err != nil {
+			return err
+		}
+
+		if s.SignResponse || s.Redirect != nil {
+			return fmt.Errorf("respond_json cannot be combined with sign_response or redirect")
+		}
+	}
+
+	if s.SigningJWKS != nil {
+		if err := s.SigningJWKS.validate(); err != nil {
+			return err
+		}
+
+		if s.Secret != "" || s.KeyFile != "" || s.Tenant != "" || s.Vault != nil || s.AutoGenerateSecret ||
+			s.SharedSecretStorageKey != "" || s.Algorithm != "" || len(s.AlgorithmAllow) > 0 {
+			return fmt.Errorf("signing_jwks cannot be combined with other signing key or algorithm options")
+		}
+	}
+
+	if s.CertFile != "" && s.KeyFile == "" {
+		return fmt.Errorf("cert_file requires key_file")
+	}
+
+	if s.IncludeX5C && s.CertFile == "" {
+		return fmt.Errorf("include_x5c requires cert_file")
+	}
+
+	if s.KeyFile != "" {
+		if s.Secret != "" || s.Tenant != "" {
+			return fmt.Errorf("key_file cannot be combined with secret or tenant")
+		}
+
+		if err := s.validateKeyAlgorithm(); err != nil {
+			return err
+		}
+	} else if s.Algorithm != "" && !strings.Contains(s.Algorithm, "{") && len(s.AlgorithmAllow) == 0 {
+		if _, err := resolveHMACMethod(s.Algorithm); err != nil {
+			return err
+		}
+	}
+
+	if err := s.validateAlgorithmAllow(); err != nil {
+		return err
+	}
+
+	if err := s.validateHTTP2Push(); err != nil {
+		return err
+	}
+
+	if s.TokenCacheTTL < 0 {
+		return fmt.Errorf("token cache TTL must not be negative")
+	}
+
+	if s.SigningTimeout < 0 {
+		return fmt.Errorf("signing_timeout must not be negative")
+	}
+
+	if s.TokenCacheReuse < 0 || s.TokenCacheReuse > 1 {
+		return fmt.Errorf("token_cache_reuse must be between 0 and 100%%")
+	}
+
+	if s.TokenCacheSize < 0 {
+		return fmt.Errorf("token_cache_size must not be negative")
+	}
+
+	if err := validateBodyContentType(s.BodyContentType); err != nil {
+		return err
+	}
+
+	if s.RequestBodyLimitBytes < 0 {
+		return fmt.Errorf("request body limit must not be negative")
+	}
+
+	if len(s.ScopedSecrets) > 0 {
+		if s.Tenant != "" || s.KeyFile != "" {
+			return fmt.Errorf("scoped_secrets cannot be combined with tenant or key_file")
+		}
+
+		for scope, secret := range s.ScopedSecrets {
+			if scope == "" || secret == "" {
+				return fmt.Errorf("scoped_secrets requires non-empty scopes and secrets")
+			}
+		}
+	}
+
+	if err := s.validatePreviousSecrets(); err != nil {
+		return err
+	}
+
+	if s.Challenge != nil {
+		if err := s.Challenge.validate(); err != nil {
+			return err
+		}
+	}
+
+	if s.Tenant != "" && len(s.TenantKeys) == 0 {
+		return fmt.Errorf("tenant is set but no tenant keys are configured")
+	}
+
+	if err := validateAliases(s.ClaimAliases); err != nil {
+		return err
+	}
+
+	if err := validateRequestClaims(s.RequestClaims); err != nil {
+		return err
+	}
+
+	if s.PathPrefixStrip != "" {
+		if s.RequestPathClaim == "" {
+			return fmt.Errorf("path_prefix_strip requires a request_path_claim")
+		}
+
+		if !strings.HasPrefix(s.PathPrefixStrip, "/") {
+			return fmt.Errorf("path_prefix_strip must start with a slash: %s", s.PathPrefixStrip)
+		}
+	}
+
+	if s.SubjectFromCert != nil {
+		if err := s.SubjectFromCert.validate(); err != nil {
+			return err
+		}
+	}
+
+	if s.IncludeQuery != nil {
+		if err := s.IncludeQuery.validate(s); err != nil {
+			return err
+		}
+	}
+
+	if s.ClaimsFromBody != nil {
+		if err := s.ClaimsFromBody.validate(s); err != nil {
+			return err
+		}
+	}
+
+	if s.PKCE != nil {
+		if err := s.PKCE.validate(); err != nil {
+			return err
+		}
+	}
+
+	if s.LabelClaim != "" && s.Label == "" {
+		return fmt.Errorf("label_claim requires a label or a handler name")
+	}
+
+	if err := ValidateClaimKeys(s.Claims); err != nil {
+		return err
+	}
+
+	if err := validateClaimPaths(s.Claims); err != nil {
+		return err
+	}
+
+	for claim := range s.EnvClaims {
+		if _, ok := s.Claims[claim]; ok {
+			return fmt.Errorf("claim %s is defined both as a claim value and from the environment", claim)
+		}
+	}
+
+	if err := s.validateHashClaims(); err != nil {
+		return err
+	}
+
+	if err := validateOnMissing(s.OnMissingClaimSource, s.ClaimDefaults); err != nil {
+		return err
+	}
+
+	switch s.NestedClaims {
+	case "", nestedClaimsPartial, nestedClaimsAllOrNothing:
+	default:
+		return fmt.Errorf("unknown nested_claims mode: %s", s.NestedClaims)
+	}
+
+	switch s.OutputSerialization {
+	case "", serializationCompact:
+	case serializationJSON, serializationDetached:
+		if s.Cookie != nil {
+			return fmt.Errorf("cookie delivery requires compact output serialization")
+		}
+	default:
+		return fmt.Errorf("unknown output serialization: %s", s.OutputSerialization)
+	}
+
+	if s.TimestampFormat != "" && s.TimestampFormat != timestampUnix {
+		if s.Refresh != nil || s.Rotation != nil || s.Cookie != nil && s.Cookie.RefreshBefore != 0 {
+			return fmt.Errorf("timestamp_format %s cannot be combined with refreshing issued tokens", s.TimestampFormat)
+		}
+
+		if s.NumericDates {
+			return fmt.Errorf("timestamp_format %s cannot be combined with numeric_dates", s.TimestampFormat)
+		}
+	}
+
+	if s.JWEAlgorithm != "" {
+		if s.OutputSerialization != "" && s.OutputSerialization != serializationCompact {
+			return fmt.Errorf("jwe_algorithm requires compact output serialization")
+		}
+
+		if s.Verify != nil || s.SignBody != nil || s.Refresh != nil {
+			return fmt.Errorf("jwe_algorithm cannot be combined with verify, sign_body or refresh_if_expiring_within")
+		}
+
+		if s.Cookie != nil && s.Cookie.RefreshBefore != 0 {
+			return fmt.Errorf("jwe_algorithm cannot be combined with cookie refresh_before")
+		}
+	} else if s.JWEEnc != "" || s.JWERecipientKeyFile != "" {
+		return fmt.Errorf("jwe_enc and jwe_recipient_key_file require jwe_algorithm")
+	}
+
+	if s.FanOut {
+		if len(s.Audience) == 0 {
+			return fmt.Errorf("fan_out requires at least one audience")
+		}
+
+		if s.SignResponse || s.Cookie != nil || s.Redirect != nil || s.RespondJSON != nil {
+			return fmt.Errorf("fan_out cannot be combined with sign_response, cookie, redirect or respond_json")
+		}
+	}
+
+	switch s.OnSchemaError {
+	case "", onSchemaErrorError, onSchemaErrorSkip, onSchemaErrorWarn:
+	default:
+		return fmt.Errorf("unknown on_schema_error mode: %s", s.OnSchemaError)
+	}
+
+	return nil
+}
+
+var claimKeyPattern = regexp.MustCompile(`^[\x21-\x7E]+$`)
+
+// ValidateClaimKeys requires every claim key, at any depth, to be printable ASCII without spaces. Keys with
+// whitespace, control characters or non-ASCII runes trip up some JWT libraries and header parsers.
+func ValidateClaimKeys(claims jwt.MapClaims) error {
+	return validateClaimKeys(claims, "")
+}
+
+func validateClaimKeys(claims map[string]any, prefix string) error {
+	for _, key := range slices.Sorted(maps.Keys(claims)) {
+		path := prefix + key
+
+		if !claimKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid claim key %q: keys must be printable ASCII without spaces", path)
+		}
+
+		if err := validateNestedClaimKeys(claims[key], path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateNestedClaimKeys(v any, path string) error {
+	switch val := v.(type) {
+	case map[string]any:
+		return validateClaimKeys(val, path+".")
+	case jwt.MapClaims:
+		return validateClaimKeys(val, path+".")
+	case []any:
+		for i, el := range val {
+			if err := validateNestedClaimKeys(el, path+"."+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// claimPathNode is a node of the tree of dotted claim paths, holding the configured path of the value ending here
+// or the first one passing below it.
+type claimPathNode struct {
+	leaf     string
+	below    string
+	children map[string]*claimPathNode
+}
+
+// validateClaimPaths rejects claims whose dotted paths contradict each other, such as user.id next to a plain user
+// claim, or user.id given both as a dotted key and inside a user block. Dotted keys name nested claims for
+// placeholders and claim lookups, so such claims could not be told apart. All conflicts are reported at once.
+func validateClaimPaths(claims jwt.MapClaims) error {
+	root := &claimPathNode{}
+
+	var conflicts []string
+	collectClaimPaths(claims, nil, "", root, &conflicts)
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting claim paths: %s", strings.Join(conflicts, "; "))
+	}
+
+	return nil
+}
+
+func collectClaimPaths(claims map[string]any, segs []string, prefix string, root *claimPathNode, conflicts *[]string) {
+	for _, key := range slices.Sorted(maps.Keys(claims)) {
+		keySegs := append(slices.Clip(segs), strings.Split(key, ".")...)
+		path := prefix + key
+
+		switch val := claims[key].(type) {
+		case map[string]any:
+			collectClaimPaths(val, keySegs, path+".", root, conflicts)
+		case jwt.MapClaims:
+			collectClaimPaths(val, keySegs, path+".", root, conflicts)
+		default:
+			if conflict := root.insert(keySegs, path); conflict != "" {
+				*conflicts = append(*conflicts, conflict)
+			}
+		}
+	}
+}
+
+func (n *claimPathNode) insert(segs []string, path string) string {
+	node := n
+
+	for _, seg := range segs {
+		if node.leaf != "" {
+			return fmt.Sprintf("%s conflicts with %s", path, node.leaf)
+		}
+
+		if node.below == "" {
+			node.below = path
+		}
+
+		child, ok := node.children[seg]
+		if !ok {
+			if node.children == nil {
+				node.children = map[string]*claimPathNode{}
+			}
+
+			child = &claimPathNode{}
+			node.children[seg] = child
+		}
+
+		node = child
+	}
+
+	switch {
+	case node.leaf != "":
+		return fmt.Sprintf("%s is defined more than once", path)
+	case node.below != "":
+		return fmt.Sprintf("%s conflicts with %s", path, node.below)
+	}
+
+	node.leaf = path
+
+	return ""
+}
+
+func (s *JwtSigner) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	s.l.Debug("Run", zap.String("path", r.URL.Path), zap.String("query", r.URL.RawQuery))
+
+	if s.SigningTimeout == 0 {
+		return s.serve(w, r, next)
+	}
+
+	return s.serveWithTimeout(w, r, next)
+}
+
+func (s *JwtSigner) serve(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if repl == nil {
+		return fmt.Errorf("no replacer found in context")
+	}
+
+	if s.Verify != nil {
+		return s.serveVerify(w, r, repl, next)
+	}
+
+	for _, ph := range s.SkipIfEmpty {
+		if strings.TrimSpace(repl.ReplaceAll(ph, "")) == "" {
+			s.l.Debug("Placeholder is empty, skip signing", zap.String("placeholder", ph))
+			return next.ServeHTTP(w, r)
+		}
+	}
+
+	if s.SignBody != nil {
+		return s.serveSignedBody(w, r, repl, next)
+	}
+
+	if s.SignResponse {
+		return s.serveSignedResponse(w, r, repl, next)
+	}
+
+	if s.Exchange != nil {
+		return s.serveExchange(w, r, repl, next)
+	}
+
+	if s.Resign != nil {
+		return s.serveResign(w, r, repl, next)
+	}
+
+	if s.ClientCredentials != nil {
+		return s.serveClientCredentials(w, r, repl, next)
+	}
+
+	if s.Vault != nil {
+		return s.serveVault(w, r, repl, next)
+	}
+
+	if s.Refresh != nil {
+		return s.serveRefresh(w, r, repl, next)
+	}
+
+	if s.Rotation != nil {
+		return s.serveRotation(w, r, repl, next)
+	}
+
+	if s.Session != nil {
+		return s.serveSession(w, r, repl, next)
+	}
+
+	if s.CSRF != nil {
+		return s.serveCSRF(w, r, repl, next)
+	}
+
+	if s.Cookie != nil && s.Cookie.RefreshBefore != 0 {
+		if tosStr, cs, ok := s.freshCookie(r, repl); ok {
+			s.l.Debug("Token cookie is still fresh, skip signing")
+
+			repl.Set("http.jwt_signer.digest_str", tosStr)
+			setIssuedClaimPlaceholders(repl, "", cs)
+			s.exportVars(r, cs)
+
+			return next.ServeHTTP(w, withClaims(r, cs))
+		}
+	}
+
+	if s.FanOut {
+		return s.serveFanOut(w, r, repl, next)
+	}
+
+	return s.issue(w, r, repl, next, nil)
+}
+
+// issue signs a new token and delivers it, keep holds claims carried over from a presented token which take
+// precedence over the configured ones.
+func (s *JwtSigner) issue(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler, keep jwt.MapClaims) error {
+	tosStr, cs, err := s.signIssued(r, repl, keep)
+	if err != nil {
+		return s.skipOrFail(w, r, next, err)
+	}
+
+	return s.output(w, r, repl, next, tosStr, cs)
+}
+
+// signIssued signs the token issue hands out, without handing it out yet.
+func (s *JwtSigner) signIssued(r *http.Request, repl *caddy.Replacer, keep jwt.MapClaims) (string, jwt.MapClaims, error) {
+	extra, err := s.extraClaims(repl)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(keep) > 0 {
+		for k, v := range extra {
+			keep[k] = v
+		}
+
+		extra = keep
+	}
+
+	return s.sign(r, repl, nil, extra)
+}
+
+// skipOrFail passes the request on when signing was skipped and fails it otherwise.
+func (s *JwtSigner) skipOrFail(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, err error) error {
+	if errors.Is(err, errSkipSigning) {
+		return next.ServeHTTP(w, r)
+	}
+
+	return err
+}
+
+func (s *JwtSigner) extraClaims(repl *caddy.Replacer) (jwt.MapClaims, error) {
+	// most handlers add nothing, spare them the map
+	if (s.RespondJSON == nil || s.RespondJSON.CSRFClaim == "") && s.GenerateNonce == nil && s.IdempotentJTI == nil &&
+		s.Preset != presetAssertion {
+		return nil, nil
+	}
+
+	extra := jwt.MapClaims{}
+
+	if s.RespondJSON != nil && s.RespondJSON.CSRFClaim != "" {
+		csrf, err := newCSRFToken()
+		if err != nil {
+			return nil, err
+		}
+
+		extra[s.RespondJSON.CSRFClaim] = csrf
+	}
+
+	if s.GenerateNonce != nil {
+		nonce, err := s.GenerateNonce.generate()
+		if err != nil {
+			return nil, err
+		}
+
+		extra[s.GenerateNonce.Claim] = nonce
+	}
+
+	if s.IdempotentJTI != nil {
+		jti, err := s.IdempotentJTI.generate(repl, s.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		extra["jti"] = jti
+	}
+
+	if s.Preset == presetAssertion {
+		jti, err := newTokenID()
+		if err != nil {
+			return nil, err
+		}
+
+		extra["jti"] = jti
+	}
+
+	if len(extra) == 0 {
+		return nil, nil
+	}
+
+	return extra, nil
+}
+
+func (s *JwtSigner) output(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler, tosStr string, cs jwt.MapClaims) error {
+	if s.RespondJSON != nil {
+		s.deliver(w, r, repl, tosStr, cs)
+		s.noStore(w)
+
+		return s.RespondJSON.write(w, tosStr, cs)
+	}
+
+	if s.Redirect != nil {
+		loc, err := s.Redirect.location(r, repl, tosStr, cs)
+		if err != nil {
+			return err
+		}
+
+		s.deliver(w, r, repl, tosStr, cs)
+		s.noStore(w)
+
+		w.Header().Set("Location", loc)
+		w.WriteHeader(s.Redirect.Status)
+
+		return nil
+	}
+
+	s.deliver(w, r, repl, tosStr, cs)
+
+	return next.ServeHTTP(w, withClaims(r, cs))
+}
+
+func (s *JwtSigner) serveFanOut(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	toks, err := s.signPerAudience(r, repl)
+	if errors.Is(err, errSkipSigning) {
+		return next.ServeHTTP(w, r)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, tok := range toks {
+		repl.Set("http.jwt_signer.aud."+tok.aud+".digest_str", tok.tosStr)
+	}
+
+	s.deliver(w, r, repl, toks[0].tosStr, toks[0].cs)
+
+	return next.ServeHTTP(w, withClaims(r, toks[0].cs))
+}
+
+func (s *JwtSigner) deliver(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, tosStr string, cs jwt.MapClaims) {
+	repl.Set("http.jwt_signer.digest_str", tosStr)
+
+	if s.OutputSerialization == serializationDetached {
+		if err := setDetachedPlaceholders(repl, tosStr, cs); err != nil {
+			s.l.Error("Failed to expose detached token parts", zap.Error(err))
+		}
+	}
+	setIssuedClaimPlaceholders(repl, "", cs)
+	s.exportVars(r, cs)
+
+	if s.InjectHeader != "" {
+		r.Header.Set(s.InjectHeader, s.headerValue(tosStr))
+	}
+
+	if s.Cookie != nil {
+		http.SetCookie(w, s.Cookie.build(tosStr, cs))
+	}
+
+	s.push(w, repl, tosStr)
+}
+
+// noStore keeps responses carrying a token out of shared and browser caches.
+func (s *JwtSigner) noStore(w http.ResponseWriter) {
+	if s.AllowCaching {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
+
+func (s *JwtSigner) headerValue(tosStr string) string {
+	prefix := "Bearer"
+	if s.HeaderPrefix != nil {
+		prefix = *s.HeaderPrefix
+	}
+
+	if prefix == "" {
+		return tosStr
+	}
+
+	return prefix + " " + tosStr
+}
+
+// setIssuedClaimPlaceholders exposes the string, number and boolean claims of an issued token as
+// {http.jwt_signer.claim.<name>}, nested objects are flattened with dotted names and arrays are skipped.
+func setIssuedClaimPlaceholders(repl *caddy.Replacer, prefix string, cs map[string]any) {
+	for k, v := range cs {
+		switch val := v.(type) {
+		case string, float64, int64, int, bool, json.Number:
+			repl.Set("http.jwt_signer.claim."+prefix+k, claimString(val))
+		case map[string]any:
+			setIssuedClaimPlaceholders(repl, prefix+k+".", val)
+		case jwt.MapClaims:
+			setIssuedClaimPlaceholders(repl, prefix+k+".", val)
+		}
+	}
+}
+
+func (s *JwtSigner) exportVars(r *http.Request, cs jwt.MapClaims) {
+	for _, key := range s.ExportVars {
+		if val, ok := cs[key]; ok {
+			caddyhttp.SetVar(r.Context(), "jwt_"+key, val)
+		}
+	}
+}
+
+func (s *JwtSigner) sign(r *http.Request, repl *caddy.Replacer, base, extra jwt.MapClaims) (string, jwt.MapClaims, error) {
+	key, cs, err := s.buildClaims(r, repl, base, extra)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if auds := s.audiences(repl); len(auds) > 0 {
+		cs["aud"] = s.audienceClaim(auds)
+	}
+
+	if key, err = s.scopedKey(key, repl, cs["aud"]); err != nil {
+		return "", nil, err
+	}
+
+	if s.tokenCache == nil {
+		return s.signClaims(key, cs)
+	}
+
+	cacheKey, err := tokenCacheKey(key, cs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	exp, _ := cs["exp"].(int64)
+	if entry, ok := s.tokenCache.get(cacheKey, time.Unix(exp, 0)); ok {
+		s.l.Debug("Reuse cached token", zap.Time("exp", entry.exp))
+		return entry.tosStr, entry.cs, nil
+	}
+
+	tosStr, signed, shared, err := s.tokenCache.sign(cacheKey, func() (string, jwt.MapClaims, error) {
+		return s.signClaims(key, cs)
+	})
+	if shared {
+		s.l.Debug("Share token signed by a concurrent request", zap.Error(err))
+	}
+
+	return tosStr, signed, err
+}
+
+type audienceToken struct {
+	aud    string
+	tosStr string
+	cs     jwt.MapClaims
+}
+
+func (s *JwtSigner) signPerAudience(r *http.Request, repl *caddy.Replacer) ([]audienceToken, error) {
+	auds := s.audiences(repl)
+	if len(auds) == 0 {
+		return nil, fmt.Errorf("required parameter empty after replacements: audience")
+	}
+
+	key, base, err := s.buildClaims(r, repl, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	toks := make([]audienceToken, 0, len(auds))
+
+	for _, aud := range auds {
+		cs := make(jwt.MapClaims, len(base)+1)
+		for k, v := range base {
+			cs[k] = v
+		}
+
+		cs["aud"] = s.audienceClaim([]string{aud})
+
+		audKey, err := s.scopedKey(key, repl, aud)
+		if err != nil {
+			return nil, err
+		}
+
+		tosStr, cs, err := s.signClaims(audKey, cs)
+		if err != nil {
+			return nil, fmt.Errorf("signing token for audience %s: %w", aud, err)
+		}
+
+		toks = append(toks, audienceToken{aud: aud, tosStr: tosStr, cs: cs})
+	}
+
+	return toks, nil
+}
+
+func (s *JwtSigner) buildClaims(r *http.Request, repl *caddy.Replacer, base, extra jwt.MapClaims) (*signingKey, jwt.MapClaims, error) {
+	// a duration without placeholders was parsed and checked once at provision
+	dur, durStr, parse := s.staticDur, s.Dur, !s.durStatic
+	if parse {
+		durStr = repl.ReplaceAll(s.Dur, "")
+	}
+
+	fromCookie := false
+	if override, ok := r.Context().Value(durationOverrideKey{}).(time.Duration); ok {
+		durStr, parse = override.String(), true
+	} else if s.durationCookie != "" {
+		if cookie, err := r.Cookie(s.durationCookie); err == nil && cookie.Value != "" {
+			durStr, fromCookie, parse = cookie.Value, true, true
+		} else if durStr == "" {
+			return nil, nil, caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("no duration cookie %s presented", s.durationCookie))
+		}
+	}
+
+	if durStr == "" {
+		return nil, nil, fmt.Errorf("required parameter empty after replacements: dur")
+	}
+
+	key, err := s.key(repl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if parse {
+		dur, err = parseTokenDuration(durStr, s.durationUnit)
+		if err == nil {
+			err = s.checkDuration(dur)
+		} else {
+			err = fmt.Errorf("invalid duration: %s", durStr)
+		}
+
+		if err != nil {
+			if fromCookie {
+				return nil, nil, caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("duration cookie %s: %w", s.durationCookie, err))
+			}
+
+			return nil, nil, err
+		}
+	}
+
+	s.l.Debug("Parsed duration", zap.String("as_str", durStr), zap.Float64("seconds", dur.Seconds()), zap.Bool("from_cookie", fromCookie))
+
+	// room for every configured source and iat and exp
+	cs := make(jwt.MapClaims, len(base)+len(s.staticClaims)+len(s.dynamicClaims)+len(s.envClaims)+len(extra)+2)
+	for k, v := range base {
+		cs[k] = v
+	}
+
+	prov := s.newProvenance()
+	prov.set(base, func(string) string { return "token" })
+
+	if s.IncludeQuery != nil {
+		before := prov.snapshot(cs)
+		if err := s.IncludeQuery.apply(cs, r, s.onMissing(cs, repl), s.l); err != nil {
+			return nil, nil, err
+		}
+
+		prov.changed(before, cs, func(k string) string { return "query:" + strings.TrimPrefix(k, s.IncludeQuery.Prefix) })
+	}
+
+	if s.PKCE != nil {
+		before := prov.snapshot(cs)
+		if err := s.PKCE.apply(cs, r, s.onMissing(cs, repl), s.l); err != nil {
+			return nil, nil, err
+		}
+
+		prov.changed(before, cs, func(k string) string {
+			if k == s.PKCE.MethodClaim {
+				return "query:code_challenge_method"
+			}
+
+			return "query:code_challenge"
+		})
+	}
+
+	if s.ClaimsFromBody != nil {
+		before := prov.snapshot(cs)
+		if err := s.ClaimsFromBody.apply(cs, r, s.RequestBodyLimitBytes, s.BodyContentType, s.onMissing(cs, repl), s.l); err != nil {
+			return nil, nil, err
+		}
+
+		prov.changed(before, cs, func(k string) string {
+			if path, ok := s.ClaimsFromBody.Map[k]; ok {
+				return "body:" + path
+			}
+
+			return "body:" + k
+		})
+	}
+
+	if len(s.RequestClaims) > 0 {
+		filled, err := s.fillClaims(requestClaimsPattern(s.RequestClaims), repl)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for k, v := range filled {
+			cs[k] = v
+		}
+
+		prov.set(filled, func(k string) string { return "request:" + k })
+	}
+
+	if s.RequestPathClaim != "" {
+		cs[s.RequestPathClaim] = stripPathPrefix(r.URL.Path, s.PathPrefixStrip)
+		prov.setKey(s.RequestPathClaim, "request:path")
+	}
+
+	// claims without placeholders were filled at provision, they are shared and never modified
+	for k, v := range s.staticClaims {
+		cs[k] = v
+	}
+
+	prov.set(s.staticClaims, func(string) string { return "static" })
+
+	filled, err := s.fillClaims(s.dynamicClaims, repl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range filled {
+		cs[k] = v
+	}
+
+	prov.set(filled, func(k string) string { return claimSource(s.Claims[k]) })
+
+	for k, v := range s.envClaims {
+		cs[k] = v
+	}
+
+	prov.set(s.envClaims, func(k string) string { return "env:" + s.EnvClaims[k] })
+
+	if len(s.HashClaims) > 0 {
+		before := prov.snapshot(cs)
+		if err := s.addHashClaims(cs, repl, s.onMissing(cs, repl)); err != nil {
+			return nil, nil, err
+		}
+
+		prov.changed(before, cs, func(k string) string {
+			if hc, ok := s.HashClaims[k]; ok {
+				return hc.Function + ":" + claimSource(hc.Value)
+			}
+
+			return "default"
+		})
+	}
+
+	if s.SubjectFromCert != nil {
+		before := prov.snapshot(cs)
+		if err := s.applyCertSubject(r, cs); err != nil {
+			return nil, nil, err
+		}
+
+		prov.changed(before, cs, func(string) string { return "cert:" + s.SubjectFromCert.From })
+	}
+
+	if s.LabelClaim != "" {
+		label := repl.ReplaceAll(s.Label, "")
+		if s.TrimClaims {
+			label = strings.TrimSpace(label)
+		}
+
+		if label != "" {
+			cs[s.LabelClaim] = label
+			prov.setKey(s.LabelClaim, "label")
+		}
+	}
+
+	for k, v := range extra {
+		cs[k] = v
+	}
+
+	prov.set(extra, func(string) string { return "handler" })
+
+	before := prov.snapshot(cs)
+
+	if err := s.addTokenHashes(key.method, repl, cs); err != nil {
+		return nil, nil, err
+	}
+
+	if s.DPoP != nil {
+		if err := s.bindDPoP(r, cs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	now := time.Now()
+	cs["iat"] = now.Unix()
+	cs["exp"] = now.Add(dur).Unix()
+
+	if deadline, ok := r.Context().Value(deadlineKey{}).(time.Time); ok && deadline.Before(now.Add(dur)) {
+		cs["exp"] = deadline.Unix()
+	}
+
+	prov.changed(before, cs, func(k string) string {
+		switch k {
+		case "at_hash", "c_hash":
+			return "token_hash"
+		case "cnf":
+			return "dpop"
+		default:
+			return "registered"
+		}
+	})
+	prov.log(s.l, cs)
+
+	return key, cs, nil
+}
+
+func (s *JwtSigner) checkDuration(dur time.Duration) error {
+	if dur < time.Duration(s.MinDuration) {
+		return fmt.Errorf("duration %s is below the minimum of %s", dur, time.Duration(s.MinDuration))
+	}
+
+	if s.MaxDuration > 0 && dur > time.Duration(s.MaxDuration) {
+		return fmt.Errorf("duration %s is above the maximum of %s", dur, time.Duration(s.MaxDuration))
+	}
+
+	return nil
+}
+
+// parseTokenDuration accepts either a Go duration string or a bare integer counted in the given unit,
+// as sent by systems which only speak seconds.
+func parseTokenDuration(val string, unit time.Duration) (time.Duration, error) {
+	if strings.TrimLeft(val, "0123456789") != "" {
+		return time.ParseDuration(val)
+	}
+
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > math.MaxInt64/int64(unit) {
+		return 0, fmt.Errorf("duration out of range: %s", val)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+func (s *JwtSigner) signClaims(key *signingKey, cs jwt.MapClaims) (string, jwt.MapClaims, error) {
+	applyAliases(cs, s.ClaimAliases, s.l)
+
+	if s.NumericDates {
+		if err := normalizeNumericDates(cs); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if s.claimsSchema != nil {
+		if err := validateClaimsSchema(s.claimsSchema, cs); err != nil {
+			switch s.OnSchemaError {
+			case onSchemaErrorWarn:
+				s.l.Warn("Claims do not match schema, signing anyway", zap.Error(err))
+			case onSchemaErrorSkip:
+				s.l.Debug("Claims do not match schema, skip signing", zap.Error(err))
+				return "", nil, errSkipSigning
+			default:
+				return "", nil, caddyhttp.Error(http.StatusBadRequest, err)
+			}
+		}
+	}
+
+	payload := s.formatTimestamps(cs)
+	if s.CompressClaims {
+		compressed, err := compressClaims(payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("compressing claims: %w", err)
+		}
+
+		payload = compressed
+	}
+
+	tok := jwt.NewWithClaims(key.method, payload)
+	for k, v := range key.header {
+		tok.Header[k] = v
+	}
+	if s.CompressClaims {
+		tok.Header["cty"] = compressedContentType
+	}
+
+	unlock := s.lockSigning()
+	tosStr, err := serializeToken(s.OutputSerialization, key, tok)
+	unlock()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if s.jwe != nil {
+		if tosStr, err = s.jwe.encrypt([]byte(tosStr)); err != nil {
+			return "", nil, fmt.Errorf("encrypting token: %w", err)
+		}
+	}
+
+	return tosStr, cs, nil
+}
+
+// lockSigning holds the signing lock in serial concurrency mode, the returned function releases it.
+func (s *JwtSigner) lockSigning() func() {
+	if s.Concurrency != concurrencySerial {
+		return func() {}
+	}
+
+	s.signMu.Lock()
+
+	return s.signMu.Unlock
+}
+
+// formatTimestamps renders iat and exp as strings for consumers which do not accept NumericDate. The claims
+// handed back to the caller keep the integers, so expiry handling elsewhere is unaffected.
+func (s *JwtSigner) formatTimestamps(cs jwt.MapClaims) jwt.MapClaims {
+	var layout string
+
+	switch s.TimestampFormat {
+	case "", timestampUnix:
+		return cs
+	case timestampRFC3339:
+		layout = time.RFC3339
+	case timestampRFC3339Nano:
+		layout = time.RFC3339Nano
+	default:
+		layout = s.TimestampFormat
+	}
+
+	payload := make(jwt.MapClaims, len(cs))
+	for k, v := range cs {
+		payload[k] = v
+	}
+
+	for _, k := range []string{"iat", "exp"} {
+		if ts, ok := cs[k].(int64); ok {
+			payload[k] = time.Unix(ts, 0).UTC().Format(layout)
+		}
+	}
+
+	return payload
+}
+
+func (s *JwtSigner) audiences(repl *caddy.Replacer) []string {
+	auds := make([]string, 0, len(s.Audience))
+
+	for _, aud := range s.Audience {
+		if aud = repl.ReplaceAll(aud, ""); aud != "" {
+			auds = append(auds, aud)
+		}
+	}
+
+	return auds
+}
+
+func (s *JwtSigner) audienceClaim(auds []string) any {
+	if len(auds) == 1 && !s.AudienceAlwaysArray {
+		return auds[0]
+	}
+
+	return auds
+}
+
+// validateKeyAlgorithm makes sure an explicit algorithm fits the key loaded from key_file, whose type
+// otherwise decides the algorithm.
+func (s *JwtSigner) validateKeyAlgorithm() error {
+	if s.Algorithm == "" || s.moduleKey == nil || s.Algorithm == s.moduleKey.method.Alg() {
+		return nil
+	}
+
+	if s.Algorithm == signingMethodES256K.Alg() {
+		return fmt.Errorf("algorithm ES256K requires a key on the secp256k1 curve, key_file holds a key for %s", s.moduleKey.method.Alg())
+	}
+
+	return fmt.Errorf("algorithm %s does not match the %s key in key_file", s.Algorithm, s.moduleKey.method.Alg())
+}
+
+func (s *JwtSigner) key(repl *caddy.Replacer) (*signingKey, error) {
+	if s.moduleKey != nil {
+		return s.moduleKey, nil
+	}
+
+	if s.secretKey != nil {
+		return s.secretKey, nil
+	}
+
+	if s.SigningJWKS != nil {
+		return s.SigningJWKS.remote.current()
+	}
+
+	if s.Tenant != "" {
+		tenant := repl.ReplaceAll(s.Tenant, "")
+		if tenant == "" {
+			return nil, fmt.Errorf("required parameter empty after replacements: tenant")
+		}
+
+		key, ok := s.tenantKeys[tenant]
+		if !ok {
+			return nil, fmt.Errorf("unknown tenant: %s", tenant)
+		}
+
+		s.l.Debug("Selected tenant key", zap.String("tenant", tenant), zap.String("alg", key.method.Alg()))
+
+		return key, nil
+	}
+
+	if len(s.AlgorithmAllow) > 0 {
+		return s.allowedAlgorithmKey(repl)
+	}
+
+	secret := repl.ReplaceAll(s.Secret, "")
+	if secret == "" {
+		return nil, fmt.Errorf("required parameter empty after replacements: secret")
+	}
+
+	method := jwt.SigningMethod(jwt.SigningMethodHS256)
+	if s.Algorithm != "" {
+		var err error
+		if method, err = resolveHMACMethod(repl.ReplaceAll(s.Algorithm, "")); err != nil {
+			return nil, err
+		}
+	}
+
+	return hmacKey(method, secret), nil
+}
+
+func (s *JwtSigner) parse(tosStr string, repl *caddy.Replacer, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	key, err := s.key(repl)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		tok *jwt.Token
+		cs  jwt.MapClaims
+	)
+
+	opts = append(opts, jwt.WithValidMethods([]string{key.method.Alg()}), jwt.WithExpirationRequired())
+
+	for _, cand := range s.verificationCandidates(key, tosStr, repl) {
+		cs = jwt.MapClaims{}
+
+		tok, err = jwt.ParseWithClaims(tosStr, cs, func(*jwt.Token) (any, error) {
+			scoped, err := s.scopedKey(cand.key, repl, cs["aud"])
+			if err != nil {
+				return nil, err
+			}
+
+			return scoped.verify, nil
+		}, opts...)
+		if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			continue
+		}
+
+		if err == nil && len(s.PreviousSecrets) > 0 {
+			s.l.Debug("Token signature verified", zap.String("key", cand.name))
+		}
+
+		break
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.CompressClaims && tok.Header["cty"] == compressedContentType {
+		if err := expandClaims(cs); err != nil {
+			return nil, fmt.Errorf("expanding compressed claims: %w", err)
+		}
+	}
+
+	return cs, nil
+}
+
+func (s *JwtSigner) serveSignedResponse(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	rec := &bodyRecorder{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		limit:                 s.SignResponseMaxSize,
+	}
+
+	// the payload must be the body verifiers see, so the upstream is asked not to compress it
+	r.Header.Del("Accept-Encoding")
+
+	if err := next.ServeHTTP(rec, r); err != nil {
+		return err
+	}
+
+	if rec.passThrough {
+		s.l.Debug("Passed through unsigned response", zap.Int("status", rec.status))
+		return nil
+	}
+
+	if rec.overflow {
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("response body exceeds %d bytes", s.SignResponseMaxSize))
+	}
+
+	if enc := w.Header().Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("response body is encoded with %s", enc))
+	}
+
+	s.l.Debug("Recorded response", zap.Int("status", rec.Status()), zap.Int("size", rec.buf.Len()))
+
+	var payload any
+	switch s.SignResponseEncoding {
+	case payloadEncodingJSON:
+		if err := json.Unmarshal(rec.buf.Bytes(), &payload); err != nil {
+			return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("response body is not valid JSON: %w", err))
+		}
+	default:
+		payload = base64.RawURLEncoding.EncodeToString(rec.buf.Bytes())
+	}
+
+	tosStr, cs, err := s.sign(r, repl, nil, jwt.MapClaims{"payload": payload})
+	if errors.Is(err, errSkipSigning) {
+		w.WriteHeader(rec.Status())
+		_, err = w.Write(rec.buf.Bytes())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	s.deliver(w, r, repl, tosStr, cs)
+	s.noStore(w)
+
+	h := w.Header()
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", "application/jwt")
+	h.Set("Content-Length", strconv.Itoa(len(tosStr)))
+
+	w.WriteHeader(rec.Status())
+	_, err = io.WriteString(w, tosStr)
+	return err
+}
+
+// bodyRecorder buffers a successful response body up to the limit and holds back the status until the body is
+// replaced. Bodies over the limit are still accepted, and discarded, so that the upstream finishes and the handler
+// decides on the response. Responses with any other status are passed through as they are.
+type bodyRecorder struct {
+	*caddyhttp.ResponseWriterWrapper
+	status      int
+	buf         bytes.Buffer
+	limit       int64
+	overflow    bool
+	passThrough bool
+}
+
+func (br *bodyRecorder) WriteHeader(status int) {
+	if status < 200 || br.status != 0 {
+		return
+	}
+
+	br.status = status
+
+	if status > 299 {
+		br.passThrough = true
+		br.ResponseWriterWrapper.WriteHeader(status)
+	}
+}
+
+func (br *bodyRecorder) Write(p []byte) (int, error) {
+	if br.status == 0 {
+		br.status = http.StatusOK
+	}
+
+	if br.passThrough {
+		return br.ResponseWriterWrapper.Write(p)
+	}
+
+	if br.overflow || int64(br.buf.Len()+len(p)) > br.limit {
+		br.overflow = true
+		br.buf.Reset()
+		return len(p), nil
+	}
+
+	return br.buf.Write(p)
+}
+
+func (br *bodyRecorder) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{br}, r)
+}
+
+func (br *bodyRecorder) Status() int {
+	if br.status == 0 {
+		return http.StatusOK
+	}
+
+	return br.status
+}
+
+func loadClaimsFile(path string) (jwt.MapClaims, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading claims file: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	cs := jwt.MapClaims{}
+	if err := dec.Decode(&cs); err != nil {
+		return nil, fmt.Errorf("claims file %s: %w", path, err)
+	}
+
+	if dec.More() {
+		return nil, fmt.Errorf("claims file %s: unexpected data after the claims object", path)
+	}
+
+	return cs, nil
+}
+
+// fillClaims fills the claims pattern for the request, refusing claims which resolved empty with
+// strict_placeholders.
+func (s *JwtSigner) fillClaims(pat jwt.MapClaims, repl *caddy.Replacer) (jwt.MapClaims, error) {
+	cs := fillClaims(pat, repl, s.TrimClaims, s.NestedClaims, s.l)
+
+	if s.StrictPlaceholders {
+		if empty := emptiedClaims(pat, cs, ""); len(empty) > 0 {
+			return nil, caddyhttp.Error(http.StatusInternalServerError,
+				fmt.Errorf("claims resolved empty with strict_placeholders: %s", strings.Join(empty, ", ")))
+		}
+	}
+
+	return cs, nil
+}
+
+// emptiedClaims lists the dotted paths of pattern claims which are missing from or shrank in the filled claims.
+func emptiedClaims(pat, got map[string]any, prefix string) []string {
+	var empty []string
+
+	for _, k := range slices.Sorted(maps.Keys(pat)) {
+		path := prefix + k
+
+		switch val := pat[k].(type) {
+		case string:
+			if _, ok := got[k]; !ok {
+				empty = append(empty, path)
+			}
+		case map[string]any:
+			sub, _ := got[k].(map[string]any)
+			if sub == nil {
+				if cs, ok := got[k].(jwt.MapClaims); ok {
+					sub = cs
+				}
+			}
+
+			empty = append(empty, emptiedClaims(val, sub, path+".")...)
+		case []any:
+			arr, _ := got[k].([]any)
+			if len(arr) < len(val) {
+				empty = append(empty, path)
+				continue
+			}
+
+			for i, el := range val {
+				if obj, ok := el.(map[string]any); ok {
+					sub, _ := arr[i].(jwt.MapClaims)
+					empty = append(empty, emptiedClaims(obj, sub, path+"."+strconv.Itoa(i)+".")...)
+				}
+			}
+		}
+	}
+
+	return empty
+}
+
+func fillClaims(pat jwt.MapClaims, repl *caddy.Replacer, trim bool, nested string, l *zap.Logger) jwt.MapClaims {
+	cs := make(jwt.MapClaims, len(pat))
+
+	for k, v := range pat {
+		switch val := v.(type) {
+		case string:
+			valExpanded := repl.ReplaceAll(val, "")
+			if trim {
+				valExpanded = strings.TrimSpace(valExpanded)
+			}
+			l.Debug("String key expanded", zap.String("key", k), zap.String("value", valExpanded), zap.String("value_config", val))
+			if valExpanded != "" {
+				cs[k] = valExpanded
+			}
+		case map[string]any:
+			l.Debug("Descending into nested map", zap.String("key", k))
+			sub := fillClaims(val, repl, trim, nested, l)
+			if nested == nestedClaimsAllOrNothing && len(sub) < len(val) {
+				l.Debug("Nested map incomplete, omit it", zap.String("key", k))
+				sub = nil
+			}
+
+			if sub != nil {
+				cs[k] = sub
+			}
+		case []any:
+			arr := fillArray(val, repl, trim, nested, l)
+			l.Debug("Array key expanded", zap.String("key", k), zap.Int("length", len(arr)), zap.Int("length_config", len(val)))
+			if len(arr) > 0 {
+				cs[k] = arr
+			}
+		default:
+			l.Debug("Set value of non-obvious type", zap.String("key", k), zap.String("type", fmt.Sprintf("%T", val)))
+			cs[k] = v
+		}
+	}
+
+	l.Debug("Finalize current map", zap.Int("length", len(cs)))
+
+	if len(cs) == 0 {
+		return nil
+	}
+
+	return cs
+}
+
+// fillArray replaces placeholders in the string and object elements of an array claim and drops those left empty,
+// other elements are kept as they are. Object elements are filled like nested claims.
+func fillArray(pat []any, repl *caddy.Replacer, trim bool, nested string, l *zap.Logger) []any {
+	arr := make([]any, 0, len(pat))
+
+	for _, v := range pat {
+		switch val := v.(type) {
+		case string:
+			str := repl.ReplaceAll(val, "")
+			if trim {
+				str = strings.TrimSpace(str)
+			}
+
+			if str != "" {
+				arr = append(arr, str)
+			}
+		case map[string]any:
+			sub := fillClaims(val, repl, trim, nested, l)
+			if nested == nestedClaimsAllOrNothing && len(sub) < len(val) {
+				l.Debug("Array element incomplete, omit it")
+				sub = nil
+			}
+
+			if sub != nil {
+				arr = append(arr, sub)
+			}
+		case []any:
+			if sub := fillArray(val, repl, trim, nested, l); len(sub) > 0 {
+				arr = append(arr, sub)
+			}
+		default:
+			arr = append(arr, v)
+		}
+	}
+
+	return arr
+}
+
+func validateAliases(aliases map[string]string) error {
+	targets := make(map[string]string, len(aliases))
+
+	for src, dst := range aliases {
+		if src == "" || dst == "" {
+			return fmt.Errorf("claim alias must have both source and target names")
+		}
+
+		if src == dst {
+			return fmt.Errorf("claim alias %s points to itself", src)
+		}
+
+		if other, ok := targets[dst]; ok {
+			return fmt.Errorf("claims %s and %s are both aliased to %s", other, src, dst)
+		}
+
+		targets[dst] = src
+	}
+
+	for src := range aliases {
+		seen := map[string]bool{src: true}
+
+		for next, ok := aliases[src]; ok; next, ok = aliases[next] {
+			if seen[next] {
+				return fmt.Errorf("circular claim alias involving %s", src)
+			}
+
+			seen[next] = true
+		}
+	}
+
+	return nil
+}
+
+func applyAliases(cs jwt.MapClaims, aliases map[string]string, l *zap.Logger) {
+	moved := make(jwt.MapClaims, len(aliases))
+
+	for src, dst := range aliases {
+		if val, ok := cs[src]; ok {
+			l.Debug("Rename aliased claim", zap.String("from", src), zap.String("to", dst))
+			moved[dst] = val
+			delete(cs, src)
+		}
+	}
+
+	for k, v := range moved {
+		cs[k] = v
+	}
+}
+
+func (*JwtSigner) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.jwt_signer",
+		New: func() caddy.Module { return new(JwtSigner) },
+	}
+}
+
+func (s *JwtSigner) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+
+	d.Args(&s.Dur)
+	d.Args(&s.Secret)
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	cs := jwt.MapClaims{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		// the block holds the claims, as it always has, and the options go into their own block, so that no option
+		// can change the meaning of an existing claim
+		if d.Val() != "options" || d.CountRemainingArgs() > 0 {
+			if err := parseClaimCaddyfile(d, cs, s); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			if err := s.parseOptionCaddyfile(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(cs) > 0 {
+		s.Claims = cs
+	}
+
+	return nil
+}
+
+// parseOptionCaddyfile parses one entry of the options block.
+func (s *JwtSigner) parseOptionCaddyfile(d *caddyfile.Dispenser) error {
+	switch d.Val() {
+	case "duration":
+		if !d.Args(&s.Dur) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "secret":
+		if !d.Args(&s.Secret) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "key_file":
+		if !d.Args(&s.KeyFile) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "cert_file":
+		if !d.Args(&s.CertFile) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "include_x5c":
+		s.IncludeX5C = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "signing_jwks":
+		s.SigningJWKS = &SigningJWKSConfig{}
+		if err := s.SigningJWKS.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "verify":
+		s.Verify = &VerifyConfig{}
+		if err := s.Verify.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "refresh_if_expiring_within":
+		s.Refresh = &RefreshConfig{}
+		if err := s.Refresh.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "rotate_refresh_tokens":
+		s.Rotation = &RotationConfig{}
+		if err := s.Rotation.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "dpop":
+		s.DPoP = &DPoPConfig{}
+		if err := s.DPoP.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "generate_nonce":
+		s.GenerateNonce = &NonceConfig{}
+		if err := s.GenerateNonce.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "idempotent_jti":
+		s.IdempotentJTI = &IdempotentJTIConfig{}
+		if err := s.IdempotentJTI.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "session":
+		s.Session = &SessionConfig{}
+		if err := s.Session.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "auth_endpoint":
+		s.AuthEndpoint = &AuthEndpointConfig{}
+		if err := s.AuthEndpoint.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "resign":
+		s.Resign = &ResignConfig{}
+		if err := s.Resign.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "csrf":
+		s.CSRF = &CSRFConfig{}
+		if err := s.CSRF.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "revocation":
+		s.Revocation = &RevocationConfig{}
+		if err := s.Revocation.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "concurrency":
+		if !d.Args(&s.Concurrency) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "strict_curve":
+		s.StrictCurve = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "shared_secret_storage_key":
+		if !d.Args(&s.SharedSecretStorageKey) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "auto_generate_secret":
+		s.AutoGenerateSecret = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "deny_weak_secrets":
+		s.DenyWeakSecrets = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "preset":
+		if !d.Args(&s.Preset) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "vault":
+		s.Vault = &VaultConfig{}
+		if err := s.Vault.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "client_credentials":
+		s.ClientCredentials = &ClientCredentialsConfig{}
+		if err := s.ClientCredentials.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "exchange":
+		s.Exchange = &ExchangeConfig{}
+		if err := s.Exchange.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "handler_name":
+		if !d.Args(&s.Name) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "sign_response":
+		if err := s.parseSignResponseCaddyfile(d); err != nil {
+			return err
+		}
+	case "challenge":
+		s.Challenge = &ChallengeConfig{}
+		if err := s.Challenge.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "allow_caching":
+		s.AllowCaching = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "http2_push":
+		if !d.Args(&s.HTTP2PushTarget) {
+			return d.ArgErr()
+		}
+
+		s.UseHTTP2Push = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "sign_body":
+		s.SignBody = &SignBodyConfig{}
+		if err := s.SignBody.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "export_vars":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+
+		s.ExportVars = append(s.ExportVars, args...)
+	case "skip_if_empty":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+
+		s.SkipIfEmpty = append(s.SkipIfEmpty, args...)
+	case "inject_header":
+		s.InjectHeader = "Authorization"
+		d.Args(&s.InjectHeader)
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "header_prefix":
+		var prefix string
+		if !d.Args(&prefix) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.HeaderPrefix = &prefix
+	case "cookie":
+		s.Cookie = &CookieConfig{}
+		if err := s.Cookie.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "redirect":
+		s.Redirect = &RedirectConfig{}
+		if err := s.Redirect.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "duration_unit":
+		if !d.Args(&s.DurationUnit) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "trim_claims":
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.TrimClaims = true
+	case "strict_placeholders":
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.StrictPlaceholders = true
+	case "min_duration", "max_duration":
+		opt := d.Val()
+
+		var durStr string
+		if !d.Args(&durStr) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		dur, err := caddy.ParseDuration(durStr)
+		if err != nil {
+			return d.Errf("invalid %s %s: %v", opt, durStr, err)
+		}
+
+		if opt == "min_duration" {
+			s.MinDuration = caddy.Duration(dur)
+		} else {
+			s.MaxDuration = caddy.Duration(dur)
+		}
+	case "duration_source":
+		if !d.Args(&s.DurationSource) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "token_cache_ttl":
+		var durStr string
+		if !d.Args(&durStr) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		dur, err := caddy.ParseDuration(durStr)
+		if err != nil {
+			return d.Errf("invalid token_cache_ttl %s: %v", durStr, err)
+		}
+
+		s.TokenCacheTTL = caddy.Duration(dur)
+	case "signing_timeout":
+		var durStr string
+		if !d.Args(&durStr) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
 
-	s.l.Debug("Provisioned", zap.String("duration", s.Dur), zap.Any("claims", s.Claims))
+		dur, err := caddy.ParseDuration(durStr)
+		if err != nil {
+			return d.Errf("invalid signing_timeout %s: %v", durStr, err)
+		}
+
+		s.SigningTimeout = caddy.Duration(dur)
+	case "token_cache_reuse":
+		var pctStr string
+		if !d.Args(&pctStr) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(pctStr, "%"), 64)
+		if err != nil {
+			return d.Errf("invalid token_cache_reuse %s: %v", pctStr, err)
+		}
+
+		s.TokenCacheReuse = pct / 100
+	case "token_cache_size":
+		var sizeStr string
+		if !d.Args(&sizeStr) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			return d.Errf("invalid token_cache_size %s: %v", sizeStr, err)
+		}
+
+		s.TokenCacheSize = size
+	case "algorithm":
+		if !d.Args(&s.Algorithm) {
+			return d.ArgErr()
+		}
+
+		if strings.TrimSpace(s.Algorithm) == "" {
+			return d.Err(errNoneAlgorithm.Error())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "algorithm_allow":
+		algs := d.RemainingArgs()
+		if len(algs) == 0 {
+			return d.ArgErr()
+		}
+
+		s.AlgorithmAllow = append(s.AlgorithmAllow, algs...)
+	case "algorithm_keys":
+		if err := s.parseAlgorithmKeysCaddyfile(d); err != nil {
+			return err
+		}
+	case "respond_json":
+		s.RespondJSON = &RespondJSONConfig{}
+		if err := s.RespondJSON.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "tenant":
+		if err := s.parseTenantCaddyfile(d); err != nil {
+			return err
+		}
+	case "label_claim":
+		if !d.Args(&s.LabelClaim) {
+			return d.ArgErr()
+		}
+
+		d.Args(&s.Label)
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "hash_claim_key":
+		if !d.Args(&s.HashClaimKey) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "hash_claim_encoding":
+		if !d.Args(&s.HashClaimEncoding) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "claims_file":
+		if !d.Args(&s.ClaimsFile) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "request_claims":
+		names := d.RemainingArgs()
+		if len(names) == 0 {
+			return d.ArgErr()
+		}
+
+		s.RequestClaims = append(s.RequestClaims, names...)
+	case "at_hash_from":
+		if !d.Args(&s.AtHashFrom) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "c_hash_from":
+		if !d.Args(&s.CHashFrom) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "request_path_claim":
+		if !d.Args(&s.RequestPathClaim) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "path_prefix_strip":
+		if !d.Args(&s.PathPrefixStrip) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "subject_from_cert":
+		s.SubjectFromCert = &CertSubjectConfig{}
+		if err := s.SubjectFromCert.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "pkce_claim":
+		s.PKCE = &PKCEConfig{}
+		if err := s.PKCE.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "include_query":
+		s.IncludeQuery = &IncludeQueryConfig{}
+		if err := s.IncludeQuery.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "numeric_dates":
+		s.NumericDates = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "timestamp_format":
+		if !d.Args(&s.TimestampFormat) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "output_serialization":
+		if !d.Args(&s.OutputSerialization) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "jwe_algorithm":
+		if !d.Args(&s.JWEAlgorithm) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "jwe_enc":
+		if !d.Args(&s.JWEEnc) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "jwe_recipient_key_file":
+		if !d.Args(&s.JWERecipientKeyFile) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "claims_from_body":
+		s.ClaimsFromBody = &BodyClaimsConfig{}
+		if err := s.ClaimsFromBody.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+	case "body_content_type":
+		if !d.Args(&s.BodyContentType) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "request_body_limit_bytes", "max_body_bytes":
+		limit, err := parseSizeArg(d)
+		if err != nil {
+			return err
+		}
+
+		s.RequestBodyLimitBytes = limit
+	case "audience":
+		if err := s.parseAudienceCaddyfile(d); err != nil {
+			return err
+		}
+	case "claims_schema":
+		if err := s.parseClaimsSchemaCaddyfile(d); err != nil {
+			return err
+		}
+	case "on_schema_error":
+		if !d.Args(&s.OnSchemaError) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "on_missing_claim_source":
+		if !d.Args(&s.OnMissingClaimSource) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "claim_defaults":
+		if err := s.parseClaimDefaultsCaddyfile(d); err != nil {
+			return err
+		}
+	case "nested_claims":
+		if !d.Args(&s.NestedClaims) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "compress_claims":
+		s.CompressClaims = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "audience_always_array":
+		s.AudienceAlwaysArray = true
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "scoped_secrets":
+		if err := s.parseScopedSecretsCaddyfile(d); err != nil {
+			return err
+		}
+	case "previous_secrets":
+		if err := s.parsePreviousSecretsCaddyfile(d); err != nil {
+			return err
+		}
+	case "use_claim_group":
+		names := d.RemainingArgs()
+		if len(names) == 0 {
+			return d.ArgErr()
+		}
+
+		s.UseClaimGroups = append(s.UseClaimGroups, names...)
+	case "claim_aliases":
+		if err := s.parseClaimAliasesCaddyfile(d); err != nil {
+			return err
+		}
+	default:
+		return d.Errf("unknown option: %s", d.Val())
+	}
 
 	return nil
 }
 
-func (s *JwtSigner) Validate() error {
-	vals := map[string]string{
-		"duration": s.Dur,
-		"secret":   s.Secret,
+func (s *JwtSigner) parseSignResponseCaddyfile(d *caddyfile.Dispenser) error {
+	s.SignResponse = true
+
+	if d.NextArg() {
+		s.SignResponseEncoding = d.Val()
 	}
 
-	for key, val := range vals {
-		if val == "" {
-			return fmt.Errorf("missing required parameter: %s", key)
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "encoding":
+			if !d.Args(&s.SignResponseEncoding) {
+				return d.ArgErr()
+			}
+		case "max_size":
+			var sizeStr string
+			if !d.Args(&sizeStr) {
+				return d.ArgErr()
+			}
+
+			size, err := humanize.ParseBytes(sizeStr)
+			if err != nil {
+				return d.Errf("invalid max_size %s: %v", sizeStr, err)
+			}
+
+			s.SignResponseMaxSize = int64(size)
+		default:
+			return d.Errf("unknown sign_response option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
 		}
 	}
 
 	return nil
 }
 
-func (s *JwtSigner) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	s.l.Debug("Run", zap.String("path", r.URL.Path), zap.String("query", r.URL.RawQuery))
+func (s *JwtSigner) parseAudienceCaddyfile(d *caddyfile.Dispenser) error {
+	auds := d.RemainingArgs()
+	if len(auds) == 0 {
+		return d.ArgErr()
+	}
 
-	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
-	if repl == nil {
-		return fmt.Errorf("no replacer found in context")
+	s.Audience = append(s.Audience, auds...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "fan_out":
+			s.FanOut = true
+		default:
+			return d.Errf("unknown audience option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
 	}
 
-	durStr, secret := repl.ReplaceAll(s.Dur, ""), repl.ReplaceAll(s.Secret, "")
+	return nil
+}
 
-	toValidate := map[string]string{
-		"dur":    durStr,
-		"secret": secret,
+func (s *JwtSigner) parseTenantCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&s.Tenant) {
+		return d.ArgErr()
 	}
 
-	for key, val := range toValidate {
-		if val == "" {
-			return fmt.Errorf("required parameter empty after replacements: %s", key)
-		}
+	if d.NextArg() {
+		return d.ArgErr()
 	}
 
-	dur, err := time.ParseDuration(durStr)
-	if err != nil {
-		return fmt.Errorf("invalid duration: %s", durStr)
+	if s.TenantKeys == nil {
+		s.TenantKeys = map[string]*KeyConfig{}
 	}
 
-	s.l.Debug("Parsed duration", zap.String("as_str", durStr), zap.Float64("seconds", dur.Seconds()))
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		tenant := d.Val()
 
-	cs := fillClaims(s.Claims, repl, s.l)
-	if cs == nil {
-		cs = jwt.MapClaims{}
+		kc := &KeyConfig{}
+		if err := kc.unmarshalCaddyfile(d); err != nil {
+			return err
+		}
+
+		s.TenantKeys[tenant] = kc
 	}
 
-	now := time.Now()
-	cs["iat"] = now.Unix()
-	cs["exp"] = now.Add(dur).Unix()
+	return nil
+}
 
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, cs)
+func (s *JwtSigner) parseClaimAliasesCaddyfile(d *caddyfile.Dispenser) error {
+	if s.ClaimAliases == nil {
+		s.ClaimAliases = map[string]string{}
+	}
 
-	tosStr, err := tok.SignedString([]byte(secret))
-	if err != nil {
-		return err
+	var src, dst string
+	if d.Args(&src, &dst) {
+		s.ClaimAliases[src] = dst
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
 	}
 
-	repl.Set("http.jwt_signer.digest_str", tosStr)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		src = d.Val()
+		if !d.Args(&dst) {
+			return d.ArgErr()
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		s.ClaimAliases[src] = dst
+	}
 
-	return next.ServeHTTP(w, r)
+	return nil
 }
 
-func fillClaims(pat jwt.MapClaims, repl *caddy.Replacer, l *zap.Logger) jwt.MapClaims {
+func parseClaimsCaddyfile(d *caddyfile.Dispenser, claims *jwt.MapClaims) error {
 	cs := jwt.MapClaims{}
 
-	for k, v := range pat {
-		switch val := v.(type) {
-		case string:
-			valExpanded := repl.ReplaceAll(val, "")
-			l.Debug("String key expanded", zap.String("key", k), zap.String("value", valExpanded), zap.String("value_config", val))
-			if valExpanded != "" {
-				cs[k] = valExpanded
-			}
-		case map[string]any:
-			l.Debug("Descending into nested map", zap.String("key", k))
-			nested := fillClaims(val, repl, l)
-			if nested != nil {
-				cs[k] = nested
-			}
-		default:
-			l.Debug("Set value of non-obvious type", zap.String("key", k), zap.String("type", fmt.Sprintf("%T", val)))
-			cs[k] = v
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if err := parseClaimCaddyfile(d, cs, nil); err != nil {
+			return err
 		}
 	}
 
-	l.Debug("Finalize current map", zap.Int("length", len(cs)))
-
-	if len(cs) == 0 {
-		return nil
+	if len(cs) > 0 {
+		*claims = cs
 	}
 
-	return cs
+	return nil
 }
 
-func (*JwtSigner) CaddyModule() caddy.ModuleInfo {
-	return caddy.ModuleInfo{
-		ID:  "http.handlers.jwt_signer",
-		New: func() caddy.Module { return new(JwtSigner) },
+// parseClaimCaddyfile parses one claim into cs. The claims taken from elsewhere, env, hash and hmac, are only
+// supported at the top level and go into top, which is nil for nested claims.
+func parseClaimCaddyfile(d *caddyfile.Dispenser, cs jwt.MapClaims, top *JwtSigner) error {
+	key := d.Val()
+	if key == "" {
+		return fmt.Errorf("malformed claims: no key found")
 	}
-}
 
-func (s *JwtSigner) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
-	d.Next() // consume directive name
+	var val string
+	if d.Args(&val) {
+		if val == "" {
+			return fmt.Errorf("malformed claim %s: value is empty", key)
+		}
 
-	if !d.Args(&s.Dur, &s.Secret) {
-		return d.ArgErr()
+		if val == "+=" {
+			return appendClaimCaddyfile(d, cs, key)
+		}
+
+		if val == "env" && d.NextArg() {
+			if top == nil {
+				return d.Errf("env claim %s is only supported at the top level", key)
+			}
+
+			if top.EnvClaims == nil {
+				top.EnvClaims = map[string]string{}
+			}
+
+			top.EnvClaims[key] = d.Val()
+
+			if d.NextArg() {
+				return d.Errf("too many arguments after key: %s", key)
+			}
+
+			return nil
+		}
+
+		if (val == hashClaimHash || val == hashClaimHMAC) && d.NextArg() {
+			if top == nil {
+				return d.Errf("%s claim %s is only supported at the top level", val, key)
+			}
+
+			hc := &HashClaim{Function: val, Algorithm: d.Val()}
+			if !d.Args(&hc.Value) {
+				return d.Errf("%s claim %s requires an algorithm and a value", val, key)
+			}
+
+			if d.NextArg() {
+				return d.Errf("too many arguments after key: %s", key)
+			}
+
+			if top.HashClaims == nil {
+				top.HashClaims = map[string]*HashClaim{}
+			}
+
+			top.HashClaims[key] = hc
+
+			return nil
+		}
+
+		if d.NextArg() {
+			return d.Errf("too many arguments after key: %s", key)
+		}
+
+		cs[key] = val
+		return nil
 	}
 
-	if d.NextArg() {
-		return d.ArgErr()
+	nested, err := parseClaimBlockCaddyfile(d)
+	if err != nil {
+		return d.Errf("nested under key %s: %w", key, err)
 	}
 
-	return parseClaimsCaddyfile(d, &s.Claims)
+	if nested != nil {
+		cs[key] = nested
+		return nil
+	}
+
+	return d.Errf("mailformed claim %s: no value", key)
 }
 
-func parseClaimsCaddyfile(d *caddyfile.Dispenser, claims *jwt.MapClaims) error {
-	cs := jwt.MapClaims{}
+// parseClaimBlockCaddyfile parses the block of a nested claim, an object of claims or, when its entries are
+// "- <value>" or "- { ... }", an array.
+func parseClaimBlockCaddyfile(d *caddyfile.Dispenser) (any, error) {
+	var (
+		cs  = jwt.MapClaims{}
+		arr []any
+	)
 
 	for nesting := d.Nesting(); d.NextBlock(nesting); {
-		key := d.Val()
-		if key == "" {
-			return fmt.Errorf("malformed claims: no key found")
+		if d.Val() != "-" {
+			if err := parseClaimCaddyfile(d, cs, nil); err != nil {
+				return nil, err
+			}
+
+			continue
 		}
 
 		var val string
 		if d.Args(&val) {
 			if val == "" {
-				return fmt.Errorf("malformed claim %s: value is empty", key)
+				return nil, d.Err("malformed array element: value is empty")
 			}
 
 			if d.NextArg() {
-				return d.Errf("too many arguments after key: %s", key)
+				return nil, d.ArgErr()
 			}
 
-			cs[key] = val
+			arr = append(arr, val)
 			continue
 		}
 
-		nested := jwt.MapClaims(nil)
-		if err := parseClaimsCaddyfile(d, &nested); err != nil {
-			return d.Errf("nested under key %s: %w", key, err)
+		el, err := parseClaimBlockCaddyfile(d)
+		if err != nil {
+			return nil, err
 		}
 
-		if nested != nil {
-			cs[key] = nested
-			continue
+		if el == nil {
+			return nil, d.Err("malformed array element: no value")
 		}
 
-		return d.Errf("mailformed claim %s: no value", key)
+		arr = append(arr, el)
 	}
 
-	if len(cs) > 0 {
-		*claims = cs
+	switch {
+	case len(arr) > 0 && len(cs) > 0:
+		return nil, d.Err("a block holds either claims or array elements")
+	case len(arr) > 0:
+		return arr, nil
+	case len(cs) > 0:
+		return cs, nil
+	default:
+		return nil, nil
+	}
+}
+
+// appendClaimCaddyfile parses "<key> += <value>...", appending the values to the array claim so that repeated
+// directives for the same key build one array. A string claim set before becomes the first element.
+func appendClaimCaddyfile(d *caddyfile.Dispenser, cs jwt.MapClaims, key string) error {
+	vals := d.RemainingArgs()
+	if len(vals) == 0 {
+		return d.Errf("malformed claim %s: nothing to append", key)
+	}
+
+	var arr []any
+	switch prev := cs[key].(type) {
+	case nil:
+	case []any:
+		arr = prev
+	case string:
+		arr = []any{prev}
+	default:
+		return d.Errf("cannot append to nested claim %s", key)
+	}
+
+	for _, val := range vals {
+		if val == "" {
+			return d.Errf("malformed claim %s: value is empty", key)
+		}
+
+		arr = append(arr, val)
 	}
 
+	cs[key] = arr
 	return nil
 }
 
 func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	s := JwtSigner{}
-	err := s.UnmarshalCaddyfile(h.Dispenser)
+	if err := s.UnmarshalCaddyfile(h.Dispenser); err != nil {
+		return nil, err
+	}
+
+	err := s.useClaimGroups(h)
 	return &s, err
 }
 
 var (
 	_ caddy.Provisioner           = (*JwtSigner)(nil)
 	_ caddy.Validator             = (*JwtSigner)(nil)
+	_ caddy.CleanerUpper          = (*JwtSigner)(nil)
 	_ caddyhttp.MiddlewareHandler = (*JwtSigner)(nil)
 	_ caddyfile.Unmarshaler       = (*JwtSigner)(nil)
 )
@@ -0,0 +1,563 @@
+package jwt_signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var nopHandler = caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+
+// provisionSigner parses the Caddyfile directive and loads the handler from its JSON like Caddy does, then
+// provisions and validates it. The handler logs nothing once provisioned and is cleaned up with the test.
+func provisionSigner(t testing.TB, cfg string) (*JwtSigner, error) {
+	t.Helper()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+
+	return provisionSignerIn(t, ctx, cfg)
+}
+
+// provisionSignerIn is provisionSigner within the given context, e.g. one providing storage.
+func provisionSignerIn(t testing.TB, ctx caddy.Context, cfg string) (*JwtSigner, error) {
+	t.Helper()
+
+	parsed := &JwtSigner{}
+	if err := parsed.UnmarshalCaddyfile(caddyfile.NewTestDispenser(cfg)); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &JwtSigner{}
+	if err := json.Unmarshal(raw, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Provision(ctx); err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { _ = s.Cleanup() })
+
+	// Caddy logs at debug level by default, which would dominate benchmarks
+	s.l = zap.NewNop()
+
+	return s, s.Validate()
+}
+
+// newTestRequest prepares a request the way Caddy's HTTP server does before handing it to the handler chain.
+func newTestRequest(method, target string, body io.Reader) (*http.Request, *caddy.Replacer) {
+	r := httptest.NewRequest(method, target, body)
+	repl := caddy.NewReplacer()
+
+	ctx := context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl)
+	ctx = context.WithValue(ctx, caddyhttp.VarsCtxKey, map[string]any{})
+	r = r.WithContext(ctx)
+	caddyhttp.PrepareRequest(r, repl, nil, nil)
+
+	return r, repl
+}
+
+// parseTestToken verifies the token the handler signed for the request with testSecret and returns its claims.
+func parseTestToken(t testing.TB, repl *caddy.Replacer) jwt.MapClaims {
+	t.Helper()
+
+	tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+
+	cs := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tosStr, cs, func(*jwt.Token) (any, error) { return []byte(testSecret), nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	return cs
+}
+
+func TestConcurrencySerial(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub {http.request.header.X-User}
+		options {
+			concurrency serial
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			user := fmt.Sprintf("user-%d", i)
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User", user)
+
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				errs <- err
+				return
+			}
+
+			tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+
+			cs := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(tosStr, cs, func(*jwt.Token) (any, error) { return []byte(testSecret), nil }); err != nil {
+				errs <- err
+				return
+			}
+
+			if cs["sub"] != user {
+				errs <- fmt.Errorf("token for %s carries sub %v", user, cs["sub"])
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrencySerialWaitsForLock(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub u
+		options {
+			concurrency serial
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.signMu.Lock()
+
+	done := make(chan error, 1)
+	go func() {
+		r, _ := newTestRequest(http.MethodGet, "/", nil)
+		done <- s.ServeHTTP(httptest.NewRecorder(), r, nopHandler)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("signed while another signature held the lock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.signMu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkServeHTTP signs a token per request for claim trees of increasing weight. Run it with -benchmem, or
+// compare allocs/op with benchstat, to keep allocations on the signing path from creeping back.
+func BenchmarkServeHTTP(b *testing.B) {
+	configs := []struct {
+		name, claims string
+	}{
+		{"no claims", "\n"},
+		{"static claims", `
+			iss https://auth.example.com
+			aud api
+			scope "read write"
+		`},
+		{"representative", benchClaims},
+	}
+
+	for _, c := range configs {
+		s, err := provisionSigner(b, `jwt_signer 15m `+testSecret+` {`+c.claims+`}`)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(c.name, func(b *testing.B) {
+			w := httptest.NewRecorder()
+
+			b.ReportAllocs()
+
+			for b.Loop() {
+				b.StopTimer()
+				r, _ := newBenchRequest()
+				b.StartTimer()
+
+				if err := s.ServeHTTP(w, r, nopHandler); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSignResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		upstream caddyhttp.HandlerFunc
+		status   int
+		payload  any
+		err      int
+	}{
+		{
+			name:     "json",
+			encoding: "json",
+			upstream: func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				_, err := io.WriteString(w, `{"id":7,"ok":1}`)
+				return err
+			},
+			status:  http.StatusCreated,
+			payload: map[string]any{"id": float64(7), "ok": float64(1)},
+		},
+		{
+			name:     "base64url",
+			encoding: "base64url",
+			upstream: func(w http.ResponseWriter, r *http.Request) error {
+				_, err := io.WriteString(w, "plain")
+				return err
+			},
+			status:  http.StatusOK,
+			payload: "cGxhaW4",
+		},
+		{
+			name:     "just under the limit",
+			encoding: "base64url",
+			upstream: func(w http.ResponseWriter, r *http.Request) error {
+				_, err := w.Write(bytes.Repeat([]byte("a"), 16))
+				return err
+			},
+			status:  http.StatusOK,
+			payload: "YWFhYWFhYWFhYWFhYWFhYQ",
+		},
+		{
+			name:     "over the limit",
+			encoding: "base64url",
+			upstream: func(w http.ResponseWriter, r *http.Request) error {
+				// upstreams stop on the first failed write, which must not take the place of the size error
+				for range 3 {
+					if _, err := w.Write(bytes.Repeat([]byte("a"), 10)); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			err: http.StatusBadGateway,
+		},
+		{
+			name:     "encoded body",
+			encoding: "base64url",
+			upstream: func(w http.ResponseWriter, r *http.Request) error {
+				if r.Header.Get("Accept-Encoding") != "" {
+					return fmt.Errorf("upstream asked for %s", r.Header.Get("Accept-Encoding"))
+				}
+
+				w.Header().Set("Content-Encoding", "gzip")
+				_, err := io.WriteString(w, "compressed")
+				return err
+			},
+			err: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				options {
+					sign_response `+tt.encoding+` {
+						max_size 16
+					}
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, _ := newTestRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+
+			err = s.ServeHTTP(w, r, tt.upstream)
+			if tt.err != 0 {
+				var he caddyhttp.HandlerError
+				if !errors.As(err, &he) || he.StatusCode != tt.err {
+					t.Fatalf("error %v, want status %d", err, tt.err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if w.Code != tt.status || w.Header().Get("Content-Type") != "application/jwt" {
+				t.Fatalf("status %d with content type %s", w.Code, w.Header().Get("Content-Type"))
+			}
+
+			cs := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(w.Body.String(), cs, func(*jwt.Token) (any, error) { return []byte(testSecret), nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(cs["payload"], tt.payload) {
+				t.Fatalf("payload %#v, want %#v", cs["payload"], tt.payload)
+			}
+		})
+	}
+}
+
+func TestSignResponsePassesErrorsThrough(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		options {
+			sign_response {
+				max_size 4
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	err = s.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNotFound)
+		_, err := io.WriteString(w, "no such resource")
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound || w.Body.String() != "no such resource" {
+		t.Fatalf("response %d %q", w.Code, w.Body.String())
+	}
+
+	if tosStr, _ := repl.GetString("http.jwt_signer.digest_str"); tosStr != "" {
+		t.Fatalf("signed an error response: %s", tosStr)
+	}
+}
+
+func TestClaimAliases(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		emailVerified {http.request.header.X-Email-Verified}
+		options {
+			request_claims method
+			claim_aliases {
+				emailVerified email_verified
+				method http_method
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, repl := newTestRequest(http.MethodPost, "/", nil)
+	r.Header.Set("X-Email-Verified", "true")
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := parseTestToken(t, repl)
+
+	for _, src := range []string{"emailVerified", "method"} {
+		if v, ok := cs[src]; ok {
+			t.Errorf("source claim %s still present: %v", src, v)
+		}
+	}
+
+	if cs["email_verified"] != "true" || cs["http_method"] != http.MethodPost {
+		t.Fatalf("aliased claims %v", cs)
+	}
+}
+
+func TestValidateAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases map[string]string
+		err     string
+	}{
+		{"chain", map[string]string{"a": "b", "b": "c"}, ""},
+		{"self", map[string]string{"a": "a"}, "points to itself"},
+		{"shared target", map[string]string{"a": "c", "b": "c"}, "both aliased to c"},
+		{"cycle", map[string]string{"a": "b", "b": "c", "c": "a"}, "circular claim alias"},
+		{"empty target", map[string]string{"a": ""}, "both source and target"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAliases(tt.aliases)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateClaimKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			claims: jwt.MapClaims{"sub": "u", "https://example.com/roles": []any{"a"}, "org": map[string]any{"id": 1}},
+		},
+		{
+			name:    "space",
+			claims:  jwt.MapClaims{"user id": "u"},
+			wantErr: `invalid claim key "user id"`,
+		},
+		{
+			name:    "unicode",
+			claims:  jwt.MapClaims{"rôle": "admin"},
+			wantErr: `invalid claim key "rôle"`,
+		},
+		{
+			name:    "control character",
+			claims:  jwt.MapClaims{"role\x07": "admin"},
+			wantErr: `invalid claim key "role\a"`,
+		},
+		{
+			name:    "null byte",
+			claims:  jwt.MapClaims{"role\x00": "admin"},
+			wantErr: `invalid claim key "role\x00"`,
+		},
+		{
+			name:    "empty",
+			claims:  jwt.MapClaims{"": "admin"},
+			wantErr: `invalid claim key ""`,
+		},
+		{
+			name:    "nested",
+			claims:  jwt.MapClaims{"org": map[string]any{"team": map[string]any{"team name": "ops"}}},
+			wantErr: `invalid claim key "org.team.team name"`,
+		},
+		{
+			name:    "nested in an array",
+			claims:  jwt.MapClaims{"orgs": []any{map[string]any{"id": 1}, map[string]any{"the id": 2}}},
+			wantErr: `invalid claim key "orgs.1.the id"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateClaimKeys(tt.claims)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateClaimPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		claims    string
+		conflicts []string
+	}{
+		{
+			name: "siblings",
+			claims: `user.id 7
+				user.name alice
+				org {
+					id acme
+				}
+				org.region eu`,
+		},
+		{
+			name: "dotted path below a plain claim",
+			claims: `user alice
+				user.id 7`,
+			conflicts: []string{"user.id conflicts with user"},
+		},
+		{
+			name: "dotted path repeating a block entry",
+			claims: `user {
+					id 7
+				}
+				user.id 8`,
+			conflicts: []string{"user.id is defined more than once"},
+		},
+		{
+			name: "dotted claim above a block entry",
+			claims: `org.team ops
+				org {
+					team {
+						lead bob
+					}
+				}`,
+			conflicts: []string{"org.team conflicts with org.team.lead"},
+		},
+		{
+			name: "every conflict listed",
+			claims: `a.b x
+				a.b.c y
+				user alice
+				user.id 7
+				user.role admin`,
+			conflicts: []string{
+				"a.b.c conflicts with a.b",
+				"user.id conflicts with user",
+				"user.role conflicts with user",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				`+tt.claims+`
+			}`)
+			if len(tt.conflicts) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if want := "conflicting claim paths: " + strings.Join(tt.conflicts, "; "); err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("error = %v, want %s", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package jwt_signer
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// signingCertHeader reads the certificate of the signing key, optionally followed by its chain, and returns the
+// header fields naming it: the SHA-256 thumbprint as x5t#S256 and, with chain, the certificates as x5c
+// (RFC 7515, sections 4.1.6 and 4.1.8). The certificate has to certify the key.
+func signingCertHeader(path string, key *signingKey, chain bool) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading cert file: %w", err)
+	}
+
+	var certs []*x509.Certificate
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cert file %s: %w", path, err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("cert file %s holds no PEM certificates", path)
+	}
+
+	pub, ok := certs[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(key.verify) {
+		return nil, fmt.Errorf("cert file %s does not certify the signing key", path)
+	}
+
+	thumbprint := sha256.Sum256(certs[0].Raw)
+	header := map[string]any{"x5t#S256": base64.RawURLEncoding.EncodeToString(thumbprint[:])}
+
+	if chain {
+		x5c := make([]string, len(certs))
+		for i, cert := range certs {
+			x5c[i] = base64.StdEncoding.EncodeToString(cert.Raw)
+		}
+
+		header["x5c"] = x5c
+	}
+
+	return header, nil
+}
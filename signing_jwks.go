@@ -0,0 +1,434 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// SigningJWKSConfig signs with the key a JSON Web Key Set holds under a kid, so a federation can distribute its
+// keys from one place. A public key set carries no private material, so this is meant for symmetric (oct) keys or
+// internal endpoints publishing private keys. The key is refreshed in the background; a failed refresh keeps the
+// key fetched before.
+type SigningJWKSConfig struct {
+	URL             string         `json:"url"`
+	KeyID           string         `json:"kid"`
+	RefreshInterval caddy.Duration `json:"refresh_interval,omitempty"`
+	Timeout         caddy.Duration `json:"timeout,omitempty"`
+	CAFile          string         `json:"ca_file,omitempty"`
+
+	// Insecure allows fetching the key over plain http, which exposes it to anyone on the path. Only meant for
+	// endpoints reachable over a trusted link, such as a sidecar on localhost.
+	Insecure bool `json:"insecure,omitempty"`
+
+	poolKey string
+	remote  *remoteSigningKey
+}
+
+// remoteSigningKeys are shared by all configs fetching the same key alike, across config reloads too.
+var remoteSigningKeys = caddy.NewUsagePool()
+
+// remoteSigningKey holds the key fetched from a key set until the last config using it is unloaded.
+type remoteSigningKey struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	url    string
+	kid    string
+	client *http.Client
+	l      *zap.Logger
+
+	fetchMu   sync.Mutex
+	mu        sync.RWMutex
+	key       *signingKey
+	attempted time.Time
+}
+
+func (sj *SigningJWKSConfig) provision(ctx caddy.Context, l *zap.Logger) error {
+	if sj.RefreshInterval == 0 {
+		sj.RefreshInterval = caddy.Duration(defaultJWKSRefreshInterval)
+	}
+
+	if sj.Timeout == 0 {
+		sj.Timeout = caddy.Duration(defaultJWKSTimeout)
+	}
+
+	// checked before the first fetch, which would already expose the key over plain http
+	if err := sj.validate(); err != nil {
+		return err
+	}
+
+	url := caddy.NewReplacer().ReplaceKnown(sj.URL, "")
+	sj.poolKey = fmt.Sprintf("%s|%s|%d|%d|%s", url, sj.KeyID, sj.RefreshInterval, sj.Timeout, sj.CAFile)
+
+	if sj.Insecure && strings.HasPrefix(strings.ToLower(url), "http:") {
+		l.Warn("Fetching the signing key over plain http", zap.String("signing_jwks", url))
+	}
+
+	val, loaded, err := remoteSigningKeys.LoadOrNew(sj.poolKey, func() (caddy.Destructor, error) {
+		client, err := newJWKSClient(sj.CAFile, time.Duration(sj.Timeout))
+		if err != nil {
+			return nil, err
+		}
+
+		rk := &remoteSigningKey{
+			url:    url,
+			kid:    sj.KeyID,
+			client: client,
+			l:      l.With(zap.String("signing_jwks", url), zap.String("kid", sj.KeyID)),
+		}
+		// the first fetch is aborted with the config being loaded, later ones once the last config using the key
+		// is unloaded
+		rk.ctx, rk.cancel = context.WithCancel(context.Background())
+		stop := context.AfterFunc(ctx, rk.cancel)
+
+		rk.fetchMu.Lock()
+		err = rk.fetch()
+		rk.fetchMu.Unlock()
+
+		if !stop() {
+			return nil, ctx.Err()
+		}
+
+		if err != nil {
+			rk.l.Warn("Fetching signing key failed, it will be fetched on demand", zap.Error(err))
+		}
+
+		go rk.refreshLoop(time.Duration(sj.RefreshInterval))
+
+		return rk, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sj.remote = val.(*remoteSigningKey)
+
+	if loaded {
+		l.Debug("Reusing signing key fetched by the previous config", zap.String("signing_jwks", url))
+	}
+
+	return nil
+}
+
+func (rk *remoteSigningKey) Destruct() error {
+	rk.cancel()
+	return nil
+}
+
+func (sj *SigningJWKSConfig) validate() error {
+	if sj.URL == "" {
+		return fmt.Errorf("signing_jwks requires a url")
+	}
+
+	u, err := url.Parse(caddy.NewReplacer().ReplaceKnown(sj.URL, ""))
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("invalid signing_jwks url: %s", sj.URL)
+	}
+
+	if u.Scheme == "http" && !sj.Insecure {
+		return fmt.Errorf("signing_jwks fetches signing keys and requires an https url unless insecure is set: %s", sj.URL)
+	}
+
+	if sj.KeyID == "" {
+		return fmt.Errorf("signing_jwks requires a kid")
+	}
+
+	if sj.RefreshInterval < 0 || sj.Timeout < 0 {
+		return fmt.Errorf("signing_jwks durations must not be negative")
+	}
+
+	return nil
+}
+
+func (sj *SigningJWKSConfig) cleanup() {
+	// released once, the key itself stays usable for requests still in flight
+	if sj.remote != nil && sj.poolKey != "" {
+		_, _ = remoteSigningKeys.Delete(sj.poolKey)
+		sj.poolKey = ""
+	}
+}
+
+func (rk *remoteSigningKey) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rk.ctx.Done():
+			return
+		case <-ticker.C:
+			rk.fetchMu.Lock()
+			err := rk.fetch()
+			rk.fetchMu.Unlock()
+
+			if rk.ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				rk.l.Warn("Refreshing signing key failed, keeping the cached key", zap.Error(err))
+			}
+		}
+	}
+}
+
+// fetch replaces the cached key with the one the key set currently holds under the kid, the caller holds fetchMu.
+func (rk *remoteSigningKey) fetch() error {
+	rk.attempted = time.Now()
+
+	req, err := http.NewRequestWithContext(rk.ctx, http.MethodGet, rk.url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := rk.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks endpoint responded %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return fmt.Errorf("decoding jwks: %w", err)
+	}
+
+	i := slices.IndexFunc(set.Keys, func(k jwk) bool { return k.Kid == rk.kid })
+	if i < 0 {
+		return fmt.Errorf("jwks holds no key %q", rk.kid)
+	}
+
+	key, err := set.Keys[i].signingKey()
+	if err != nil {
+		return fmt.Errorf("key %q: %w", rk.kid, err)
+	}
+
+	if err := key.selfTest(); err != nil {
+		return fmt.Errorf("key %q self-test failed: %w", rk.kid, err)
+	}
+
+	key.header = map[string]any{"kid": rk.kid}
+
+	rk.mu.Lock()
+	rk.key = key
+	rk.mu.Unlock()
+
+	rk.l.Debug("Signing key fetched", zap.String("alg", key.method.Alg()))
+
+	return nil
+}
+
+// current returns the cached key. Until one was fetched, requests retry the fetch at most every jwksMinRefetch.
+func (rk *remoteSigningKey) current() (*signingKey, error) {
+	rk.mu.RLock()
+	key := rk.key
+	rk.mu.RUnlock()
+
+	if key != nil {
+		return key, nil
+	}
+
+	rk.fetchMu.Lock()
+	defer rk.fetchMu.Unlock()
+
+	if rk.key == nil && time.Since(rk.attempted) >= jwksMinRefetch && rk.ctx.Err() == nil {
+		if err := rk.fetch(); err != nil {
+			return nil, fmt.Errorf("fetching signing key: %w", err)
+		}
+	}
+
+	rk.mu.RLock()
+	defer rk.mu.RUnlock()
+
+	if rk.key == nil {
+		return nil, fmt.Errorf("signing key %q not fetched yet", rk.kid)
+	}
+
+	return rk.key, nil
+}
+
+// signingKey builds a key able to sign from a symmetric (oct) key or a private key, honoring the alg it names.
+func (k *jwk) signingKey() (*signingKey, error) {
+	if k.Kty == "oct" {
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("invalid symmetric key")
+		}
+
+		method := jwt.SigningMethod(jwt.SigningMethodHS256)
+		if k.Alg != "" {
+			if method, err = resolveHMACMethod(k.Alg); err != nil {
+				return nil, err
+			}
+		}
+
+		if reason := weakKeyReason(method, secret); reason != "" {
+			return nil, fmt.Errorf("weak key: %s", reason)
+		}
+
+		return hmacKey(method, string(secret)), nil
+	}
+
+	priv, err := k.privateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if reason := weakKeyReason(nil, priv); reason != "" {
+		return nil, fmt.Errorf("weak key: %s", reason)
+	}
+
+	key, err := asymmetricKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.Alg != "" && k.Alg != key.method.Alg() {
+		vk, err := publicVerificationKey(priv.Public())
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(vk.methods, k.Alg) {
+			return nil, fmt.Errorf("algorithm %s does not match the key type", k.Alg)
+		}
+
+		key.method = jwt.GetSigningMethod(k.Alg)
+	}
+
+	return key, nil
+}
+
+func (k *jwk) privateKey() (crypto.Signer, error) {
+	if k.D == "" {
+		return nil, fmt.Errorf("%s key holds no private key material", k.Kty)
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(k.D)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	pub, err := k.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var priv crypto.Signer
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		p, errP := base64.RawURLEncoding.DecodeString(k.P)
+		q, errQ := base64.RawURLEncoding.DecodeString(k.Q)
+		if errP != nil || errQ != nil || len(p) == 0 || len(q) == 0 {
+			return nil, fmt.Errorf("RSA key requires its primes p and q")
+		}
+
+		rk := &rsa.PrivateKey{
+			PublicKey: *pub,
+			D:         new(big.Int).SetBytes(d),
+			Primes:    []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+		}
+		if err := rk.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid RSA key: %w", err)
+		}
+
+		rk.Precompute()
+		priv = rk
+	case *ecdsa.PublicKey:
+		if pub.Curve == secp256k1.S256() {
+			priv = secp256k1.PrivKeyFromBytes(d).ToECDSA()
+			break
+		}
+
+		ek, err := ecdsa.ParseRawPrivateKey(pub.Curve, d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+
+		priv = ek
+	case ed25519.PublicKey:
+		if len(d) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+
+		priv = ed25519.NewKeyFromSeed(d)
+	}
+
+	if !priv.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+		return nil, fmt.Errorf("private key does not match the public key")
+	}
+
+	return priv, nil
+}
+
+func (sj *SigningJWKSConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&sj.URL, &sj.KeyID) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch opt := d.Val(); opt {
+		case "refresh_interval", "timeout":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid signing_jwks %s %s: %v", opt, durStr, err)
+			}
+
+			if opt == "refresh_interval" {
+				sj.RefreshInterval = caddy.Duration(dur)
+			} else {
+				sj.Timeout = caddy.Duration(dur)
+			}
+		case "ca_file":
+			if !d.Args(&sj.CAFile) {
+				return d.ArgErr()
+			}
+		case "insecure":
+			sj.Insecure = true
+		default:
+			return d.Errf("unknown signing_jwks option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
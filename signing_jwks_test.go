@@ -0,0 +1,184 @@
+package jwt_signer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/goleak"
+)
+
+// serveSigningJWKS serves a key set holding a symmetric key under kid k1 over TLS, and returns the path of a CA
+// file trusting the server.
+func serveSigningJWKS(t *testing.T, handler http.HandlerFunc) (*httptest.Server, string) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(handler)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, caFile
+}
+
+func octKeySet(t *testing.T, secret string) []byte {
+	t.Helper()
+
+	set, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "oct",
+		"kid": "k1",
+		"k":   base64.RawURLEncoding.EncodeToString([]byte(secret)),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return set
+}
+
+func TestSigningJWKSCleanupStopsRefresh(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	set := octKeySet(t, strings.Repeat("s", 32))
+
+	var fetches atomic.Int32
+
+	srv, caFile := serveSigningJWKS(t, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(set)
+	})
+	defer srv.Close()
+
+	s := unmarshalSigner(t, `jwt_signer 5m {
+		options {
+			signing_jwks `+srv.URL+` k1 {
+				refresh_interval 10ms
+				ca_file `+caFile+`
+			}
+		}
+	}`)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); fetches.Load() < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("key set fetched %d times, want a refresh", fetches.Load())
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := s.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	srv.Close()
+}
+
+func TestSigningJWKSFetchAbortedWithProvision(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	started := make(chan struct{}, 16)
+
+	srv, caFile := serveSigningJWKS(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	})
+	defer srv.Close()
+
+	s := unmarshalSigner(t, `jwt_signer 5m {
+		options {
+			signing_jwks `+srv.URL+` k1 {
+				timeout 1m
+				ca_file `+caFile+`
+			}
+		}
+	}`)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Provision(ctx) }()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Provision returned %v, want it cancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Provision kept fetching after its context was cancelled")
+	}
+}
+
+func TestSigningJWKSRequiresHTTPS(t *testing.T) {
+	set := octKeySet(t, strings.Repeat("s", 32))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("signing key fetched over plain http")
+		_, _ = w.Write(set)
+	}))
+	defer srv.Close()
+
+	_, err := provisionSigner(t, `jwt_signer 5m {
+		options {
+			signing_jwks `+srv.URL+` k1
+		}
+	}`)
+	if err == nil || !strings.Contains(err.Error(), "requires an https url") {
+		t.Fatalf("plain http accepted: %v", err)
+	}
+}
+
+func TestSigningJWKSInsecure(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+	set := octKeySet(t, secret)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(set)
+	}))
+	defer srv.Close()
+
+	s, err := provisionSigner(t, `jwt_signer 5m {
+		options {
+			signing_jwks `+srv.URL+` k1 {
+				insecure
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := s.key(caddy.NewReplacer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(key.sign.([]byte)) != secret || key.header["kid"] != "k1" {
+		t.Fatalf("fetched key %s with header %v", key.sign, key.header)
+	}
+}
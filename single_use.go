@@ -0,0 +1,137 @@
+package jwt_signer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const defaultConsumedPrefix = "jwt_signer/consumed"
+
+var errTokenUsed = errors.New("token has already been used")
+
+// consumedTokens records the jti of single-use tokens in storage until the tokens expire.
+type consumedTokens struct {
+	storage   certmagic.Storage
+	prefix    string
+	lastSweep atomic.Int64
+}
+
+type consumedEntry struct {
+	Exp int64 `json:"exp"`
+}
+
+func (ct *consumedTokens) key(jti string) string {
+	sum := sha256.Sum256([]byte(jti))
+	return path.Join(ct.prefix, hex.EncodeToString(sum[:]))
+}
+
+// consume marks the token as used, failing if it was used before. The storage lock makes concurrent
+// presentations of the same token race for a single success.
+func (ct *consumedTokens) consume(ctx context.Context, jti string, exp time.Time) error {
+	key := ct.key(jti)
+
+	if err := ct.storage.Lock(ctx, key+".lock"); err != nil {
+		return fmt.Errorf("locking %s: %w", key, err)
+	}
+	defer ct.storage.Unlock(context.WithoutCancel(ctx), key+".lock")
+
+	return ct.record(ctx, jti, exp)
+}
+
+// record marks the token as used without taking the storage lock, so concurrent presentations on different
+// instances may both succeed.
+func (ct *consumedTokens) record(ctx context.Context, jti string, exp time.Time) error {
+	key := ct.key(jti)
+
+	raw, err := ct.storage.Load(ctx, key)
+	switch {
+	case err == nil:
+		var entry consumedEntry
+		if json.Unmarshal(raw, &entry) != nil || entry.Exp >= time.Now().Unix() {
+			return errTokenUsed
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	raw, err = json.Marshal(consumedEntry{Exp: exp.Unix()})
+	if err != nil {
+		return err
+	}
+
+	return ct.storage.Store(ctx, key, raw)
+}
+
+// sweep drops records of tokens which have expired by now, at most once per hour.
+func (ct *consumedTokens) sweep(l *zap.Logger) {
+	now := time.Now()
+
+	last := ct.lastSweep.Load()
+	if now.Sub(time.Unix(0, last)) < time.Hour || !ct.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	go func() {
+		ctx := context.Background()
+
+		keys, err := ct.storage.List(ctx, ct.prefix, false)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				l.Warn("Listing consumed tokens failed", zap.Error(err))
+			}
+
+			return
+		}
+
+		for _, key := range keys {
+			raw, err := ct.storage.Load(ctx, key)
+			if err != nil {
+				continue
+			}
+
+			var entry consumedEntry
+			if json.Unmarshal(raw, &entry) == nil && entry.Exp < now.Unix() {
+				_ = ct.storage.Delete(ctx, key)
+			}
+		}
+	}()
+}
+
+func (s *JwtSigner) consumeToken(w http.ResponseWriter, r *http.Request, tosStr string, cs jwt.MapClaims) error {
+	s.consumed.sweep(s.l)
+
+	jti, _ := cs["jti"].(string)
+	if jti == "" {
+		return s.rejectPresented(w, tosStr, fmt.Errorf("single-use token has no jti"))
+	}
+
+	exp, err := cs.GetExpirationTime()
+	if err != nil || exp == nil {
+		return s.rejectPresented(w, tosStr, fmt.Errorf("single-use token has no usable expiration"))
+	}
+
+	err = s.consumed.consume(r.Context(), jti, exp.Time)
+	if errors.Is(err, errTokenUsed) {
+		s.l.Debug("Single-use token presented again", zap.String("jti", jti))
+		return s.rejectPresented(w, tosStr, err)
+	}
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, fmt.Errorf("recording single-use token: %w", err))
+	}
+
+	return nil
+}
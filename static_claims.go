@@ -0,0 +1,59 @@
+package jwt_signer
+
+import (
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// partitionClaims splits the configured claims into the ones without placeholders, filled once here, and the ones
+// filled per request. A placeholder-free claim which fills to less than configured stays dynamic, so that
+// strict_placeholders keeps reporting it.
+func (s *JwtSigner) partitionClaims() {
+	s.staticClaims, s.dynamicClaims = jwt.MapClaims{}, jwt.MapClaims{}
+
+	repl := caddy.NewReplacer()
+
+	for k, v := range s.Claims {
+		if hasPlaceholder(v) {
+			s.dynamicClaims[k] = v
+			continue
+		}
+
+		pat := jwt.MapClaims{k: v}
+
+		filled := fillClaims(pat, repl, s.TrimClaims, s.NestedClaims, zap.NewNop())
+		if len(emptiedClaims(pat, filled, "")) > 0 {
+			s.dynamicClaims[k] = v
+			continue
+		}
+
+		s.staticClaims[k] = filled[k]
+	}
+
+	s.l.Debug("Partitioned claims", zap.Int("static", len(s.staticClaims)), zap.Int("dynamic", len(s.dynamicClaims)))
+}
+
+// hasPlaceholder reports whether fillClaims would replace anything in the value, looking where it looks.
+func hasPlaceholder(v any) bool {
+	switch val := v.(type) {
+	case string:
+		return strings.Contains(val, "{")
+	case map[string]any:
+		for _, el := range val {
+			if hasPlaceholder(el) {
+				return true
+			}
+		}
+	case []any:
+		for _, el := range val {
+			if hasPlaceholder(el) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,135 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// benchClaims is a claim tree as found in gateway configs: mostly constants with a few values from the request.
+const benchClaims = `
+	iss https://auth.example.com
+	aud api
+	sub {http.request.header.X-User}
+	email {http.request.header.X-Email}
+	org {
+		id acme
+		name "Acme Corp"
+		plan enterprise
+		region eu
+	}
+	roles {
+		- reader
+		- writer
+	}
+	features {
+		billing true
+		export true
+		beta false
+	}
+	client {
+		ip {http.request.remote.host}
+		agent {http.request.header.User-Agent}
+	}
+`
+
+func newBenchRequest() (*http.Request, *caddy.Replacer) {
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "user-1")
+	r.Header.Set("X-Email", "user-1@example.com")
+	r.Header.Set("User-Agent", "bench")
+
+	return r, repl
+}
+
+func TestPartitionClaims(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {`+benchClaims+`
+		empty {http.request.header.X-Missing}
+		half {
+			static yes
+			dynamic {http.request.header.X-Missing}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	static := []string{"iss", "aud", "org", "roles", "features"}
+	dynamic := []string{"sub", "email", "client", "empty", "half"}
+
+	for _, k := range static {
+		if _, ok := s.staticClaims[k]; !ok {
+			t.Errorf("claim %s is not static", k)
+		}
+	}
+
+	for _, k := range dynamic {
+		if _, ok := s.dynamicClaims[k]; !ok {
+			t.Errorf("claim %s is not dynamic", k)
+		}
+	}
+
+	// the partitioned claims fill to what filling the whole tree per request gave, with and without the headers
+	_, withHeaders := newBenchRequest()
+	_, withoutHeaders := newTestRequest(http.MethodGet, "/", nil)
+
+	for _, repl := range []*caddy.Replacer{withHeaders, withoutHeaders} {
+		want := fillClaims(s.Claims, repl, s.TrimClaims, s.NestedClaims, zap.NewNop())
+
+		got := jwt.MapClaims{}
+		for k, v := range s.staticClaims {
+			got[k] = v
+		}
+
+		filled, err := s.fillClaims(s.dynamicClaims, repl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k, v := range filled {
+			got[k] = v
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("partitioned claims = %v, want %v", got, want)
+		}
+	}
+}
+
+// BenchmarkFillClaims compares filling the whole claim tree per request with filling only its dynamic part and
+// copying the static one, as buildClaims does.
+func BenchmarkFillClaims(b *testing.B) {
+	s, err := provisionSigner(b, `jwt_signer 5m `+testSecret+` {`+benchClaims+`}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	_, repl := newBenchRequest()
+
+	b.Run("whole", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for b.Loop() {
+			_ = fillClaims(s.Claims, repl, s.TrimClaims, s.NestedClaims, s.l)
+		}
+	})
+
+	b.Run("partitioned", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for b.Loop() {
+			cs := make(jwt.MapClaims, len(s.staticClaims)+len(s.dynamicClaims))
+			for k, v := range s.staticClaims {
+				cs[k] = v
+			}
+
+			for k, v := range fillClaims(s.dynamicClaims, repl, s.TrimClaims, s.NestedClaims, s.l) {
+				cs[k] = v
+			}
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package jwt_signer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+const defaultSigningTimeout = 5 * time.Second
+
+var errSigningTimeout = errors.New("signing timed out")
+
+// upstreamContext hands the following handlers the values added while signing, but the cancellation of the
+// original request instead of the signing timeout.
+type upstreamContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c upstreamContext) Value(key any) any {
+	return c.values.Value(key)
+}
+
+// waitsOnBackend tells whether the handler may wait on something outside the process while signing: Vault, remote
+// key sets or storage. Only then does signing_timeout apply by default, sparing other handlers its timer.
+func (s *JwtSigner) waitsOnBackend() bool {
+	remoteKeys := s.SigningJWKS != nil || s.Verify != nil && s.Verify.JWKS != nil || s.Exchange != nil && s.Exchange.JWKS != nil
+	storage := s.Rotation != nil || s.Revocation != nil ||
+		s.Verify != nil && (s.Verify.SingleUse || s.Verify.ReplayProtection != nil) ||
+		s.Exchange != nil && (s.Exchange.SingleUse || s.Exchange.ReplayProtection != nil)
+
+	return s.Vault != nil || remoteKeys || storage
+}
+
+// serveWithTimeout bounds the handler's own work by signing_timeout, answering 503 when it runs out. The clock is
+// stopped while the following handlers run, so a slow upstream does not eat into the budget of modes which sign
+// its response.
+func (s *JwtSigner) serveWithTimeout(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	orig := r.Context()
+
+	ctx, cancel := context.WithCancelCause(orig)
+	defer cancel(nil)
+
+	budget, started := time.Duration(s.SigningTimeout), time.Now()
+
+	timer := time.AfterFunc(budget, func() { cancel(errSigningTimeout) })
+	defer timer.Stop()
+
+	wrapped := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if !timer.Stop() {
+			return context.Cause(ctx)
+		}
+
+		budget -= time.Since(started)
+
+		err := next.ServeHTTP(w, r.WithContext(upstreamContext{Context: orig, values: r.Context()}))
+
+		started = time.Now()
+		timer.Reset(budget)
+
+		return err
+	})
+
+	err := s.serve(w, r.WithContext(ctx), wrapped)
+	if err != nil && errors.Is(context.Cause(ctx), errSigningTimeout) {
+		s.l.Warn("Signing timed out", zap.Duration("timeout", time.Duration(s.SigningTimeout)), zap.Error(err))
+		return caddyhttp.Error(http.StatusServiceUnavailable, errSigningTimeout)
+	}
+
+	return err
+}
@@ -0,0 +1,133 @@
+package jwt_signer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// stallingStorage never answers, like storage behind a hung network mount, until the caller gives up.
+type stallingStorage struct {
+	*memStorage
+}
+
+func (stallingStorage) Lock(ctx context.Context, _ string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (stallingStorage) Load(ctx context.Context, _ string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stallingStorage) Store(ctx context.Context, _ string, _ []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSigningTimeoutDefault(t *testing.T) {
+	tests := []struct {
+		name, options string
+		want          time.Duration
+	}{
+		{name: "signing in memory", options: `trim_claims`},
+		{name: "set explicitly", options: `signing_timeout 2s`, want: 2 * time.Second},
+		{
+			name: "single-use tokens",
+			options: `verify {
+					single_use
+				}`,
+			want: defaultSigningTimeout,
+		},
+		{
+			name: "rotated refresh tokens",
+			options: `rotate_refresh_tokens {
+					from header X-Refresh
+				}`,
+			want: defaultSigningTimeout,
+		},
+		{
+			name: "rotated refresh tokens with a timeout of their own",
+			options: `rotate_refresh_tokens {
+					from header X-Refresh
+				}
+				signing_timeout 30s`,
+			want: 30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := provisionSignerIn(t, storageContext(t), `jwt_signer 5m `+testSecret+` {
+				sub alice
+				options {
+					`+tt.options+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := time.Duration(s.SigningTimeout); got != tt.want {
+				t.Fatalf("signing_timeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigningTimeout(t *testing.T) {
+	s := rotatingSigner(t, "1h")
+	s.Rotation.storage = stallingStorage{newMemStorage()}
+	s.SigningTimeout = caddy.Duration(50 * time.Millisecond)
+
+	started := time.Now()
+
+	if _, status := rotateToken(t, s, ""); status != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", status)
+	}
+
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("timed out after %v", elapsed)
+	}
+}
+
+func TestSigningTimeoutExcludesNext(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub alice
+		options {
+			signing_timeout 20ms
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	})
+
+	r, repl := newTestRequest(http.MethodGet, "/", nil)
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, slow); err != nil {
+		var he caddyhttp.HandlerError
+		if errors.As(err, &he) {
+			t.Fatalf("status %d while the next handler ran", he.StatusCode)
+		}
+		t.Fatal(err)
+	}
+
+	if cs := parseTestToken(t, repl); cs["sub"] != "alice" {
+		t.Fatalf("sub = %v, want alice", cs["sub"])
+	}
+}
@@ -0,0 +1,97 @@
+package jwt_signer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampFormat(t *testing.T) {
+	tests := []struct {
+		name, format, layout string
+	}{
+		{name: "default"},
+		{name: "unix", format: "unix"},
+		{name: "rfc3339", format: "rfc3339", layout: time.RFC3339},
+		{name: "rfc3339nano", format: "rfc3339nano", layout: time.RFC3339Nano},
+		{name: "layout", format: `"2006-01-02 15:04:05"`, layout: "2006-01-02 15:04:05"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := ""
+			if tt.format != "" {
+				options = "timestamp_format " + tt.format
+			}
+
+			s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+				sub alice
+				options {
+					`+options+`
+				}
+			}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, repl := newTestRequest(http.MethodGet, "/", nil)
+
+			if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+				t.Fatal(err)
+			}
+
+			// string timestamps do not pass the validation of a JWT parser, so the payload is read as it is
+			tosStr, _ := repl.GetString("http.jwt_signer.digest_str")
+
+			parts := strings.Split(tosStr, ".")
+			if len(parts) != 3 {
+				t.Fatalf("token %q is not compact", tosStr)
+			}
+
+			raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var payload map[string]any
+			if err := json.Unmarshal(raw, &payload); err != nil {
+				t.Fatal(err)
+			}
+
+			var iat, exp time.Time
+			if tt.layout == "" {
+				iatNum, iatOK := payload["iat"].(float64)
+				expNum, expOK := payload["exp"].(float64)
+				if !iatOK || !expOK {
+					t.Fatalf("iat %#v and exp %#v, want numbers", payload["iat"], payload["exp"])
+				}
+
+				iat, exp = time.Unix(int64(iatNum), 0), time.Unix(int64(expNum), 0)
+			} else {
+				iatStr, iatOK := payload["iat"].(string)
+				expStr, expOK := payload["exp"].(string)
+				if !iatOK || !expOK {
+					t.Fatalf("iat %#v and exp %#v, want strings", payload["iat"], payload["exp"])
+				}
+
+				if iat, err = time.Parse(tt.layout, iatStr); err != nil {
+					t.Fatal(err)
+				}
+				if exp, err = time.Parse(tt.layout, expStr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if lifetime := exp.Sub(iat); lifetime != 5*time.Minute {
+				t.Fatalf("lifetime %s, want 5m", lifetime)
+			}
+			if since := time.Since(iat); since < -time.Second || since > time.Minute {
+				t.Fatalf("iat %s is not now", iat)
+			}
+		})
+	}
+}
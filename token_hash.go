@@ -0,0 +1,71 @@
+package jwt_signer
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenHashFunc picks the hash of an OIDC at_hash or c_hash: the one used by the signing algorithm, SHA-512 for
+// EdDSA with Ed25519 keys.
+func tokenHashFunc(method jwt.SigningMethod) (crypto.Hash, error) {
+	alg := method.Alg()
+
+	switch {
+	case alg == jwt.SigningMethodEdDSA.Alg():
+		return crypto.SHA512, nil
+	case strings.HasSuffix(alg, "256"), alg == "ES256K":
+		return crypto.SHA256, nil
+	case strings.HasSuffix(alg, "384"):
+		return crypto.SHA384, nil
+	case strings.HasSuffix(alg, "512"):
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("no token hash defined for algorithm %s", alg)
+	}
+}
+
+// tokenHash computes an OIDC at_hash or c_hash: the left-most half of the hash of the value, base64url encoded
+// without padding.
+func tokenHash(method jwt.SigningMethod, val string) (string, error) {
+	h, err := tokenHashFunc(method)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := h.New()
+	hasher.Write([]byte(val))
+	sum := hasher.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// addTokenHashes adds at_hash and c_hash for the access token and authorization code given by at_hash_from and
+// c_hash_from, a value resolving empty leaves its claim out.
+func (s *JwtSigner) addTokenHashes(method jwt.SigningMethod, repl *caddy.Replacer, cs jwt.MapClaims) error {
+	for claim, from := range map[string]string{"at_hash": s.AtHashFrom, "c_hash": s.CHashFrom} {
+		if from == "" {
+			continue
+		}
+
+		val := repl.ReplaceAll(from, "")
+		if val == "" {
+			continue
+		}
+
+		hash, err := tokenHash(method, val)
+		if err != nil {
+			return err
+		}
+
+		cs[claim] = hash
+	}
+
+	return nil
+}
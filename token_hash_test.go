@@ -0,0 +1,102 @@
+package jwt_signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The access token and code of the OpenID Connect Core 1.0 examples (appendix A.3, A.4 and A.6) with their
+// at_hash and c_hash for SHA-256. The spec gives no examples for the longer hashes; those halves were computed
+// with an independent implementation.
+const (
+	oidcAccessToken = "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"
+	oidcCode        = "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk"
+)
+
+func TestTokenHash(t *testing.T) {
+	var (
+		sha256 = [2]string{"77QmUPtjPfzWtF2AnpK9RQ", "LDktKdoQak3Pk0cnXxCltA"}
+		sha384 = [2]string{"jtAeDp945y1dDqU3nkIVGNZP1HjH_MFs", "Mq-knyaEMtWGfnBi2POEZb1kiLx10_DF"}
+		sha512 = [2]string{"q7nS86GgvvFaZkzALLWqJYaJIKw2wCDAVfCAsm5CrBM", "E9z1C-c0Az4eTEzE0Nm3OQ3BS2BhMgxuP7x5JAQj1_4"}
+	)
+
+	tests := []struct {
+		method jwt.SigningMethod
+		want   [2]string
+	}{
+		{jwt.SigningMethodHS256, sha256},
+		{jwt.SigningMethodRS256, sha256},
+		{jwt.SigningMethodPS256, sha256},
+		{jwt.SigningMethodES256, sha256},
+		{signingMethodES256K, sha256},
+		{jwt.SigningMethodHS384, sha384},
+		{jwt.SigningMethodRS384, sha384},
+		{jwt.SigningMethodPS384, sha384},
+		{jwt.SigningMethodES384, sha384},
+		{jwt.SigningMethodHS512, sha512},
+		{jwt.SigningMethodRS512, sha512},
+		{jwt.SigningMethodPS512, sha512},
+		{jwt.SigningMethodES512, sha512},
+		// EdDSA with Ed25519 keys hashes with SHA-512, the hash Ed25519 signs with
+		{jwt.SigningMethodEdDSA, sha512},
+	}
+
+	for _, tt := range tests {
+		for i, val := range []string{oidcAccessToken, oidcCode} {
+			got, err := tokenHash(tt.method, val)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.method.Alg(), err)
+			}
+
+			if got != tt.want[i] {
+				t.Errorf("%s: hash of %s = %s, want %s", tt.method.Alg(), val, got, tt.want[i])
+			}
+		}
+	}
+
+	if _, err := tokenHash(jwt.SigningMethodNone, oidcAccessToken); err == nil {
+		t.Error("hashed for alg none")
+	}
+}
+
+func TestTokenHashClaims(t *testing.T) {
+	s, err := provisionSigner(t, `jwt_signer 5m `+testSecret+` {
+		sub u
+		options {
+			at_hash_from {http.request.header.X-Access-Token}
+			c_hash_from {http.request.uri.query.code}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, repl := newTestRequest(http.MethodGet, "/?code="+oidcCode, nil)
+	r.Header.Set("X-Access-Token", oidcAccessToken)
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := parseTestToken(t, repl)
+	if cs["at_hash"] != "77QmUPtjPfzWtF2AnpK9RQ" || cs["c_hash"] != "LDktKdoQak3Pk0cnXxCltA" {
+		t.Fatalf("at_hash = %v, c_hash = %v", cs["at_hash"], cs["c_hash"])
+	}
+
+	r, repl = newTestRequest(http.MethodGet, "/", nil)
+
+	if err := s.ServeHTTP(httptest.NewRecorder(), r, nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	cs = parseTestToken(t, repl)
+	if _, ok := cs["at_hash"]; ok {
+		t.Errorf("at_hash set without an access token: %v", cs["at_hash"])
+	}
+	if _, ok := cs["c_hash"]; ok {
+		t.Errorf("c_hash set without a code: %v", cs["c_hash"])
+	}
+}
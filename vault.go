@@ -0,0 +1,176 @@
+package jwt_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const defaultVaultTimeout = 10 * time.Second
+
+// VaultConfig has tokens issued by the identity token endpoint of Vault or OpenBao instead of being signed
+// locally. The claims and lifetime are those of the Vault role, the configured claims are not used.
+type VaultConfig struct {
+	Address   string         `json:"address"`
+	Token     string         `json:"token"`
+	Namespace string         `json:"namespace,omitempty"`
+	Role      string         `json:"role"`
+	Timeout   caddy.Duration `json:"timeout,omitempty"`
+
+	client *http.Client
+}
+
+type vaultTokenResponse struct {
+	Data struct {
+		Token string `json:"token"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (vc *VaultConfig) provision() {
+	if vc.Timeout == 0 {
+		vc.Timeout = caddy.Duration(defaultVaultTimeout)
+	}
+
+	vc.client = &http.Client{Timeout: time.Duration(vc.Timeout)}
+}
+
+func (vc *VaultConfig) validate() error {
+	if vc.Address == "" || vc.Token == "" || vc.Role == "" {
+		return fmt.Errorf("vault requires address, token and role")
+	}
+
+	if !strings.Contains(vc.Address, "{") {
+		if u, err := url.Parse(vc.Address); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid vault address: %s", vc.Address)
+		}
+	}
+
+	if vc.Timeout < 0 {
+		return fmt.Errorf("vault timeout must not be negative")
+	}
+
+	return nil
+}
+
+// issue requests a token for the role from the identity token endpoint.
+func (vc *VaultConfig) issue(r *http.Request, repl *caddy.Replacer) (string, error) {
+	role := repl.ReplaceAll(vc.Role, "")
+	if role == "" {
+		return "", fmt.Errorf("required parameter empty after replacements: vault role")
+	}
+
+	endpoint := strings.TrimRight(repl.ReplaceAll(vc.Address, ""), "/") + "/v1/identity/oidc/token/" + url.PathEscape(role)
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("X-Vault-Token", repl.ReplaceAll(vc.Token, ""))
+	if vc.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", repl.ReplaceAll(vc.Namespace, ""))
+	}
+
+	resp, err := vc.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body vaultTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, defaultRequestBodyLimit)).Decode(&body); err != nil {
+		return "", fmt.Errorf("vault responded %d with an unreadable body: %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded %d: %s", resp.StatusCode, strings.Join(body.Errors, "; "))
+	}
+
+	if body.Data.Token == "" {
+		return "", fmt.Errorf("vault response carries no token")
+	}
+
+	return body.Data.Token, nil
+}
+
+func (s *JwtSigner) serveVault(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	tosStr, err := s.Vault.issue(r, repl)
+	if err != nil {
+		s.l.Error("Requesting token from vault failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("requesting token from vault: %w", err))
+	}
+
+	// the token comes from a trusted issuer over an authenticated channel, the claims are only read for delivery
+	cs := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tosStr, cs); err != nil {
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("vault returned a malformed token: %w", err))
+	}
+
+	for _, k := range []string{"iat", "exp"} {
+		if v, ok := cs[k].(float64); ok {
+			cs[k] = int64(v)
+		}
+	}
+
+	s.l.Debug("Token issued by vault", zap.Int("claims", len(cs)))
+
+	return s.output(w, r, repl, next, tosStr, cs)
+}
+
+func (vc *VaultConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&vc.Address) {
+		return d.ArgErr()
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "token":
+			if !d.Args(&vc.Token) {
+				return d.ArgErr()
+			}
+		case "namespace":
+			if !d.Args(&vc.Namespace) {
+				return d.ArgErr()
+			}
+		case "role":
+			if !d.Args(&vc.Role) {
+				return d.ArgErr()
+			}
+		case "timeout":
+			var durStr string
+			if !d.Args(&durStr) {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(durStr)
+			if err != nil {
+				return d.Errf("invalid vault timeout %s: %v", durStr, err)
+			}
+
+			vc.Timeout = caddy.Duration(dur)
+		default:
+			return d.Errf("unknown vault option: %s", d.Val())
+		}
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,473 @@
+package jwt_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	expectIssuer   = "issuer"
+	expectAudience = "audience"
+	expectType     = "type"
+)
+
+const (
+	tokenFromHeader = "header"
+	tokenFromCookie = "cookie"
+	tokenFromQuery  = "query"
+)
+
+type VerifyConfig struct {
+	From     string         `json:"from,omitempty"`
+	Name     string         `json:"name,omitempty"`
+	Leeway   caddy.Duration `json:"leeway,omitempty"`
+	Issuer   string         `json:"issuer,omitempty"`
+	Audience []string       `json:"audience,omitempty"`
+	Type     string         `json:"type,omitempty"`
+
+	// Scopes are required in the space-separated scope claim or the scp array, a token lacking any is refused
+	// with 403.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Optional lists expectations (issuer, audience, type) a token may also meet by leaving out the claim or
+	// header altogether, a present value still has to match.
+	Optional []string `json:"optional,omitempty"`
+
+	SingleUse bool `json:"single_use,omitempty"`
+
+	// ReplayProtection rejects tokens presenting a nonce seen before.
+	ReplayProtection *ReplayConfig `json:"replay_protection,omitempty"`
+
+	// JWKS verifies tokens against the keys published by an external identity provider instead of a local key.
+	JWKS *JWKSConfig `json:"jwks,omitempty"`
+
+	// DPoP accepts DPoP-bound tokens with the DPoP scheme and a proof by the bound key.
+	DPoP *DPoPConfig `json:"dpop,omitempty"`
+}
+
+func (vc *VerifyConfig) provision() {
+	if vc.From == "" {
+		vc.From = tokenFromHeader
+	}
+
+	if vc.Name == "" && vc.From == tokenFromHeader {
+		vc.Name = "Authorization"
+	}
+
+	if vc.DPoP != nil {
+		vc.DPoP.provision()
+	}
+}
+
+func (vc *VerifyConfig) validate() error {
+	switch vc.From {
+	case tokenFromHeader, tokenFromCookie, tokenFromQuery:
+	default:
+		return fmt.Errorf("unknown verify token source: %s", vc.From)
+	}
+
+	if vc.Name == "" {
+		return fmt.Errorf("verify token source %s requires a name", vc.From)
+	}
+
+	if vc.Leeway < 0 {
+		return fmt.Errorf("verify leeway must not be negative")
+	}
+
+	for _, check := range vc.Optional {
+		switch check {
+		case expectIssuer, expectAudience, expectType:
+		default:
+			return fmt.Errorf("unknown verify expectation: %s", check)
+		}
+	}
+
+	if vc.JWKS != nil {
+		if err := vc.JWKS.validate(); err != nil {
+			return err
+		}
+	}
+
+	if vc.ReplayProtection != nil {
+		if err := vc.ReplayProtection.validate(); err != nil {
+			return err
+		}
+	}
+
+	if vc.DPoP != nil {
+		if err := vc.DPoP.validate(); err != nil {
+			return err
+		}
+
+		if vc.From != tokenFromHeader || !strings.EqualFold(vc.Name, "Authorization") {
+			return fmt.Errorf("dpop requires tokens from the Authorization header")
+		}
+	}
+
+	return nil
+}
+
+// token extracts the presented token, an Authorization header has to use the Bearer scheme.
+func (vc *VerifyConfig) token(r *http.Request) string {
+	switch vc.From {
+	case tokenFromCookie:
+		if cookie, err := r.Cookie(vc.Name); err == nil {
+			return cookie.Value
+		}
+
+		return ""
+	case tokenFromQuery:
+		return r.URL.Query().Get(vc.Name)
+	default:
+		val := r.Header.Get(vc.Name)
+		if !strings.EqualFold(vc.Name, "Authorization") {
+			return val
+		}
+
+		return schemeToken(val, vc.schemes()...)
+	}
+}
+
+// schemes lists the accepted Authorization schemes, DPoP-bound tokens come with their own.
+func (vc *VerifyConfig) schemes() []string {
+	if vc.DPoP != nil {
+		return []string{"Bearer", dpopScheme}
+	}
+
+	return []string{"Bearer"}
+}
+
+// scheme returns the Authorization scheme the token was presented with.
+func (vc *VerifyConfig) scheme(r *http.Request) string {
+	scheme, _, _ := strings.Cut(strings.TrimSpace(r.Header.Get(vc.Name)), " ")
+	return scheme
+}
+
+// bearerToken extracts the token from an Authorization header value of the Bearer scheme.
+func bearerToken(val string) string {
+	return schemeToken(val, "Bearer")
+}
+
+func schemeToken(val string, schemes ...string) string {
+	scheme, tok, ok := strings.Cut(val, " ")
+	if !ok || !slices.ContainsFunc(schemes, func(s string) bool { return strings.EqualFold(scheme, s) }) {
+		return ""
+	}
+
+	return strings.TrimSpace(tok)
+}
+
+func (vc *VerifyConfig) parserOptions() []jwt.ParserOption {
+	return []jwt.ParserOption{jwt.WithLeeway(time.Duration(vc.Leeway))}
+}
+
+// expect checks the issuer, audience and type expectations of a verified token. The error names the failed
+// expectation for the log, clients only ever see a generic invalid_token.
+func (vc *VerifyConfig) expect(tosStr string, cs jwt.MapClaims) error {
+	if vc.Issuer != "" {
+		iss, ok := cs["iss"]
+		switch {
+		case !ok && slices.Contains(vc.Optional, expectIssuer):
+		case !ok:
+			return fmt.Errorf("expected issuer: token has no iss claim")
+		case iss != vc.Issuer:
+			return fmt.Errorf("expected issuer: token issuer %v is not %s", iss, vc.Issuer)
+		}
+	}
+
+	if len(vc.Audience) > 0 {
+		auds, err := cs.GetAudience()
+		switch {
+		case err != nil:
+			return fmt.Errorf("expected audience: %w", err)
+		case len(auds) == 0 && slices.Contains(vc.Optional, expectAudience):
+		case len(auds) == 0:
+			return fmt.Errorf("expected audience: token has no aud claim")
+		case !slices.ContainsFunc(auds, func(aud string) bool { return slices.Contains(vc.Audience, aud) }):
+			return fmt.Errorf("expected audience: token audience %v contains none of %v", []string(auds), vc.Audience)
+		}
+	}
+
+	if vc.Type != "" {
+		var typ string
+		if tok, _, err := jwt.NewParser().ParseUnverified(tosStr, jwt.MapClaims{}); err == nil {
+			typ, _ = tok.Header["typ"].(string)
+		}
+
+		switch {
+		case typ == "" && slices.Contains(vc.Optional, expectType):
+		case !sameMediaType(typ, vc.Type):
+			return fmt.Errorf("expected type: token type %q is not %s", typ, vc.Type)
+		}
+	}
+
+	return nil
+}
+
+// sameMediaType compares typ values case-insensitively, with the "application/" prefix being optional as
+// RFC 7515 section 4.1.9 recommends.
+func sameMediaType(a, b string) bool {
+	trim := func(v string) string {
+		v = strings.ToLower(v)
+		return strings.TrimPrefix(v, "application/")
+	}
+
+	return trim(a) == trim(b)
+}
+
+func (s *JwtSigner) serveVerify(w http.ResponseWriter, r *http.Request, repl *caddy.Replacer, next caddyhttp.Handler) error {
+	tosStr := s.Verify.token(r)
+	if tosStr == "" || s.Verify.presentedMalformed(r) {
+		return s.rejectPresented(w, "", s.Verify.missingToken(r))
+	}
+
+	var (
+		cs  jwt.MapClaims
+		err error
+	)
+
+	if s.Verify.JWKS != nil {
+		cs, err = s.Verify.JWKS.parse(tosStr, s.Verify.parserOptions()...)
+	} else {
+		cs, err = s.parse(tosStr, repl, s.Verify.parserOptions()...)
+	}
+	if err == nil {
+		err = s.Verify.expect(tosStr, cs)
+	}
+	if err == nil {
+		err = s.checkRevoked(cs)
+	}
+	if err == nil {
+		err = s.Verify.checkScopes(cs)
+	}
+	if err == nil {
+		err = s.Verify.checkDPoP(r, tosStr, cs)
+	}
+	if err != nil {
+		return s.rejectPresented(w, tosStr, err)
+	}
+
+	if s.Verify.SingleUse {
+		if err := s.consumeToken(w, r, tosStr, cs); err != nil {
+			return err
+		}
+	}
+
+	if s.Verify.ReplayProtection != nil {
+		if err := s.checkNonce(w, r, s.Verify.ReplayProtection, tosStr, cs); err != nil {
+			return err
+		}
+	}
+
+	s.l.Debug("Token verified", zap.Int("claims", len(cs)))
+
+	s.setClaimPlaceholders(repl, cs)
+	s.exportVars(r, cs)
+
+	if s.AuthEndpoint != nil {
+		return s.serveAuthEndpoint(w, repl)
+	}
+
+	return next.ServeHTTP(w, withClaims(r, cs))
+}
+
+// claimSet holds verified claims in the replacer, it renders as the JSON of the whole set.
+type claimSet jwt.MapClaims
+
+func (cs claimSet) String() string {
+	return claimString(map[string]any(cs))
+}
+
+// setClaimPlaceholders exposes verified claims as {http.jwt_signer.claims.<path>}, and also under
+// {http.jwt_signer.<name>.claims.<path>} for a named instance. Paths are resolved lazily when a placeholder is used.
+func (s *JwtSigner) setClaimPlaceholders(repl *caddy.Replacer, cs jwt.MapClaims) {
+	_, mapped := repl.Get("http.jwt_signer.claims")
+
+	repl.Set("http.jwt_signer.claims", claimSet(cs))
+	if s.Name != "" {
+		repl.Set("http.jwt_signer."+s.Name+".claims", claimSet(cs))
+	}
+
+	if mapped {
+		return
+	}
+
+	repl.Map(func(key string) (any, bool) {
+		if !strings.HasPrefix(key, "http.jwt_signer.") {
+			return nil, false
+		}
+
+		root, path, ok := strings.Cut(key, ".claims.")
+		if !ok {
+			return nil, false
+		}
+
+		val, _ := repl.Get(root + ".claims")
+		set, ok := val.(claimSet)
+		if !ok {
+			return nil, false
+		}
+
+		v, found := lookupClaim(map[string]any(set), path)
+		if !found {
+			return "", true
+		}
+
+		return claimString(v), true
+	})
+}
+
+// lookupClaim walks a dotted path through nested objects and arrays, numeric segments index arrays.
+func lookupClaim(cs map[string]any, path string) (any, bool) {
+	var cur any = cs
+
+	for _, seg := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]any:
+			v, ok := node[seg]
+			if !ok {
+				return nil, false
+			}
+
+			cur = v
+		case jwt.MapClaims:
+			v, ok := node[seg]
+			if !ok {
+				return nil, false
+			}
+
+			cur = v
+		case []any:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+
+			cur = node[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+func claimString(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		out, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+
+		return string(out)
+	}
+}
+
+func (vc *VerifyConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if err := vc.unmarshalOption(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (vc *VerifyConfig) unmarshalOption(d *caddyfile.Dispenser) error {
+	switch d.Val() {
+	case "from":
+		if !d.Args(&vc.From) {
+			return d.ArgErr()
+		}
+
+		d.Args(&vc.Name)
+	case "leeway":
+		var durStr string
+		if !d.Args(&durStr) {
+			return d.ArgErr()
+		}
+
+		dur, err := caddy.ParseDuration(durStr)
+		if err != nil {
+			return d.Errf("invalid leeway %s: %v", durStr, err)
+		}
+
+		vc.Leeway = caddy.Duration(dur)
+	case "issuer", "expect_issuer":
+		if !d.Args(&vc.Issuer) {
+			return d.ArgErr()
+		}
+	case "expect_type":
+		if !d.Args(&vc.Type) {
+			return d.ArgErr()
+		}
+	case "single_use":
+		vc.SingleUse = true
+	case "jwks":
+		vc.JWKS = &JWKSConfig{}
+		return vc.JWKS.unmarshalCaddyfile(d)
+	case "replay_protection":
+		vc.ReplayProtection = &ReplayConfig{}
+		return vc.ReplayProtection.unmarshalCaddyfile(d)
+	case "dpop":
+		vc.DPoP = &DPoPConfig{}
+		return vc.DPoP.unmarshalCaddyfile(d)
+	case "audience", "expect_audience":
+		auds := d.RemainingArgs()
+		if len(auds) == 0 {
+			return d.ArgErr()
+		}
+
+		vc.Audience = append(vc.Audience, auds...)
+
+		return nil
+	case "require_scope":
+		scopes := d.RemainingArgs()
+		if len(scopes) == 0 {
+			return d.ArgErr()
+		}
+
+		vc.Scopes = append(vc.Scopes, scopes...)
+
+		return nil
+	case "optional":
+		checks := d.RemainingArgs()
+		if len(checks) == 0 {
+			return d.ArgErr()
+		}
+
+		vc.Optional = append(vc.Optional, checks...)
+
+		return nil
+	default:
+		return d.Errf("unknown verify option: %s", d.Val())
+	}
+
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	return nil
+}